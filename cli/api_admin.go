@@ -0,0 +1,122 @@
+package cli
+
+import (
+	"bcsbs/core"
+	"bcsbs/core/types"
+	"bcsbs/p2p"
+	"bcsbs/p2p/enode"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+// AdminAPI is the collection of administrative RPC methods exposed
+// under the "admin" namespace.
+type AdminAPI struct {
+	server *p2p.Server
+	chain  *core.BlockChain
+}
+
+// RPCCheckpoint is the JSON representation of a signed checkpoint.
+type RPCCheckpoint struct {
+	Number    hexutil.Uint64 `json:"number"`
+	Hash      common.Hash    `json:"hash"`
+	Signature hexutil.Bytes  `json:"signature"`
+}
+
+func newRPCCheckpoint(checkpoint *types.Checkpoint) *RPCCheckpoint {
+	return &RPCCheckpoint{
+		Number:    hexutil.Uint64(checkpoint.Number),
+		Hash:      checkpoint.Hash,
+		Signature: checkpoint.Signature,
+	}
+}
+
+// Peers retrieves all the information we know about each individual peer at the
+// protocol granularity.
+func (api *AdminAPI) Peers(r *http.Request, args *NoArgs, result *[]*p2p.PeerInfo) error {
+	*result = api.server.PeersInfo()
+	return nil
+}
+
+// NodeInfo retrieves all the information we know about the host node at the
+// protocol granularity.
+func (api *AdminAPI) NodeInfo(r *http.Request, args *NoArgs, result *p2p.NodeInfo) error {
+	*result = *api.server.NodeInfo()
+	return nil
+}
+
+// AddPeer requests connecting to a remote node, and also maintaining the new
+// connection at all times, even reconnecting if it is lost.
+func (api *AdminAPI) AddPeer(r *http.Request, url *string, result *bool) error {
+	node, err := enode.ParseV4(*url)
+	if err != nil {
+		return fmt.Errorf("invalid enode: %v", err)
+	}
+	api.server.AddPeer(node)
+	*result = true
+	return nil
+}
+
+// RemovePeer disconnects from a remote node if the connection exists.
+func (api *AdminAPI) RemovePeer(r *http.Request, url *string, result *bool) error {
+	node, err := enode.ParseV4(*url)
+	if err != nil {
+		return fmt.Errorf("invalid enode: %v", err)
+	}
+	api.server.RemovePeer(node)
+	*result = true
+	return nil
+}
+
+// AddTrustedPeer allows a remote node to always connect, even if slots are full.
+func (api *AdminAPI) AddTrustedPeer(r *http.Request, url *string, result *bool) error {
+	node, err := enode.ParseV4(*url)
+	if err != nil {
+		return fmt.Errorf("invalid enode: %v", err)
+	}
+	api.server.AddTrustedPeer(node)
+	*result = true
+	return nil
+}
+
+// RemoveTrustedPeer removes a remote node from the trusted peer set, but it
+// does not disconnect it automatically.
+func (api *AdminAPI) RemoveTrustedPeer(r *http.Request, url *string, result *bool) error {
+	node, err := enode.ParseV4(*url)
+	if err != nil {
+		return fmt.Errorf("invalid enode: %v", err)
+	}
+	api.server.RemoveTrustedPeer(node)
+	*result = true
+	return nil
+}
+
+// AddCheckpoint accepts a checkpoint signed by one of the checkpoint signers
+// of the chain config and propagates it to the network.
+func (api *AdminAPI) AddCheckpoint(r *http.Request, args *RPCCheckpoint, result *bool) error {
+	if args == nil {
+		return errors.New("missing checkpoint")
+	}
+	checkpoint := &types.Checkpoint{
+		Number:    uint64(args.Number),
+		Hash:      args.Hash,
+		Signature: args.Signature,
+	}
+	if err := api.chain.AddSignedCheckpoint(checkpoint); err != nil {
+		return err
+	}
+	*result = true
+	return nil
+}
+
+// Checkpoint returns the latest accepted signed checkpoint.
+func (api *AdminAPI) Checkpoint(r *http.Request, args *NoArgs, result **RPCCheckpoint) error {
+	if checkpoint := api.chain.SignedCheckpoint(); checkpoint != nil {
+		*result = newRPCCheckpoint(checkpoint)
+	}
+	return nil
+}
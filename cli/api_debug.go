@@ -0,0 +1,63 @@
+package cli
+
+import (
+	"bcsbs/core"
+	"errors"
+	"net/http"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+// DebugAPI is the collection of debugging RPC methods exposed under the
+// "debug" namespace.
+type DebugAPI struct {
+	chain *core.BlockChain
+}
+
+// RPCBadBlock is the JSON representation of a block rejected by the node.
+type RPCBadBlock struct {
+	Hash   common.Hash    `json:"hash"`
+	Block  *RPCBlock      `json:"block"`
+	RLP    hexutil.Bytes  `json:"rlp"`
+	Reason string         `json:"reason"`
+	Peer   string         `json:"peer"`
+	Time   hexutil.Uint64 `json:"time"`
+}
+
+// SetHead rewinds the head of the blockchain to a previous block.
+func (api *DebugAPI) SetHead(r *http.Request, number *hexutil.Uint64, result *bool) error {
+	if number == nil {
+		return errors.New("missing block number")
+	}
+	if err := api.chain.SetHead(uint64(*number)); err != nil {
+		return err
+	}
+	*result = true
+	return nil
+}
+
+// GetBadBlocks returns the blocks rejected by the node, the most recently
+// rejected first, with the rejection reason and the peer they came from.
+func (api *DebugAPI) GetBadBlocks(r *http.Request, args *NoArgs, result *[]*RPCBadBlock) error {
+	badBlocks := api.chain.BadBlocks()
+	results := make([]*RPCBadBlock, 0, len(badBlocks))
+	for _, bad := range badBlocks {
+		block := bad.Block()
+		blob, err := rlp.EncodeToBytes(block)
+		if err != nil {
+			return err
+		}
+		results = append(results, &RPCBadBlock{
+			Hash:   block.Hash(),
+			Block:  newRPCBlock(block),
+			RLP:    blob,
+			Reason: bad.Reason,
+			Peer:   bad.Peer,
+			Time:   hexutil.Uint64(bad.Time),
+		})
+	}
+	*result = results
+	return nil
+}
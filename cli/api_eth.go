@@ -0,0 +1,179 @@
+package cli
+
+import (
+	"bcsbs/core"
+	"bcsbs/core/types"
+	"errors"
+	"net/http"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+// EthAPI is the collection of chain query RPC methods exposed under the
+// "eth" namespace.
+type EthAPI struct {
+	chain *core.BlockChain
+}
+
+// RPCBlock is the JSON representation of a block.
+type RPCBlock struct {
+	Number       hexutil.Uint64 `json:"number"`
+	Hash         common.Hash    `json:"hash"`
+	ParentHash   common.Hash    `json:"parentHash"`
+	Miner        common.Address `json:"miner"`
+	Timestamp    hexutil.Uint64 `json:"timestamp"`
+	Nonce        hexutil.Uint64 `json:"nonce"`
+	TxHash       common.Hash    `json:"transactionsRoot"`
+	Root         common.Hash    `json:"stateRoot"`
+	ReceiptHash  common.Hash    `json:"receiptsRoot"`
+	Difficulty   *hexutil.Big   `json:"difficulty"`
+	GasLimit     hexutil.Uint64 `json:"gasLimit"`
+	GasUsed      hexutil.Uint64 `json:"gasUsed"`
+	Extra        hexutil.Bytes  `json:"extraData"`
+	Transactions []common.Hash  `json:"transactions"`
+}
+
+// RPCTransaction is the JSON representation of a transaction included in
+// the canonical chain.
+type RPCTransaction struct {
+	Hash             common.Hash     `json:"hash"`
+	Nonce            hexutil.Uint64  `json:"nonce"`
+	From             common.Address  `json:"from"`
+	To               *common.Address `json:"to"`
+	Value            *hexutil.Big    `json:"value"`
+	Input            hexutil.Bytes   `json:"input"`
+	BlockHash        common.Hash     `json:"blockHash"`
+	BlockNumber      hexutil.Uint64  `json:"blockNumber"`
+	TransactionIndex hexutil.Uint64  `json:"transactionIndex"`
+}
+
+// RPCReceipt is the JSON representation of a transaction receipt.
+type RPCReceipt struct {
+	TxHash            common.Hash     `json:"transactionHash"`
+	TransactionIndex  hexutil.Uint64  `json:"transactionIndex"`
+	BlockHash         common.Hash     `json:"blockHash"`
+	BlockNumber       hexutil.Uint64  `json:"blockNumber"`
+	From              common.Address  `json:"from"`
+	To                *common.Address `json:"to"`
+	ContractAddress   *common.Address `json:"contractAddress"`
+	Status            hexutil.Uint64  `json:"status"`
+	Error             string          `json:"error,omitempty"`
+	GasUsed           hexutil.Uint64  `json:"gasUsed"`
+	CumulativeGasUsed hexutil.Uint64  `json:"cumulativeGasUsed"`
+}
+
+func newRPCBlock(block *types.Block) *RPCBlock {
+	txs := make([]common.Hash, 0, len(block.Transactions()))
+	for _, tx := range block.Transactions() {
+		txs = append(txs, tx.Hash())
+	}
+	return &RPCBlock{
+		Number:       hexutil.Uint64(block.NumberU64()),
+		Hash:         block.Hash(),
+		ParentHash:   block.ParentHash(),
+		Miner:        block.Coinbase(),
+		Timestamp:    hexutil.Uint64(block.Time()),
+		Nonce:        hexutil.Uint64(block.Header().Nonce.Uint64()),
+		TxHash:       block.TxHash(),
+		Root:         block.Root(),
+		ReceiptHash:  block.ReceiptHash(),
+		Difficulty:   (*hexutil.Big)(block.Difficulty()),
+		GasLimit:     hexutil.Uint64(block.GasLimit()),
+		GasUsed:      hexutil.Uint64(block.GasUsed()),
+		Extra:        block.Extra(),
+		Transactions: txs,
+	}
+}
+
+// ChainId returns the chain ID used for transaction replay protection.
+func (api *EthAPI) ChainId(r *http.Request, args *NoArgs, result *hexutil.Big) error {
+	if chainID := api.chain.Config().ChainID; chainID != nil {
+		*result = hexutil.Big(*chainID)
+	}
+	return nil
+}
+
+// BlockNumber returns the number of the current head block.
+func (api *EthAPI) BlockNumber(r *http.Request, args *NoArgs, result *hexutil.Uint64) error {
+	*result = hexutil.Uint64(api.chain.CurrentBlock().NumberU64())
+	return nil
+}
+
+// GetBlockByNumber returns the canonical block with the given number, or
+// null if the chain is not that long.
+func (api *EthAPI) GetBlockByNumber(r *http.Request, number *hexutil.Uint64, result **RPCBlock) error {
+	if number == nil {
+		return errors.New("missing block number")
+	}
+	if block := api.chain.GetBlockByNumber(uint64(*number)); block != nil {
+		*result = newRPCBlock(block)
+	}
+	return nil
+}
+
+// GetBlockByHash returns the block with the given hash, which may also be a
+// block of a side chain.
+func (api *EthAPI) GetBlockByHash(r *http.Request, hash *common.Hash, result **RPCBlock) error {
+	if hash == nil {
+		return errors.New("missing block hash")
+	}
+	if block := api.chain.GetBlockByHash(*hash); block != nil {
+		*result = newRPCBlock(block)
+	}
+	return nil
+}
+
+// GetTransactionByHash returns the canonical transaction with the given hash.
+// Pending transactions are not returned.
+func (api *EthAPI) GetTransactionByHash(r *http.Request, hash *common.Hash, result **RPCTransaction) error {
+	if hash == nil {
+		return errors.New("missing transaction hash")
+	}
+	tx, blockHash, blockNumber, index := api.chain.GetTransaction(*hash)
+	if tx == nil {
+		return nil
+	}
+	*result = &RPCTransaction{
+		Hash:             tx.Hash(),
+		Nonce:            hexutil.Uint64(tx.Nonce()),
+		From:             *tx.Sender(),
+		To:               tx.To(),
+		Value:            (*hexutil.Big)(tx.Value()),
+		Input:            tx.Data(),
+		BlockHash:        blockHash,
+		BlockNumber:      hexutil.Uint64(blockNumber),
+		TransactionIndex: hexutil.Uint64(index),
+	}
+	return nil
+}
+
+// GetTransactionReceipt returns the receipt of a canonical transaction, which
+// tells whether its execution succeeded. Pending transactions have no receipt.
+func (api *EthAPI) GetTransactionReceipt(r *http.Request, hash *common.Hash, result **RPCReceipt) error {
+	if hash == nil {
+		return errors.New("missing transaction hash")
+	}
+	tx, _, _, _ := api.chain.GetTransaction(*hash)
+	receipt, blockHash, blockNumber, index := api.chain.GetReceipt(*hash)
+	if tx == nil || receipt == nil {
+		return nil
+	}
+	fields := &RPCReceipt{
+		TxHash:            *hash,
+		TransactionIndex:  hexutil.Uint64(index),
+		BlockHash:         blockHash,
+		BlockNumber:       hexutil.Uint64(blockNumber),
+		From:              *tx.Sender(),
+		To:                tx.To(),
+		Status:            hexutil.Uint64(receipt.Status),
+		Error:             receipt.Err,
+		GasUsed:           hexutil.Uint64(receipt.GasUsed),
+		CumulativeGasUsed: hexutil.Uint64(receipt.CumulativeGasUsed),
+	}
+	if receipt.ContractAddress != (common.Address{}) {
+		fields.ContractAddress = &receipt.ContractAddress
+	}
+	*result = fields
+	return nil
+}
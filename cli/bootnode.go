@@ -0,0 +1,37 @@
+package cli
+
+import (
+	"bcsbs/ethdb/memorydb"
+	"bcsbs/p2p/discover"
+	"bcsbs/p2p/enode"
+	"fmt"
+	"net"
+)
+
+func (cli *CLI) bootnode(listenAddr, nodeKeyFile string) {
+	nodeKey := loadNodeKey(nodeKeyFile)
+
+	addr, err := net.ResolveUDPAddr("udp", listenAddr)
+	if err != nil {
+		panic(err)
+	}
+	conn, err := net.ListenUDP("udp", addr)
+	if err != nil {
+		panic(err)
+	}
+
+	realaddr := conn.LocalAddr().(*net.UDPAddr)
+	ip := realaddr.IP
+	if ip.IsUnspecified() {
+		ip = net.IPv4(127, 0, 0, 1)
+	}
+	self := enode.NewV4(&nodeKey.PublicKey, ip, realaddr.Port, realaddr.Port)
+
+	db := enode.OpenDB(memorydb.New())
+	if _, err := discover.ListenUDP(conn, self, db, discover.Config{PrivateKey: nodeKey}); err != nil {
+		panic(err)
+	}
+	fmt.Println(self.URLv4())
+
+	select {}
+}
@@ -0,0 +1,239 @@
+package cli
+
+import (
+	"bcsbs/consensus"
+	"bcsbs/core"
+	"bcsbs/core/rawdb"
+	"bcsbs/core/state"
+	"bcsbs/core/types"
+	"bcsbs/ethdb"
+	"bcsbs/params"
+	"bcsbs/trie"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+const (
+	dataDir = "./my_geth"
+
+	// importBatchSize is the number of blocks inserted into the chain at once
+	// by importchain.
+	importBatchSize = 2500
+)
+
+// makeChain opens the chain database. The genesis block is written if the
+// database is empty, a nil genesis selects the stored or the default one.
+// Canonical blocks more than freezeThreshold blocks below the head are moved
+// into the ancient store.
+func makeChain(genesis *core.Genesis, freezeThreshold uint64) (ethdb.Database, consensus.Engine, *state.StateDB, *core.BlockChain, error) {
+	db, err := rawdb.NewLevelDBDatabaseWithFreezer(dataDir, 0, 0, filepath.Join(dataDir, "ancient"), "", false, freezeThreshold)
+	if err != nil {
+		return nil, nil, nil, nil, err
+	}
+	tx_trie, _ := trie.NewTxTrie(db)
+	storage_trie, _ := trie.NewStorageTrie(db)
+
+	statedb, _ := state.New(tx_trie, storage_trie)
+
+	// The engine is configured by the chain config, which is stored with
+	// the genesis block
+	bc, err := core.NewBlockChain(db, nil, nil, genesis, statedb)
+	if err != nil {
+		db.Close()
+		return nil, nil, nil, nil, err
+	}
+	return db, bc.Engine(), statedb, bc, nil
+}
+
+// mustMakeChain opens the chain with its stored genesis and exits on error.
+func mustMakeChain(freezeThreshold uint64) (ethdb.Database, *core.BlockChain) {
+	db, _, _, bc, err := makeChain(nil, freezeThreshold)
+	if err != nil {
+		fmt.Println("Failed to open chain", "dir", dataDir, "err", err)
+		os.Exit(1)
+	}
+	return db, bc
+}
+
+func (cli *CLI) initGenesis(file string) {
+	genesis, err := core.ReadGenesis(file)
+	if err != nil {
+		fmt.Println("Failed to read genesis file", "err", err)
+		os.Exit(1)
+	}
+	db, _, _, bc, err := makeChain(genesis, params.FullImmutabilityThreshold)
+	if err != nil {
+		fmt.Println("Failed to write genesis block", "err", err)
+		os.Exit(1)
+	}
+	defer db.Close()
+	defer bc.Stop()
+
+	fmt.Println("Successfully wrote genesis state", "dir", dataDir, "hash", bc.Genesis().Hash())
+}
+
+func (cli *CLI) exportChain(file string, args []string) {
+	db, bc := mustMakeChain(params.FullImmutabilityThreshold)
+	defer db.Close()
+	defer bc.Stop()
+
+	first, last := uint64(0), bc.CurrentBlock().NumberU64()
+	if len(args) > 0 {
+		first = parseBlockNumber(args[0])
+	}
+	if len(args) > 1 {
+		last = parseBlockNumber(args[1])
+	}
+
+	start := time.Now()
+	if err := exportChain(bc, file, first, last); err != nil {
+		fmt.Println("Export error", "err", err)
+		os.Exit(1)
+	}
+	fmt.Println("Export done", "file", file, "first", first, "last", last, "elapsed", time.Since(start))
+}
+
+func (cli *CLI) importChain(file string, freezeThreshold uint64) {
+	db, bc := mustMakeChain(freezeThreshold)
+	defer db.Close()
+	defer bc.Stop()
+
+	start := time.Now()
+	if err := importChain(bc, file); err != nil {
+		fmt.Println("Import error", "err", err)
+		os.Exit(1)
+	}
+	head := bc.CurrentBlock()
+	fmt.Println("Import done", "number", head.NumberU64(), "hash", head.Hash(), "elapsed", time.Since(start))
+}
+
+// exportChain writes the canonical blocks first to last into file, gzip
+// compressed if the file name ends in ".gz".
+func exportChain(bc *core.BlockChain, file string, first, last uint64) error {
+	fh, err := os.OpenFile(file, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, os.ModePerm)
+	if err != nil {
+		return err
+	}
+	if strings.HasSuffix(file, ".gz") {
+		// The gzip footer is only written on close
+		gz := gzip.NewWriter(fh)
+		if err = bc.ExportN(gz, first, last); err == nil {
+			err = gz.Close()
+		}
+	} else {
+		err = bc.ExportN(fh, first, last)
+	}
+	if cerr := fh.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// importChain inserts the blocks stored in file into the chain, in batches
+// and with full validation. Blocks already known are skipped.
+func importChain(bc *core.BlockChain, file string) error {
+	fh, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer fh.Close()
+
+	var reader io.Reader = fh
+	if strings.HasSuffix(file, ".gz") {
+		if reader, err = gzip.NewReader(reader); err != nil {
+			return err
+		}
+	}
+	stream := rlp.NewStream(reader, 0)
+
+	var (
+		blocks   = make(types.Blocks, importBatchSize)
+		imported int
+		start    = time.Now()
+	)
+	for batch := 0; ; batch++ {
+		i := 0
+		for ; i < importBatchSize; i++ {
+			block := new(types.Block)
+			if err := stream.Decode(block); errors.Is(err, io.EOF) {
+				break
+			} else if err != nil {
+				return fmt.Errorf("at block %d: %v", imported+i, err)
+			}
+			// Don't import the genesis block
+			if block.NumberU64() == 0 {
+				i--
+				continue
+			}
+			blocks[i] = block
+		}
+		if i == 0 {
+			break
+		}
+		if n, err := bc.InsertChain(blocks[:i]); err != nil {
+			return fmt.Errorf("invalid block #%d: %v", blocks[n].NumberU64(), err)
+		}
+		imported += i
+		fmt.Println("Importing blocks", "batch", batch, "imported", imported, "number", blocks[i-1].NumberU64(), "elapsed", time.Since(start))
+	}
+	return nil
+}
+
+func (cli *CLI) rewind(arg string) {
+	db, bc := mustMakeChain(params.FullImmutabilityThreshold)
+	defer db.Close()
+	defer bc.Stop()
+
+	if err := bc.SetHead(parseBlockNumber(arg)); err != nil {
+		fmt.Println("Rewind error", "err", err)
+		os.Exit(1)
+	}
+	head := bc.CurrentBlock()
+	fmt.Println("Rewind done", "number", head.NumberU64(), "hash", head.Hash())
+}
+
+func (cli *CLI) verifyChain(checkState bool) {
+	db, bc := mustMakeChain(params.FullImmutabilityThreshold)
+	defer db.Close()
+	defer bc.Stop()
+
+	start := time.Now()
+	stats, err := bc.VerifyChain(checkState)
+	fmt.Println("Verified chain", "blocks", stats.Blocks, "txs", stats.Txs, "accounts", stats.Accounts, "slots", stats.Slots, "elapsed", time.Since(start))
+	if err != nil {
+		fmt.Println("Chain verification failed", "err", err)
+		os.Exit(1)
+	}
+	head := bc.CurrentBlock()
+	fmt.Println("Chain is consistent", "number", head.NumberU64(), "hash", head.Hash())
+}
+
+func (cli *CLI) badBlocks() {
+	db, bc := mustMakeChain(params.FullImmutabilityThreshold)
+	defer db.Close()
+	defer bc.Stop()
+
+	badBlocks := bc.BadBlocks()
+	for _, bad := range badBlocks {
+		fmt.Println("Bad block", "number", bad.Header.Number, "hash", bad.Header.Hash(), "peer", bad.Peer,
+			"time", time.Unix(int64(bad.Time), 0).Format(time.RFC3339), "reason", bad.Reason)
+	}
+	fmt.Println("Listed bad blocks", "count", len(badBlocks))
+}
+
+func parseBlockNumber(arg string) uint64 {
+	number, err := strconv.ParseUint(arg, 10, 64)
+	if err != nil {
+		panic(fmt.Sprintf("block number: %s err: %s", arg, err))
+	}
+	return number
+}
@@ -1,6 +1,7 @@
 package cli
 
 import (
+	"bcsbs/params"
 	"flag"
 	"fmt"
 	"os"
@@ -10,11 +11,19 @@ type CLI struct{}
 
 func (cli *CLI) printUsage() {
 	fmt.Println("Usage:")
-	fmt.Println("  startserver -address ADDRESS - Start Server")
-	fmt.Println("  initcontract -address ADDRESS -key KEY -amount AMOUNT - Create contract")
-	fmt.Println("  sendtx -address ADDRESS -key KEY -amount AMOUNT - Send coin")
-	fmt.Println("  move -address ADDRESS -x X -y Y -key KEY - Send position")
+	fmt.Println("  init GENESIS - Initialise the chain database with the genesis block of a JSON file")
+	fmt.Println("  startserver -address ADDRESS [-genesis FILE] [-listen ADDR] [-adminrpc ADDR] [-nodekey FILE] [-maxpeers N] [-peers ENODE,...] [-trusted ENODE,...] [-bootnodes ENODE,...] [-nodiscover] [-freezethreshold N] - Start Server")
+	fmt.Println("  bootnode [-addr ADDR] [-nodekey FILE] - Start a discovery-only bootstrap node")
+	fmt.Println("  initcontract -address ADDRESS -key KEY -amount AMOUNT [-chainid ID] - Create contract")
+	fmt.Println("  sendtx -address ADDRESS -key KEY -amount AMOUNT [-chainid ID] - Send coin")
+	fmt.Println("  move -address ADDRESS -x X -y Y -key KEY [-chainid ID] - Send position")
 	fmt.Println("  createwallet -dir DIR - Generates a new key-pair and saves it into the wallet file")
+	fmt.Println("  exportchain FILE [FIRST] [LAST] - Export the chain to a file, gzip compressed if FILE ends in .gz")
+	fmt.Println("  importchain [-freezethreshold N] FILE - Import blocks from a file")
+	fmt.Println("  rewind NUMBER - Rewind the chain and its state to the block NUMBER")
+	fmt.Println("  verifychain [-state] - Check the integrity of the chain database, -state also re-executes all blocks")
+	fmt.Println("  badblocks - List the blocks rejected by the node with the reason and the peer they came from")
+	fmt.Println("  signcheckpoint -key KEY -number NUMBER -hash HASH [-chainid ID] - Sign a checkpoint for admin_addCheckpoint")
 }
 
 func (cli *CLI) validateArgs() {
@@ -27,31 +36,69 @@ func (cli *CLI) validateArgs() {
 func (cli *CLI) Run() {
 	cli.validateArgs()
 
+	initCmd := flag.NewFlagSet("init", flag.ExitOnError)
 	startServerCmd := flag.NewFlagSet("startserver", flag.ExitOnError)
 	initContractCmd := flag.NewFlagSet("initcontract", flag.ExitOnError)
 	sendTxCmd := flag.NewFlagSet("sendtx", flag.ExitOnError)
 	moveCmd := flag.NewFlagSet("move", flag.ExitOnError)
 	createWalletCmd := flag.NewFlagSet("createwallet", flag.ExitOnError)
+	bootnodeCmd := flag.NewFlagSet("bootnode", flag.ExitOnError)
+	exportChainCmd := flag.NewFlagSet("exportchain", flag.ExitOnError)
+	importChainCmd := flag.NewFlagSet("importchain", flag.ExitOnError)
+	rewindCmd := flag.NewFlagSet("rewind", flag.ExitOnError)
+	verifyChainCmd := flag.NewFlagSet("verifychain", flag.ExitOnError)
+	badBlocksCmd := flag.NewFlagSet("badblocks", flag.ExitOnError)
+	signCheckpointCmd := flag.NewFlagSet("signcheckpoint", flag.ExitOnError)
+
+	importChainFreezeThreshold := importChainCmd.Uint64("freezethreshold", params.FullImmutabilityThreshold, "the number of recent blocks kept out of the ancient store")
+
+	verifyChainState := verifyChainCmd.Bool("state", false, "re-execute all blocks and compare the result with the stored state")
 
 	startServerAddress := startServerCmd.String("address", "", "The address Coinbase")
+	startServerGenesis := startServerCmd.String("genesis", "", "the genesis JSON file the stored chain must match")
+	startServerListen := startServerCmd.String("listen", ":30303", "the p2p listen address")
+	startServerAdminRPC := startServerCmd.String("adminrpc", "127.0.0.1:1338", "the listen address of the admin and debug RPC APIs")
+	startServerNodeKey := startServerCmd.String("nodekey", "./nodekey", "the p2p node key file")
+	startServerMaxPeers := startServerCmd.Int("maxpeers", 25, "the maximum number of network peers")
+	startServerPeers := startServerCmd.String("peers", "", "the comma separated static peer enode URLs")
+	startServerTrusted := startServerCmd.String("trusted", "", "the comma separated trusted peer enode URLs")
+	startServerBootnodes := startServerCmd.String("bootnodes", "", "the comma separated bootstrap node enode URLs")
+	startServerNoDiscover := startServerCmd.Bool("nodiscover", false, "disable the peer discovery mechanism")
+	startServerFreezeThreshold := startServerCmd.Uint64("freezethreshold", params.FullImmutabilityThreshold, "the number of recent blocks kept out of the ancient store")
 
 	initContractAddress := initContractCmd.String("address", "", "The address player")
 	initContractKey := initContractCmd.String("key", "", "the private key")
 	initContractAmount := initContractCmd.Int("amount", 0, "the bet")
+	initContractChainID := initContractCmd.Int64("chainid", params.DefaultChainConfig.ChainID.Int64(), "the chain ID of the network")
 
 	sendTxAddress := sendTxCmd.String("address", "", "The To player")
 	sendTxKey := sendTxCmd.String("key", "", "the private key")
 	sendTxAmount := sendTxCmd.Int("amount", 0, "the amount of coun")
+	sendTxChainID := sendTxCmd.Int64("chainid", params.DefaultChainConfig.ChainID.Int64(), "the chain ID of the network")
 
 	moveAddress := moveCmd.String("address", "", "the adress contract")
 	moveX := moveCmd.Int("x", -1, "the coordinat X")
 	moveY := moveCmd.Int("y", -1, "the coordinat Y")
 	moveKey := moveCmd.String("key", "", "the private key")
+	moveChainID := moveCmd.Int64("chainid", params.DefaultChainConfig.ChainID.Int64(), "the chain ID of the network")
 
 	createwalletDir := createWalletCmd.String("dir", "./", "the dir save file")
 	createwalletPassphrase := createWalletCmd.String("passphrase", "", "the crypto phrase")
 
+	signCheckpointKey := signCheckpointCmd.String("key", "", "the private key of the checkpoint signer")
+	signCheckpointNumber := signCheckpointCmd.Uint64("number", 0, "the number of the checkpointed block")
+	signCheckpointHash := signCheckpointCmd.String("hash", "", "the hash of the checkpointed block")
+	signCheckpointChainID := signCheckpointCmd.Int64("chainid", params.DefaultChainConfig.ChainID.Int64(), "the chain ID of the network")
+
+	bootnodeAddr := bootnodeCmd.String("addr", ":30301", "the UDP listen address")
+	bootnodeNodeKey := bootnodeCmd.String("nodekey", "./bootnode.key", "the node key file")
+
 	switch os.Args[1] {
+	case "init":
+		err := initCmd.Parse(os.Args[2:])
+		if err != nil {
+			panic(err)
+		}
 	case "startserver":
 		err := startServerCmd.Parse(os.Args[2:])
 		if err != nil {
@@ -77,29 +124,73 @@ func (cli *CLI) Run() {
 		if err != nil {
 			panic(err)
 		}
+	case "bootnode":
+		err := bootnodeCmd.Parse(os.Args[2:])
+		if err != nil {
+			panic(err)
+		}
+	case "exportchain":
+		err := exportChainCmd.Parse(os.Args[2:])
+		if err != nil {
+			panic(err)
+		}
+	case "importchain":
+		err := importChainCmd.Parse(os.Args[2:])
+		if err != nil {
+			panic(err)
+		}
+	case "rewind":
+		err := rewindCmd.Parse(os.Args[2:])
+		if err != nil {
+			panic(err)
+		}
+	case "verifychain":
+		err := verifyChainCmd.Parse(os.Args[2:])
+		if err != nil {
+			panic(err)
+		}
+	case "badblocks":
+		err := badBlocksCmd.Parse(os.Args[2:])
+		if err != nil {
+			panic(err)
+		}
+	case "signcheckpoint":
+		err := signCheckpointCmd.Parse(os.Args[2:])
+		if err != nil {
+			panic(err)
+		}
 
 	}
 
-	if startServerCmd.Parsed() {
+	if initCmd.Parsed() {
+		if initCmd.NArg() != 1 {
+			cli.printUsage()
+			os.Exit(1)
+		}
+		cli.initGenesis(initCmd.Arg(0))
+
+	} else if startServerCmd.Parsed() {
 		if *startServerAddress == "" {
 			startServerCmd.Usage()
 			os.Exit(1)
 		}
-		cli.startServer(*startServerAddress)
+		cli.startServer(*startServerAddress, *startServerGenesis, *startServerListen, *startServerAdminRPC, *startServerNodeKey,
+			splitList(*startServerPeers), splitList(*startServerTrusted), splitList(*startServerBootnodes),
+			*startServerMaxPeers, *startServerNoDiscover, *startServerFreezeThreshold)
 
 	} else if initContractCmd.Parsed() {
 		if *initContractAddress == "" || *initContractKey == "" || *initContractAmount < 0 {
 			initContractCmd.Usage()
 			os.Exit(1)
 		}
-		cli.initContract(*initContractAddress, *initContractKey, *initContractAmount)
+		cli.initContract(*initContractAddress, *initContractKey, *initContractAmount, *initContractChainID)
 
 	} else if sendTxCmd.Parsed() {
 		if *sendTxAddress == "" || *sendTxKey == "" || *sendTxAmount < 0 {
 			sendTxCmd.Usage()
 			os.Exit(1)
 		}
-		cli.sendTx(*sendTxAddress, *sendTxKey, *sendTxAmount)
+		cli.sendTx(*sendTxAddress, *sendTxKey, *sendTxAmount, *sendTxChainID)
 
 	} else if moveCmd.Parsed() {
 		if *moveAddress == "" || *moveX < 0 || *moveX > 2 ||
@@ -107,11 +198,56 @@ func (cli *CLI) Run() {
 			moveCmd.Usage()
 			os.Exit(1)
 		}
-		cli.move(*moveAddress, *moveX, *moveY, *moveKey)
+		cli.move(*moveAddress, *moveX, *moveY, *moveKey, *moveChainID)
 
 	} else if createWalletCmd.Parsed() {
 		cli.createWallet(*createwalletDir, *createwalletPassphrase)
 
+	} else if bootnodeCmd.Parsed() {
+		cli.bootnode(*bootnodeAddr, *bootnodeNodeKey)
+
+	} else if exportChainCmd.Parsed() {
+		if exportChainCmd.NArg() < 1 || exportChainCmd.NArg() > 3 {
+			cli.printUsage()
+			os.Exit(1)
+		}
+		cli.exportChain(exportChainCmd.Arg(0), exportChainCmd.Args()[1:])
+
+	} else if importChainCmd.Parsed() {
+		if importChainCmd.NArg() != 1 {
+			cli.printUsage()
+			os.Exit(1)
+		}
+		cli.importChain(importChainCmd.Arg(0), *importChainFreezeThreshold)
+
+	} else if rewindCmd.Parsed() {
+		if rewindCmd.NArg() != 1 {
+			cli.printUsage()
+			os.Exit(1)
+		}
+		cli.rewind(rewindCmd.Arg(0))
+
+	} else if verifyChainCmd.Parsed() {
+		if verifyChainCmd.NArg() != 0 {
+			cli.printUsage()
+			os.Exit(1)
+		}
+		cli.verifyChain(*verifyChainState)
+
+	} else if badBlocksCmd.Parsed() {
+		if badBlocksCmd.NArg() != 0 {
+			cli.printUsage()
+			os.Exit(1)
+		}
+		cli.badBlocks()
+
+	} else if signCheckpointCmd.Parsed() {
+		if *signCheckpointKey == "" || *signCheckpointNumber == 0 || *signCheckpointHash == "" {
+			signCheckpointCmd.Usage()
+			os.Exit(1)
+		}
+		cli.signCheckpoint(*signCheckpointKey, *signCheckpointNumber, *signCheckpointHash, *signCheckpointChainID)
+
 	} else {
 		cli.printUsage()
 		os.Exit(1)
@@ -7,7 +7,7 @@ import (
 	"github.com/ethereum/go-ethereum/crypto"
 )
 
-func (cli *CLI) initContract(address, key string, amount int) {
+func (cli *CLI) initContract(address, key string, amount int, chainID int64) {
 	code := initCode(address)
 
 	private_key, err := crypto.HexToECDSA(key)
@@ -15,8 +15,9 @@ func (cli *CLI) initContract(address, key string, amount int) {
 		panic(err)
 	}
 
-	tx := types.NewContractCreation(0, big.NewInt(int64(amount)), code)
-	tx_sign, err := types.SignTx(tx, types.HomesteadSigner{}, private_key)
+	nonce := getNonce(serverURL, crypto.PubkeyToAddress(private_key.PublicKey))
+	tx := types.NewContractCreation(nonce, big.NewInt(int64(amount)), code)
+	tx_sign, err := types.SignTx(tx, types.LatestSignerForChainID(big.NewInt(chainID)), private_key)
 	if err != nil {
 		panic(err)
 	}
@@ -26,5 +27,5 @@ func (cli *CLI) initContract(address, key string, amount int) {
 		panic(err)
 	}
 
-	send("http://93.157.234.29:1337/delivery", sign)
+	send(serverURL, sign)
 }
@@ -8,19 +8,20 @@ import (
 	"github.com/ethereum/go-ethereum/crypto"
 )
 
-func (cli *CLI) move(address string, x, y int, key string) {
+func (cli *CLI) move(address string, x, y int, key string, chainID int64) {
 	addr_contract := common.HexToAddress(address)
 	position := x*3 + y + 3
 	code := moveCode(position)
 
-	tx := types.NewTransaction(0, addr_contract, big.NewInt(0), code)
-
 	private_key, err := crypto.HexToECDSA(key)
 	if err != nil {
 		panic(err)
 	}
 
-	tx_sign, err := types.SignTx(tx, types.HomesteadSigner{}, private_key)
+	nonce := getNonce(serverURL, crypto.PubkeyToAddress(private_key.PublicKey))
+	tx := types.NewTransaction(nonce, addr_contract, big.NewInt(0), code)
+
+	tx_sign, err := types.SignTx(tx, types.LatestSignerForChainID(big.NewInt(chainID)), private_key)
 	if err != nil {
 		panic(err)
 	}
@@ -30,5 +31,5 @@ func (cli *CLI) move(address string, x, y int, key string) {
 		panic(err)
 	}
 
-	send("http://93.157.234.29:1337/delivery", sign)
+	send(serverURL, sign)
 }
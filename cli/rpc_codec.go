@@ -0,0 +1,38 @@
+package cli
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/rpc"
+	"github.com/gorilla/rpc/json"
+)
+
+// ethCodec accepts Ethereum style method names such as "admin_peers" and
+// maps them onto the gorilla service method "admin.Peers".
+type ethCodec struct {
+	rpc.Codec
+}
+
+func newEthCodec() rpc.Codec {
+	return &ethCodec{Codec: json.NewCodec()}
+}
+
+func (c *ethCodec) NewRequest(r *http.Request) rpc.CodecRequest {
+	return &ethCodecRequest{CodecRequest: c.Codec.NewRequest(r)}
+}
+
+type ethCodecRequest struct {
+	rpc.CodecRequest
+}
+
+func (r *ethCodecRequest) Method() (string, error) {
+	method, err := r.CodecRequest.Method()
+	if err != nil || strings.Contains(method, ".") {
+		return method, err
+	}
+	if service, name, ok := strings.Cut(method, "_"); ok && name != "" {
+		return service + "." + strings.ToUpper(name[:1]) + name[1:], nil
+	}
+	return method, nil
+}
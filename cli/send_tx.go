@@ -8,17 +8,18 @@ import (
 	"github.com/ethereum/go-ethereum/crypto"
 )
 
-func (cli *CLI) sendTx(address, key string, amount int) {
+func (cli *CLI) sendTx(address, key string, amount int, chainID int64) {
 	addr_contract := common.HexToAddress(address)
 
-	tx := types.NewTransaction(0, addr_contract, big.NewInt(int64(amount)), []byte{})
-
 	private_key, err := crypto.HexToECDSA(key)
 	if err != nil {
 		panic(err)
 	}
 
-	tx_sign, err := types.SignTx(tx, types.HomesteadSigner{}, private_key)
+	nonce := getNonce(serverURL, crypto.PubkeyToAddress(private_key.PublicKey))
+	tx := types.NewTransaction(nonce, addr_contract, big.NewInt(int64(amount)), []byte{})
+
+	tx_sign, err := types.SignTx(tx, types.LatestSignerForChainID(big.NewInt(chainID)), private_key)
 	if err != nil {
 		panic(err)
 	}
@@ -28,5 +29,5 @@ func (cli *CLI) sendTx(address, key string, amount int) {
 		panic(err)
 	}
 
-	send("http://93.157.234.29:1337/delivery", sign)
+	send(serverURL, sign)
 }
@@ -0,0 +1,33 @@
+package cli
+
+import (
+	"bcsbs/core/types"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"os"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// signCheckpoint signs the checkpoint of the block with the given number and
+// hash and prints it in the form accepted by admin_addCheckpoint.
+func (cli *CLI) signCheckpoint(key string, number uint64, hash string, chainID int64) {
+	privateKey, err := crypto.HexToECDSA(key)
+	if err != nil {
+		fmt.Println("Invalid private key", "err", err)
+		os.Exit(1)
+	}
+	checkpoint, err := types.SignCheckpoint(&types.Checkpoint{Number: number, Hash: common.HexToHash(hash)}, big.NewInt(chainID), privateKey)
+	if err != nil {
+		fmt.Println("Failed to sign checkpoint", "err", err)
+		os.Exit(1)
+	}
+	out, err := json.Marshal(newRPCCheckpoint(checkpoint))
+	if err != nil {
+		panic(err)
+	}
+	fmt.Println("Signed checkpoint", "number", number, "hash", checkpoint.Hash, "signer", crypto.PubkeyToAddress(privateKey.PublicKey))
+	fmt.Println(string(out))
+}
@@ -1,25 +1,23 @@
 package cli
 
 import (
-	"bcsbs/consensus/ethash"
 	"bcsbs/core"
-	"bcsbs/core/rawdb"
 	"bcsbs/core/state"
 	"bcsbs/core/types"
 	"bcsbs/core/vm"
+	"bcsbs/eth"
 	"bcsbs/miner"
-	"bcsbs/trie"
+	"bcsbs/p2p"
 	"fmt"
-	"math"
 	"math/big"
 	"net/http"
+	"os"
 	"time"
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/gorilla/mux"
 	"github.com/gorilla/rpc"
-	"github.com/gorilla/rpc/json"
 	"github.com/holiman/uint256"
 )
 
@@ -31,9 +29,19 @@ type Response struct {
 	Result string
 }
 
+type NoArgs struct{}
+
+type NonceArgs struct {
+	Address common.Address
+}
+
 type Server struct {
+	bc      *core.BlockChain
 	pool    *core.TxPool
 	statedb *state.StateDB
+
+	handler   *eth.Handler
+	p2pServer *p2p.Server
 }
 
 type Backend struct {
@@ -93,27 +101,13 @@ func show(addr_contract common.Address, statedb *state.StateDB) string {
 	return out
 }
 
-func NewServer(addr common.Address) *Server {
-
-	engine := &ethash.Ethash{
-		Target: big.NewInt(int64(math.Pow(16, 3))),
+func NewServer(addr common.Address, p2pConfig p2p.Config, genesis *core.Genesis, freezeThreshold uint64) (*Server, error) {
+	db, engine, statedb, bc, err := makeChain(genesis, freezeThreshold)
+	if err != nil {
+		return nil, err
 	}
 
-	db, _ := rawdb.NewLevelDBDatabase("./my_geth", 0, 0, "", false)
-	tx_trie, _ := trie.NewTxTrie(db)
-	storage_trie, _ := trie.NewStorageTrie(db)
-	blockCtx := core.NewEVMBlockContext()
-
-	statedb, _ := state.New(tx_trie, storage_trie, &blockCtx)
-
-	var genesis *core.Genesis
-	if rawdb.ReadHeadBlockHash(db) == (common.Hash{}) {
-		genesis = core.DefaultGenesisBlock()
-	}
-
-	bc := core.NewBlockChain(db, engine, genesis, statedb)
-
-	signer := types.HomesteadSigner{}
+	signer := types.LatestSigner(bc.Config())
 	pool := core.NewTxPool(bc, signer)
 
 	backend := &Backend{
@@ -122,50 +116,131 @@ func NewServer(addr common.Address) *Server {
 	}
 	miner := miner.New(backend, engine)
 
+	handler := eth.NewHandler(&eth.Config{
+		NetworkID: eth.DefaultNetworkID,
+		Chain:     bc,
+		TxPool:    pool,
+		Engine:    engine,
+		Miner:     miner,
+	})
+	handler.Start()
+	p2pConfig.Name = "bcsbs"
+	p2pConfig.NodeDatabase = db
+	p2pConfig.Protocols = handler.Protocols()
+	p2pServer := &p2p.Server{Config: p2pConfig}
+	if err := p2pServer.Start(); err != nil {
+		return nil, err
+	}
+
 	miner.Start(addr)
 
 	server := &Server{
+		bc:      bc,
 		pool:    pool,
 		statedb: statedb,
+
+		handler:   handler,
+		p2pServer: p2pServer,
 	}
 
-	return server
+	return server, nil
 }
 
 func (s *Server) SendRawTransaction(r *http.Request, args *TxArgs, result *Response) error {
 	sign := common.Hex2Bytes(args.Sign)
 	tx := new(types.Transaction)
-	tx.UnmarshalBinary(sign)
-	tx.SetNonce(s.statedb.GetNonce(*tx.Sender()))
-
-	s.pool.AddLocalAndUpdate(tx)
+	if err := tx.UnmarshalBinary(sign); err != nil {
+		return err
+	}
+	if err := s.pool.AddLocalAndUpdate(tx); err != nil {
+		return err
+	}
 
 	time.Sleep(time.Second * 3)
 	if len(tx.Data()) < 1 {
 		*result = Response{Result: tx.Text()}
 	} else if tx.Data()[0] == byte(vm.INIT) {
-		AddrContract := crypto.CreateAddress(*tx.Sender(), s.statedb.GetNonce(*tx.Sender())-1)
-		*result = Response{Result: fmt.Sprintf("AddrContract: %s", AddrContract)}
+		AddrContract := crypto.CreateAddress(*tx.Sender(), tx.Nonce())
+		*result = Response{Result: fmt.Sprintf("TxHash: %s\nAddrContract: %s", tx.Hash(), AddrContract)}
 	} else if tx.Data()[0] == byte(vm.MOVE) {
-		*result = Response{Result: show(*tx.To(), s.statedb)}
+		*result = Response{Result: fmt.Sprintf("TxHash: %s\n", tx.Hash()) + show(*tx.To(), s.statedb)}
 	}
 	return nil
 }
 
-func (cli *CLI) startServer(address string) {
-	addr := common.HexToAddress(address)
-
-	rpcServer := rpc.NewServer()
+// GetNonce returns the nonce the next transaction of the address has to be
+// signed with.
+func (s *Server) GetNonce(r *http.Request, args *NonceArgs, result *uint64) error {
+	*result = s.pool.Nonce(args.Address)
+	return nil
+}
 
-	rpcServer.RegisterCodec(json.NewCodec(), "application/json")
-	rpcServer.RegisterCodec(json.NewCodec(), "application/json;charset=UTF-8")
+func (s *Server) Syncing(r *http.Request, args *NoArgs, result *Response) error {
+	progress := s.handler.Downloader().Progress()
+	*result = Response{Result: fmt.Sprintf("Syncing: %t\n", s.handler.Downloader().Synchronising()) +
+		fmt.Sprintf("\tStartingBlock: %d\n", progress.StartingBlock) +
+		fmt.Sprintf("\tCurrentBlock: %d\n", progress.CurrentBlock) +
+		fmt.Sprintf("\tHighestBlock: %d\n", progress.HighestBlock)}
+	return nil
+}
 
-	server := NewServer(addr)
+func newRPCServer() *rpc.Server {
+	rpcServer := rpc.NewServer()
 
-	rpcServer.RegisterService(server, "server")
+	rpcServer.RegisterCodec(newEthCodec(), "application/json")
+	rpcServer.RegisterCodec(newEthCodec(), "application/json;charset=UTF-8")
+	return rpcServer
+}
 
+func serveRPC(addr string, rpcServer *rpc.Server) error {
 	router := mux.NewRouter()
 	router.Handle("/delivery", rpcServer)
-	http.ListenAndServe(":1337", router)
+	return http.ListenAndServe(addr, router)
+}
+
+func (cli *CLI) startServer(address, genesisFile, listenAddr, adminAddr, nodeKeyFile string, peers, trusted, bootnodes []string, maxPeers int, noDiscovery bool, freezeThreshold uint64) {
+	addr := common.HexToAddress(address)
+
+	var genesis *core.Genesis
+	if genesisFile != "" {
+		var err error
+		if genesis, err = core.ReadGenesis(genesisFile); err != nil {
+			fmt.Println("Failed to read genesis file", "err", err)
+			os.Exit(1)
+		}
+	}
+
+	p2pConfig := p2p.Config{
+		PrivateKey:     loadNodeKey(nodeKeyFile),
+		MaxPeers:       maxPeers,
+		ListenAddr:     listenAddr,
+		StaticNodes:    parseNodes(peers),
+		TrustedNodes:   parseNodes(trusted),
+		BootstrapNodes: parseNodes(bootnodes),
+		NoDiscovery:    noDiscovery,
+	}
 
+	server, err := NewServer(addr, p2pConfig, genesis, freezeThreshold)
+	if err != nil {
+		fmt.Println("Failed to start server", "err", err)
+		os.Exit(1)
+	}
+
+	// The admin and debug APIs manage the peers and can rewind the chain, they
+	// are served on a listener of their own, bound to localhost unless
+	// configured otherwise
+	adminServer := newRPCServer()
+	adminServer.RegisterService(&AdminAPI{server: server.p2pServer, chain: server.bc}, "admin")
+	adminServer.RegisterService(&DebugAPI{chain: server.bc}, "debug")
+	go func() {
+		if err := serveRPC(adminAddr, adminServer); err != nil {
+			fmt.Println("Admin RPC server failed", "addr", adminAddr, "err", err)
+			os.Exit(1)
+		}
+	}()
+
+	rpcServer := newRPCServer()
+	rpcServer.RegisterService(server, "server")
+	rpcServer.RegisterService(&EthAPI{chain: server.bc}, "eth")
+	serveRPC(":1337", rpcServer)
 }
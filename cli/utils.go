@@ -2,13 +2,18 @@ package cli
 
 import (
 	"bcsbs/core/vm"
+	"bcsbs/p2p/enode"
 	"bytes"
+	"crypto/ecdsa"
 	"encoding/hex"
 	"fmt"
 	"net/http"
+	"os"
 	"strconv"
+	"strings"
 
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/gorilla/rpc/json"
 )
 
@@ -77,13 +82,30 @@ func moveCode(position int) []byte {
 	return code_hex
 }
 
-func send(url string, sign []byte) {
+// serverURL is the RPC endpoint of the node the transaction commands talk to.
+const serverURL = "http://93.157.234.29:1337/delivery"
 
+func send(url string, sign []byte) {
 	txArgs := &TxArgs{
 		Sign: common.Bytes2Hex(sign),
 	}
 
-	message, err := json.EncodeClientRequest("server.SendRawTransaction", txArgs)
+	var result Response
+	call(url, "server.SendRawTransaction", txArgs, &result)
+
+	fmt.Println(result)
+}
+
+// getNonce asks the server for the nonce the next transaction of addr has
+// to be signed with.
+func getNonce(url string, addr common.Address) uint64 {
+	var nonce uint64
+	call(url, "server.GetNonce", &NonceArgs{Address: addr}, &nonce)
+	return nonce
+}
+
+func call(url, method string, args, result interface{}) {
+	message, err := json.EncodeClientRequest(method, args)
 	if err != nil {
 		panic(err)
 	}
@@ -101,11 +123,50 @@ func send(url string, sign []byte) {
 
 	defer resp.Body.Close()
 
-	var result Response
-	err = json.DecodeClientResponse(resp.Body, &result)
+	err = json.DecodeClientResponse(resp.Body, result)
 	if err != nil {
 		panic(err)
 	}
+}
 
-	fmt.Println(result)
+func splitList(list string) []string {
+	var out []string
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			out = append(out, item)
+		}
+	}
+	return out
+}
+
+// loadNodeKey reads the node private key from file, generating and
+// saving a new one if the file does not exist.
+func loadNodeKey(file string) *ecdsa.PrivateKey {
+	if _, err := os.Stat(file); err == nil {
+		key, err := crypto.LoadECDSA(file)
+		if err != nil {
+			panic(fmt.Sprintf("file: %s err: %s", file, err))
+		}
+		return key
+	}
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		panic(err)
+	}
+	if err := crypto.SaveECDSA(file, key); err != nil {
+		panic(err)
+	}
+	return key
+}
+
+func parseNodes(urls []string) []*enode.Node {
+	nodes := make([]*enode.Node, 0, len(urls))
+	for _, url := range urls {
+		node, err := enode.ParseV4(url)
+		if err != nil {
+			panic(fmt.Sprintf("url: %s err: %s", url, err))
+		}
+		nodes = append(nodes, node)
+	}
+	return nodes
 }
@@ -3,11 +3,15 @@ package consensus
 import (
 	"bcsbs/core/state"
 	"bcsbs/core/types"
+	"math/big"
 
 	"github.com/ethereum/go-ethereum/common"
 )
 
 type Engine interface {
+	// VerifyHeader checks whether a header conforms to the consensus rules.
+	// ErrFutureBlock is returned for a valid header whose timestamp is
+	// slightly ahead of the local clock.
 	VerifyHeader(parent, header *types.Header, seal bool) error
 
 	Seal(block *types.Block, results chan<- *types.Block, stop <-chan struct{}) error
@@ -16,7 +20,11 @@ type Engine interface {
 
 	Prepare(parent, header *types.Header) error
 
+	// CalcDifficulty returns the difficulty of a block built on parent, which
+	// adds up to the total difficulty used for fork choice.
+	CalcDifficulty(parent *types.Header) *big.Int
+
 	Finalize(header *types.Header, state *state.StateDB, txs []*types.Transaction)
 
-	FinalizeAndAssemble(header *types.Header, state *state.StateDB, txs []*types.Transaction) (*types.Block, error)
+	FinalizeAndAssemble(header *types.Header, state *state.StateDB, txs []*types.Transaction, receipts []*types.Receipt) (*types.Block, error)
 }
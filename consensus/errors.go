@@ -3,5 +3,12 @@ package consensus
 import "errors"
 
 var (
+	ErrUnknownAncestor = errors.New("unknown ancestor")
+
 	ErrInvalidNumber = errors.New("invalid block number")
+
+	// ErrFutureBlock is returned when a block's timestamp is in the future
+	// according to the current node, but not too far to be imported once
+	// its time arrives.
+	ErrFutureBlock = errors.New("block in the future")
 )
@@ -2,10 +2,14 @@ package ethash
 
 import (
 	"bcsbs/consensus"
+	"bcsbs/consensus/misc"
 	"bcsbs/core/state"
 	"bcsbs/core/types"
+	"bcsbs/params"
 	"errors"
+	"fmt"
 	"math/big"
+	"time"
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/rlp"
@@ -13,13 +17,11 @@ import (
 )
 
 var (
-	FrontierBlockReward = big.NewInt(5e+18)
-)
-
-var (
-	errOlderBlockTime = errors.New("timestamp older than parent")
-	errInvalidPoW     = errors.New("invalid proof-of-work")
-	errParentHash     = errors.New("invalid parentHash")
+	errOlderBlockTime    = errors.New("timestamp older than parent")
+	errFarFutureBlock    = errors.New("timestamp too far in the future")
+	errInvalidPoW        = errors.New("invalid proof-of-work")
+	errParentHash        = errors.New("invalid parentHash")
+	errInvalidDifficulty = errors.New("non-positive difficulty")
 )
 
 func (ethash *Ethash) SealHash(header *types.Header) (hash common.Hash) {
@@ -30,6 +32,12 @@ func (ethash *Ethash) SealHash(header *types.Header) (hash common.Hash) {
 		header.TxHash,
 		header.Number,
 		header.Time,
+		header.Root,
+		header.ReceiptHash,
+		header.Difficulty,
+		header.GasLimit,
+		header.GasUsed,
+		header.Extra,
 	}
 
 	rlp.Encode(hasher, enc)
@@ -54,12 +62,35 @@ func (ethash *Ethash) verifyHeader(header, parent *types.Header, seal bool) erro
 		return errParentHash
 	}
 
+	if uint64(len(header.Extra)) > params.MaximumExtraDataSize {
+		return fmt.Errorf("extra-data too long: %d > %d", len(header.Extra), params.MaximumExtraDataSize)
+	}
+
+	if header.Difficulty == nil || header.Difficulty.Sign() <= 0 {
+		return errInvalidDifficulty
+	}
+	if expected := ethash.CalcDifficulty(parent); header.Difficulty.Cmp(expected) != 0 {
+		return fmt.Errorf("invalid difficulty: have %v, want %v", header.Difficulty, expected)
+	}
+
+	if err := misc.VerifyGaslimit(parent, header); err != nil {
+		return err
+	}
+
 	if seal {
 		if err := ethash.verifySeal(header); err != nil {
 			return err
 		}
 	}
 
+	// Blocks slightly ahead of the local clock are otherwise valid, the
+	// caller holds them back until their time arrives
+	if now := uint64(time.Now().Unix()); header.Time > now {
+		if limit := ethash.config.FutureBlockTimeLimit(); header.Time > now+limit {
+			return fmt.Errorf("%w: %d seconds ahead, limit %d", errFarFutureBlock, header.Time-now, limit)
+		}
+		return consensus.ErrFutureBlock
+	}
 	return nil
 }
 
@@ -68,7 +99,7 @@ func (ethash *Ethash) verifySeal(header *types.Header) error {
 	var result []byte
 
 	result = hashimotoFull(ethash.SealHash(header).Bytes(), header.Nonce.Uint64())
-	target := new(big.Int).Div(two256, ethash.Target)
+	target := new(big.Int).Div(two256, header.Difficulty)
 
 	if new(big.Int).SetBytes(result).Cmp(target) > 0 {
 		return errInvalidPoW
@@ -78,21 +109,32 @@ func (ethash *Ethash) verifySeal(header *types.Header) error {
 }
 
 func (ethash *Ethash) Prepare(parent, header *types.Header) error {
+	header.Difficulty = ethash.CalcDifficulty(parent)
 	return nil
 }
 
+// CalcDifficulty returns the difficulty of the child of parent, which is the
+// fixed difficulty of the engine config.
+func (ethash *Ethash) CalcDifficulty(parent *types.Header) *big.Int {
+	return new(big.Int).Set(ethash.config.Difficulty)
+}
+
 func (ethash *Ethash) Finalize(header *types.Header, state *state.StateDB, txs []*types.Transaction) {
-	accumulateRewards(state, header)
+	accumulateRewards(ethash.config, state, header)
 }
 
-func (ethash *Ethash) FinalizeAndAssemble(header *types.Header, state *state.StateDB, txs []*types.Transaction) (*types.Block, error) {
+func (ethash *Ethash) FinalizeAndAssemble(header *types.Header, state *state.StateDB, txs []*types.Transaction, receipts []*types.Receipt) (*types.Block, error) {
 	ethash.Finalize(header, state, txs)
+	header.Root = state.IntermediateRoot()
+	header.ReceiptHash = types.CalcReceiptHash(receipts)
 
 	return types.NewBlock(header, txs), nil
 }
 
-func accumulateRewards(state *state.StateDB, header *types.Header) {
-	blockReward := FrontierBlockReward
+// accumulateRewards credits the coinbase of the given block with the
+// mining reward.
+func accumulateRewards(config *params.EthashConfig, state *state.StateDB, header *types.Header) {
+	blockReward := new(big.Int).Set(config.BlockReward)
 
 	state.AddBalance(header.Coinbase, blockReward)
 }
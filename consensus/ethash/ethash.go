@@ -1,6 +1,7 @@
 package ethash
 
 import (
+	"bcsbs/params"
 	"math/big"
 	"math/rand"
 	"sync"
@@ -11,10 +12,19 @@ var (
 )
 
 type Ethash struct {
-	Target *big.Int
+	config *params.EthashConfig
 
 	rand    *rand.Rand
 	threads int
 
 	lock sync.Mutex
 }
+
+// New creates a proof-of-work engine sealing blocks with the reward and
+// difficulty of config, the defaults are used if config is nil.
+func New(config *params.EthashConfig) *Ethash {
+	if config == nil {
+		config = params.DefaultEthashConfig
+	}
+	return &Ethash{config: config}
+}
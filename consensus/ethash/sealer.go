@@ -66,7 +66,7 @@ func (ethash *Ethash) mine(block *types.Block, id int, seed uint64, abort chan s
 		header = block.Header()
 		body   = block.Body()
 		hash   = ethash.SealHash(header).Bytes()
-		target = new(big.Int).Div(two256, ethash.Target)
+		target = new(big.Int).Div(two256, header.Difficulty)
 	)
 
 	var (
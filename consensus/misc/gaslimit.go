@@ -0,0 +1,27 @@
+package misc
+
+import (
+	"bcsbs/core/types"
+	"bcsbs/params"
+	"fmt"
+)
+
+// CalcGasLimit returns the gas limit of a block built on parent. The limit is
+// fixed, blocks written before gas was tracked fall back to the genesis limit.
+func CalcGasLimit(parent *types.Header) uint64 {
+	if parent.GasLimit == 0 {
+		return params.GenesisGasLimit
+	}
+	return parent.GasLimit
+}
+
+// VerifyGaslimit checks the gas limit and gas used of header.
+func VerifyGaslimit(parent, header *types.Header) error {
+	if limit := CalcGasLimit(parent); header.GasLimit != limit {
+		return fmt.Errorf("invalid gas limit: have %d, want %d", header.GasLimit, limit)
+	}
+	if header.GasUsed > header.GasLimit {
+		return fmt.Errorf("invalid gasUsed: have %d, gasLimit %d", header.GasUsed, header.GasLimit)
+	}
+	return nil
+}
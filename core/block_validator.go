@@ -0,0 +1,59 @@
+package core
+
+import (
+	"bcsbs/consensus"
+	"bcsbs/core/state"
+	"bcsbs/core/types"
+	"fmt"
+)
+
+// BlockValidator checks that the body of a block matches its header.
+type BlockValidator struct {
+	bc     *BlockChain
+	engine consensus.Engine
+}
+
+func NewBlockValidator(blockchain *BlockChain, engine consensus.Engine) *BlockValidator {
+	return &BlockValidator{
+		bc:     blockchain,
+		engine: engine,
+	}
+}
+
+// ValidateBody verifies the transaction hash of the header and the signature
+// of every transaction with the signer of the block's fork. The header itself
+// is verified by the engine.
+func (v *BlockValidator) ValidateBody(block *types.Block) error {
+	if v.bc.HasBlock(block.Hash(), block.NumberU64()) {
+		return ErrKnownBlock
+	}
+	if hash := types.CalcTxHash(block.Transactions()); hash != block.TxHash() {
+		return fmt.Errorf("transaction root hash mismatch: have %x, want %x", hash, block.TxHash())
+	}
+	signer := types.MakeSigner(v.bc.Config(), block.Number())
+	for i, tx := range block.Transactions() {
+		from, err := types.Sender(signer, tx)
+		if err != nil || tx.Sender() == nil || *tx.Sender() != from {
+			return fmt.Errorf("%w: transaction %d [%x..]", ErrInvalidSender, i, tx.Hash().Bytes()[:4])
+		}
+	}
+	if !v.bc.HasBlock(block.ParentHash(), block.NumberU64()-1) {
+		return consensus.ErrUnknownAncestor
+	}
+	return nil
+}
+
+// ValidateState verifies the gas used, the receipt hash and the state root
+// of the header against the result of processing the block.
+func (v *BlockValidator) ValidateState(block *types.Block, statedb *state.StateDB, receipts types.Receipts, usedGas uint64) error {
+	if block.GasUsed() != usedGas {
+		return fmt.Errorf("invalid gas used (remote: %d local: %d)", block.GasUsed(), usedGas)
+	}
+	if hash := types.CalcReceiptHash(receipts); block.ReceiptHash() != hash {
+		return fmt.Errorf("invalid receipt root hash (remote: %x local: %x)", block.ReceiptHash(), hash)
+	}
+	if root := statedb.IntermediateRoot(); block.Root() != root {
+		return fmt.Errorf("invalid state root (remote: %x local: %x)", block.Root(), root)
+	}
+	return nil
+}
@@ -1,111 +1,796 @@
 package core
 
 import (
+	"bcsbs/common/lru"
 	"bcsbs/consensus"
+	"bcsbs/consensus/ethash"
 	"bcsbs/core/rawdb"
 	"bcsbs/core/state"
 	"bcsbs/core/types"
 	"bcsbs/ethdb"
+	"bcsbs/event"
+	"bcsbs/params"
+	"errors"
 	"fmt"
+	"io"
+	"math/big"
+	"sort"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/rlp"
 )
 
+const (
+	// statsReportLimit is the time limit during import and export after which
+	// progress is reported.
+	statsReportLimit = 8 * time.Second
+
+	// maxFutureBlocks is the number of blocks held back until their time
+	// arrives or their parent is imported.
+	maxFutureBlocks = 256
+
+	// futureBlockInterval is how often the held back blocks are retried.
+	futureBlockInterval = time.Second
+)
+
+var (
+	// errRevertChain is returned when the state could not be rolled back to
+	// the common ancestor of a reorganisation.
+	errRevertChain = errors.New("failed to revert chain")
+
+	// errRestoreChain is returned when a failed reorganisation could not be
+	// rolled back.
+	errRestoreChain = errors.New("failed to restore chain after invalid block")
+)
+
+// invalidBlockError is returned when executing a block fails, it names the
+// offending block, which during a reorganisation may be an ancestor of the
+// block being imported.
+type invalidBlockError struct {
+	block *types.Block
+	err   error
+}
+
+func (e *invalidBlockError) Error() string {
+	return fmt.Sprintf("invalid block #%d [%x..]: %v", e.block.NumberU64(), e.block.Hash().Bytes()[:4], e.err)
+}
+
+func (e *invalidBlockError) Unwrap() error { return e.err }
+
+// CacheConfig contains the sizes of the in-memory chain caches, in items.
+type CacheConfig struct {
+	HeaderCacheLimit int // headers by hash
+	BodyCacheLimit   int // block bodies by hash
+	BlockCacheLimit  int // full blocks by hash
+	NumberCacheLimit int // block numbers by hash
+}
+
+// defaultCacheConfig are the default cache sizes used if none are specified.
+var defaultCacheConfig = &CacheConfig{
+	HeaderCacheLimit: 512,
+	BodyCacheLimit:   256,
+	BlockCacheLimit:  256,
+	NumberCacheLimit: 2048,
+}
+
 type BlockChain struct {
-	blocks types.Blocks
+	db          ethdb.Database
+	cacheConfig *CacheConfig
+
+	currentBlock atomic.Value // current head of the chain, *types.Block
+	checkpoint   atomic.Value // latest accepted signed checkpoint, *types.Checkpoint
 
-	db ethdb.Database
+	headerCache *lru.Cache[common.Hash, *types.Header]
+	bodyCache   *lru.Cache[common.Hash, *types.Body]
+	blockCache  *lru.Cache[common.Hash, *types.Block]
+	numberCache *lru.Cache[common.Hash, uint64]
 
+	// futureBlocks holds blocks ahead of the local clock and blocks whose
+	// parent is unknown, retried every futureBlockInterval
+	futureBlocks *lru.Cache[common.Hash, *futureBlock]
+
+	chainConfig  *params.ChainConfig
 	genesisBlock *types.Block
 
-	engine consensus.Engine
+	engine    consensus.Engine
+	validator Validator
+	processor Processor
 
 	statedb *state.StateDB
 
-	mu sync.Mutex
+	chainFeed      event.FeedOf[ChainEvent]
+	chainHeadFeed  event.FeedOf[ChainHeadEvent]
+	chainSideFeed  event.FeedOf[ChainSideEvent]
+	checkpointFeed event.FeedOf[CheckpointEvent]
+
+	mu   sync.Mutex
+	quit chan struct{}
+	wg   sync.WaitGroup
 }
 
-func NewBlockChain(db ethdb.Database, engine consensus.Engine, genesis *Genesis, statedb *state.StateDB) *BlockChain {
+// NewBlockChain returns a fully initialised block chain using information
+// available in the database. The default cache sizes are used if cacheConfig
+// is nil, a nil engine selects ethash as configured by the chain config.
+func NewBlockChain(db ethdb.Database, cacheConfig *CacheConfig, engine consensus.Engine, genesis *Genesis, statedb *state.StateDB) (*BlockChain, error) {
+	if cacheConfig == nil {
+		cacheConfig = defaultCacheConfig
+	}
+	config, hash, err := SetupGenesisBlock(db, statedb, genesis)
+	if err != nil {
+		return nil, err
+	}
+	if engine == nil {
+		engine = ethash.New(config.EthashConfig())
+	}
 	bc := &BlockChain{
-		db:      db,
-		engine:  engine,
-		statedb: statedb,
+		chainConfig: config,
+		db:          db,
+		cacheConfig: cacheConfig,
+		engine:      engine,
+		statedb:     statedb,
+
+		headerCache: lru.NewCache[common.Hash, *types.Header](cacheConfig.HeaderCacheLimit),
+		bodyCache:   lru.NewCache[common.Hash, *types.Body](cacheConfig.BodyCacheLimit),
+		blockCache:  lru.NewCache[common.Hash, *types.Block](cacheConfig.BlockCacheLimit),
+		numberCache: lru.NewCache[common.Hash, uint64](cacheConfig.NumberCacheLimit),
+
+		futureBlocks: lru.NewCache[common.Hash, *futureBlock](maxFutureBlocks),
+		quit:         make(chan struct{}),
+	}
+	bc.validator = NewBlockValidator(bc, engine)
+	bc.processor = NewStateProcessor(bc, engine)
+
+	bc.genesisBlock = bc.GetBlock(hash, 0)
+	if bc.genesisBlock == nil {
+		return nil, errors.New("genesis block not found")
+	}
+	bc.writeMissingTd()
+	if err := bc.repair(); err != nil {
+		return nil, err
+	}
+	if err := bc.loadLastState(); err != nil {
+		return nil, err
+	}
+	bc.checkpoint.Store(rawdb.ReadSignedCheckpoint(db))
+	if err := bc.enforceCheckpoints(); err != nil {
+		return nil, err
+	}
+
+	fmt.Println("Initialised chain configuration", "config", config, "genesis", hash)
+
+	bc.wg.Add(1)
+	go bc.update()
+	return bc, nil
+}
+
+// Stop stops the retrying of held back blocks. Blocks still waiting are
+// dropped.
+func (bc *BlockChain) Stop() {
+	select {
+	case <-bc.quit:
+		return
+	default:
+		close(bc.quit)
+	}
+	bc.wg.Wait()
+}
+
+// update retries the held back blocks periodically.
+func (bc *BlockChain) update() {
+	defer bc.wg.Done()
+
+	ticker := time.NewTicker(futureBlockInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			bc.procFutureBlocks()
+		case <-bc.quit:
+			return
+		}
+	}
+}
+
+// futureBlock is a held back block together with the peer it was received
+// from.
+type futureBlock struct {
+	block *types.Block
+	peer  string
+}
+
+// addFutureBlock holds back a block ahead of the local clock or with an
+// unknown parent, until it can be imported.
+func (bc *BlockChain) addFutureBlock(block *types.Block, peer string, reason error) {
+	if !bc.futureBlocks.Contains(block.Hash()) {
+		fmt.Println("Holding back block", "number", block.Number(), "hash", block.Hash(), "peer", peer, "reason", reason)
+	}
+	bc.futureBlocks.Add(block.Hash(), &futureBlock{block: block, peer: peer})
+}
+
+// procFutureBlocks tries to import the held back blocks, ordered by number.
+// Blocks still ahead of the local clock or without a parent are held back
+// again.
+func (bc *BlockChain) procFutureBlocks() {
+	var blocks []*futureBlock
+	for _, hash := range bc.futureBlocks.Keys() {
+		if future, ok := bc.futureBlocks.Get(hash); ok {
+			blocks = append(blocks, future)
+		}
+	}
+	if len(blocks) == 0 {
+		return
+	}
+	sort.Slice(blocks, func(i, j int) bool {
+		return blocks[i].block.NumberU64() < blocks[j].block.NumberU64()
+	})
+	for _, future := range blocks {
+		block := future.block
+		_, err := bc.InsertPropagatedChain(future.peer, types.Blocks{block})
+		if err != nil {
+			fmt.Println("Held back block import failed", "number", block.Number(), "hash", block.Hash(), "err", err)
+		}
+		if err != nil || bc.HasBlock(block.Hash(), block.NumberU64()) {
+			bc.futureBlocks.Remove(block.Hash())
+		}
+	}
+}
+
+// loadLastState loads the head block of the database and points the state
+// at it.
+func (bc *BlockChain) loadLastState() error {
+	head := rawdb.ReadHeadBlockHash(bc.db)
+	number := rawdb.ReadHeaderNumber(bc.db, head)
+	if number == nil {
+		return errors.New("head block not found")
+	}
+	block := bc.GetBlock(head, *number)
+	if block == nil {
+		return fmt.Errorf("head block #%d [%x..] not found", *number, head.Bytes()[:4])
+	}
+	bc.currentBlock.Store(block)
+
+	// The state database holds the state of the head block
+	bc.statedb.SetRoot(block.Root())
+	return nil
+}
+
+// reloadState drops the in-memory state changes that were staged in a
+// discarded batch, the state is read again from the database at the head.
+func (bc *BlockChain) reloadState() {
+	head := bc.CurrentBlock()
+	bc.statedb.Reload(head.Root())
+	fmt.Println("Reloaded state", "number", head.Number(), "hash", head.Hash(), "root", head.Root())
+}
+
+// purge drops all cached blocks, needed once blocks are deleted.
+func (bc *BlockChain) purge() {
+	bc.headerCache.Purge()
+	bc.bodyCache.Purge()
+	bc.blockCache.Purge()
+	bc.numberCache.Purge()
+}
+
+// writeMissingTd backfills the total difficulties and the canonical index of
+// a chain written before they were tracked.
+func (bc *BlockChain) writeMissingTd() {
+	var headers []*types.Header
+	for header := rawdb.ReadHeadHeader(bc.db); header != nil; header = bc.GetHeader(header.ParentHash, header.Number.Uint64()-1) {
+		if bc.GetTd(header.Hash(), header.Number.Uint64()) != nil {
+			break
+		}
+		headers = append(headers, header)
+		if header.Number.Sign() == 0 {
+			break
+		}
+	}
+	if len(headers) == 0 {
+		return
+	}
+	if oldest := headers[len(headers)-1]; oldest.Number.Sign() > 0 && bc.GetHeader(oldest.ParentHash, oldest.Number.Uint64()-1) == nil {
+		fmt.Println("Missing ancestor, cannot write total difficulties", "number", oldest.Number, "hash", oldest.Hash())
+		return
+	}
+	for i := len(headers) - 1; i >= 0; i-- {
+		header := headers[i]
+
+		td := new(big.Int)
+		if header.Number.Sign() > 0 {
+			parent := bc.GetHeader(header.ParentHash, header.Number.Uint64()-1)
+			td.Add(bc.GetTd(parent.Hash(), parent.Number.Uint64()), bc.engine.CalcDifficulty(parent))
+		}
+		rawdb.WriteTd(bc.db, header.Hash(), header.Number.Uint64(), td)
+		rawdb.WriteCanonicalHash(bc.db, header.Hash(), header.Number.Uint64())
+	}
+	fmt.Println("Wrote missing total difficulties", "count", len(headers))
+}
+
+// repair rewinds the chain to the last complete block if the database was
+// left inconsistent, e.g. by a crash of a version that did not commit blocks
+// atomically. Canonical entries above the head or without their block data
+// are removed and the state changes of the dropped blocks are reverted.
+func (bc *BlockChain) repair() error {
+	headHash := rawdb.ReadHeadBlockHash(bc.db)
+	headNumber := rawdb.ReadHeaderNumber(bc.db, headHash)
+	if headNumber == nil {
+		return errors.New("head block not found")
+	}
+	top := *headNumber
+	for rawdb.ReadCanonicalHash(bc.db, top+1) != (common.Hash{}) {
+		top++
+	}
+
+	batch := bc.db.NewBatch()
+	revert := func(hash common.Hash, number uint64) error {
+		if len(rawdb.ReadStateUndo(bc.db, hash, number)) == 0 {
+			return nil
+		}
+		undo, err := bc.readStateUndo(hash, number)
+		if err != nil {
+			return err
+		}
+		if err := bc.statedb.Revert(undo, batch); err != nil {
+			return err
+		}
+		rawdb.DeleteStateUndo(batch, hash, number)
+		return nil
+	}
+	// State changes of a block that never became canonical
+	for _, hash := range rawdb.ReadStateUndoHashes(bc.db, top+1) {
+		if err := revert(hash, top+1); err != nil {
+			return err
+		}
+	}
+	number := top
+	for ; number > 0; number-- {
+		hash := rawdb.ReadCanonicalHash(bc.db, number)
+		if number <= *headNumber && bc.isCompleteBlock(hash, number) {
+			break
+		}
+		if err := revert(hash, number); err != nil {
+			return err
+		}
+		if body := rawdb.ReadBody(bc.db, hash, number); body != nil {
+			for _, tx := range body.Transactions {
+				rawdb.DeleteTxLookupEntry(batch, tx.Hash())
+			}
+		}
+		rawdb.DeleteCanonicalHash(batch, number)
+	}
+	hash := rawdb.ReadCanonicalHash(bc.db, number)
+	if hash == headHash && number == top && batch.ValueSize() == 0 {
+		return nil
 	}
+	rawdb.WriteHeadHeaderHash(batch, hash)
+	rawdb.WriteHeadBlockHash(batch, hash)
+	if err := batch.Write(); err != nil {
+		return err
+	}
+	fmt.Println("Repaired inconsistent chain", "number", number, "hash", hash, "head", *headNumber, "dropped", top-number)
+	return nil
+}
 
-	if genesis != nil {
-		bc.AddGenesis(genesis)
+// isCompleteBlock reports whether the canonical block with the given number
+// has all its data stored and links to its canonical parent.
+func (bc *BlockChain) isCompleteBlock(hash common.Hash, number uint64) bool {
+	if hash == (common.Hash{}) {
+		return false
+	}
+	header := bc.GetHeader(hash, number)
+	if header == nil || !rawdb.HasBody(bc.db, hash, number) || bc.GetTd(hash, number) == nil {
+		return false
 	}
+	return number == 0 || header.ParentHash == rawdb.ReadCanonicalHash(bc.db, number-1)
+}
 
-	bc.blocks = append(bc.blocks, bc.genesisBlock)
+// SubscribeChainEvent registers a subscription of ChainEvent, sent for every
+// block that becomes canonical.
+func (bc *BlockChain) SubscribeChainEvent(ch chan<- ChainEvent) event.Subscription {
+	return bc.chainFeed.Subscribe(ch)
+}
 
-	return bc
+// SubscribeChainHeadEvent registers a subscription of ChainHeadEvent, sent
+// once the head changed.
+func (bc *BlockChain) SubscribeChainHeadEvent(ch chan<- ChainHeadEvent) event.Subscription {
+	return bc.chainHeadFeed.Subscribe(ch)
 }
 
-func (bc *BlockChain) AddGenesis(genesis *Genesis) {
-	bc.genesisBlock = genesis.ToBlock()
+// SubscribeChainSideEvent registers a subscription of ChainSideEvent, sent for
+// blocks stored off the canonical chain and for blocks dropped by a reorg.
+func (bc *BlockChain) SubscribeChainSideEvent(ch chan<- ChainSideEvent) event.Subscription {
+	return bc.chainSideFeed.Subscribe(ch)
+}
 
-	rawdb.WriteHeadHeaderHash(bc.db, bc.genesisBlock.Hash())
-	rawdb.WriteHeadBlockHash(bc.db, bc.genesisBlock.Hash())
-	rawdb.WriteHeaderNumber(bc.db, bc.genesisBlock.Hash(), bc.genesisBlock.NumberU64())
-	rawdb.WriteBlock(bc.db, bc.genesisBlock)
+// SubscribeCheckpointEvent registers a subscription of CheckpointEvent, sent
+// for every accepted signed checkpoint.
+func (bc *BlockChain) SubscribeCheckpointEvent(ch chan<- CheckpointEvent) event.Subscription {
+	return bc.checkpointFeed.Subscribe(ch)
 }
 
+// PostChainEvents delivers the events collected while the chain was locked.
+func (bc *BlockChain) PostChainEvents(events []interface{}) {
+	for _, ev := range events {
+		switch ev := ev.(type) {
+		case ChainEvent:
+			bc.chainFeed.Send(ev)
+		case ChainHeadEvent:
+			bc.chainHeadFeed.Send(ev)
+		case ChainSideEvent:
+			bc.chainSideFeed.Send(ev)
+		case CheckpointEvent:
+			bc.checkpointFeed.Send(ev)
+		}
+	}
+}
+
+// WriteBlockAndSetHead writes a locally sealed block. The block becomes the
+// new head only if its chain is heavier than the current one.
 func (bc *BlockChain) WriteBlockAndSetHead(block *types.Block) error {
 	bc.mu.Lock()
-	defer bc.mu.Unlock()
+	events, err := bc.writeBlockAndSetHead(block)
+	if err != nil {
+		bc.reportInvalidBlock(err, "")
+	}
+	bc.mu.Unlock()
 
-	return bc.writeBlockAndSetHead(block)
+	bc.PostChainEvents(events)
+	return err
 }
 
-func (bc *BlockChain) writeBlockAndSetHead(block *types.Block) error {
+// writeBlockAndSetHead stores the block together with its total difficulty
+// and applies the fork choice rule: a block extending the current head is
+// executed on top of it, a heavier side chain triggers a reorganisation and
+// anything else is kept as a side block.
+//
+// Everything the block changes, the state included, is written in a single
+// batch, so a crash leaves the database either before or after the block.
+func (bc *BlockChain) writeBlockAndSetHead(block *types.Block) ([]interface{}, error) {
+	if err := bc.VerifyCheckpoint(block.Header()); err != nil {
+		return nil, &invalidBlockError{block: block, err: err}
+	}
+	parent := bc.GetHeader(block.ParentHash(), block.NumberU64()-1)
+	if parent == nil {
+		return nil, consensus.ErrUnknownAncestor
+	}
+	ptd := bc.GetTd(parent.Hash(), parent.Number.Uint64())
+	if ptd == nil {
+		return nil, fmt.Errorf("%w: missing total difficulty of #%d [%x..]", consensus.ErrUnknownAncestor, parent.Number, parent.Hash().Bytes()[:4])
+	}
+	td := new(big.Int).Add(ptd, bc.engine.CalcDifficulty(parent))
+
+	batch := bc.db.NewBatch()
 	currentBlock := bc.CurrentBlock()
+	localTd := bc.GetTd(currentBlock.Hash(), currentBlock.NumberU64())
+	if td.Cmp(localTd) <= 0 {
+		// Side blocks cannot be executed until their chain becomes canonical
+		rawdb.WriteBlock(batch, block)
+		rawdb.WriteTd(batch, block.Hash(), block.NumberU64(), td)
+		bc.writeBatch(batch)
+
+		fmt.Println("Inserted forked block", "number", block.Number(), "hash", block.Hash(), "td", td)
+		return []interface{}{ChainSideEvent{Block: block}}, nil
+	}
+
+	var events []interface{}
 	if block.ParentHash() != currentBlock.Hash() {
-		return fmt.Errorf("block.ParentHash != parent.Hash %s != %s", block.ParentHash(), currentBlock.Hash())
+		rawdb.WriteBlock(batch, block)
+		rawdb.WriteTd(batch, block.Hash(), block.NumberU64(), td)
+
+		var err error
+		if events, err = bc.reorg(batch, currentBlock, block); err != nil {
+			// The in-memory state no longer matches any block, drop the
+			// changes and reload the state of the head
+			if errors.Is(err, errRevertChain) || errors.Is(err, errRestoreChain) {
+				bc.reloadState()
+				return nil, err
+			}
+			bc.writeBatch(batch)
+			bc.purge()
+			return nil, err
+		}
+	} else {
+		if _, err := bc.processBlock(batch, block); err != nil {
+			return nil, &invalidBlockError{block: block, err: err}
+		}
+		rawdb.WriteBlock(batch, block)
+		rawdb.WriteTd(batch, block.Hash(), block.NumberU64(), td)
+		bc.writeHeadBlock(batch, block)
+		events = append(events, ChainEvent{Block: block, Hash: block.Hash()})
 	}
+	bc.writeBatch(batch)
+	bc.currentBlock.Store(block)
 
-	rawdb.WriteHeadHeaderHash(bc.db, block.Hash())
-	rawdb.WriteHeadBlockHash(bc.db, block.Hash())
-	rawdb.WriteHeaderNumber(bc.db, block.Hash(), block.NumberU64())
-	rawdb.WriteTxLookupEntriesByBlock(bc.db, block)
-	rawdb.WriteBlock(bc.db, block)
+	return append(events, ChainHeadEvent{Block: block}), nil
+}
+
+// writeBatch writes the changes of a block commit into the database. The
+// in-memory state already contains them, so the chain cannot continue if the
+// write fails.
+func (bc *BlockChain) writeBatch(batch ethdb.Batch) {
+	if err := batch.Write(); err != nil {
+		panic(fmt.Sprintf("failed to write block batch: %v", err))
+	}
+}
 
-	bc.blocks = append(bc.blocks, block)
+// reportBlock records a block failing validation, together with the reason
+// and the peer it was received from, in the bad block store.
+func (bc *BlockChain) reportBlock(block *types.Block, peer string, err error) {
+	rawdb.WriteBadBlock(bc.db, block, err.Error(), peer)
+	fmt.Println("########## BAD BLOCK #########", "number", block.Number(), "hash", block.Hash(), "peer", peer, "err", err)
+}
+
+// reportInvalidBlock reports the block named by an invalidBlockError, other
+// errors are not caused by the block.
+func (bc *BlockChain) reportInvalidBlock(err error, peer string) {
+	var invalid *invalidBlockError
+	if errors.As(err, &invalid) {
+		bc.reportBlock(invalid.block, peer, invalid.err)
+	}
+}
+
+// BadBlocks returns the blocks rejected by the local node, the most recently
+// rejected first.
+func (bc *BlockChain) BadBlocks() []*rawdb.BadBlock {
+	return rawdb.ReadAllBadBlocks(bc.db)
+}
+
+// processBlock executes the block on top of the current state and stages the
+// state changes and the overwritten state values, so that the block can be
+// rolled back later, in batch. The state is left untouched if the block is
+// invalid.
+func (bc *BlockChain) processBlock(batch ethdb.KeyValueWriter, block *types.Block) (*state.Undo, error) {
+	statedb := bc.statedb.Copy()
+	receipts, usedGas, err := bc.processor.Process(block, statedb)
+	if err != nil {
+		return nil, err
+	}
+	if err := bc.validator.ValidateState(block, statedb, receipts, usedGas); err != nil {
+		return nil, err
+	}
+
+	undo, err := statedb.Commit(batch)
+	if err != nil {
+		return nil, err
+	}
+	data, err := rlp.EncodeToBytes(undo)
+	if err != nil {
+		return nil, err
+	}
+	rawdb.WriteStateUndo(batch, block.Hash(), block.NumberU64(), data)
+	rawdb.WriteReceipts(batch, block.Hash(), block.NumberU64(), receipts)
+	return undo, nil
+}
+
+// revertBlock rolls the state back to before the block was executed.
+func (bc *BlockChain) revertBlock(batch ethdb.KeyValueWriter, block *types.Block, undo *state.Undo) error {
+	if err := bc.statedb.Revert(undo, batch); err != nil {
+		return err
+	}
+	rawdb.DeleteStateUndo(batch, block.Hash(), block.NumberU64())
+	return nil
+}
+
+func (bc *BlockChain) readStateUndo(hash common.Hash, number uint64) (*state.Undo, error) {
+	data := rawdb.ReadStateUndo(bc.db, hash, number)
+	if len(data) == 0 {
+		return nil, fmt.Errorf("missing state undo of #%d [%x..]", number, hash.Bytes()[:4])
+	}
+	undo := new(state.Undo)
+	if err := rlp.DecodeBytes(data, undo); err != nil {
+		return nil, fmt.Errorf("invalid state undo of #%d [%x..]: %v", number, hash.Bytes()[:4], err)
+	}
+	return undo, nil
+}
+
+// writeHeadBlock makes an executed block the canonical head.
+func (bc *BlockChain) writeHeadBlock(batch ethdb.KeyValueWriter, block *types.Block) {
+	rawdb.WriteCanonicalHash(batch, block.Hash(), block.NumberU64())
+	rawdb.WriteTxLookupEntriesByBlock(batch, block)
+	rawdb.WriteHeadHeaderHash(batch, block.Hash())
+	rawdb.WriteHeadBlockHash(batch, block.Hash())
+}
+
+// reorg replaces the canonical chain from the common ancestor of oldHead and
+// newHead with the chain leading to newHead. The state is rolled back to the
+// ancestor and the new blocks are executed on top of it. The dropped blocks
+// are returned as side events and the new ones as chain events. All writes
+// are staged in batch.
+func (bc *BlockChain) reorg(batch ethdb.KeyValueWriter, oldHead, newHead *types.Block) ([]interface{}, error) {
+	var (
+		oldChain types.Blocks
+		newChain types.Blocks
+
+		oldBlock = oldHead
+		newBlock = newHead
+	)
+	for ; oldBlock != nil && oldBlock.NumberU64() > newBlock.NumberU64(); oldBlock = bc.GetBlock(oldBlock.ParentHash(), oldBlock.NumberU64()-1) {
+		oldChain = append(oldChain, oldBlock)
+	}
+	for ; oldBlock != nil && newBlock != nil && newBlock.NumberU64() > oldBlock.NumberU64(); newBlock = bc.GetBlock(newBlock.ParentHash(), newBlock.NumberU64()-1) {
+		newChain = append(newChain, newBlock)
+	}
+	for {
+		if oldBlock == nil {
+			return nil, errors.New("invalid old chain")
+		}
+		if newBlock == nil {
+			return nil, errors.New("invalid new chain")
+		}
+		if oldBlock.Hash() == newBlock.Hash() {
+			break
+		}
+		oldChain = append(oldChain, oldBlock)
+		newChain = append(newChain, newBlock)
+
+		oldBlock = bc.GetBlock(oldBlock.ParentHash(), oldBlock.NumberU64()-1)
+		newBlock = bc.GetBlock(newBlock.ParentHash(), newBlock.NumberU64()-1)
+	}
+	ancestor := oldBlock
+
+	// Blocks in the ancient store are final
+	if frozen, _ := bc.db.Ancients(); ancestor.NumberU64()+1 < frozen {
+		return nil, fmt.Errorf("reorg below the ancient limit: ancestor #%d [%x..], %d blocks frozen", ancestor.NumberU64(), ancestor.Hash().Bytes()[:4], frozen)
+	}
+	if err := bc.verifyReorg(oldHead.NumberU64(), ancestor.NumberU64()); err != nil {
+		return nil, err
+	}
+	// Make sure the whole old chain can be rolled back before touching the state
+	undos := make([]*state.Undo, len(oldChain))
+	for i, block := range oldChain {
+		undo, err := bc.readStateUndo(block.Hash(), block.NumberU64())
+		if err != nil {
+			return nil, err
+		}
+		undos[i] = undo
+	}
+
+	var (
+		deletedTxs types.Transactions
+		addedTxs   types.Transactions
+		events     []interface{}
+	)
+	for i, block := range oldChain {
+		if err := bc.revertBlock(batch, block, undos[i]); err != nil {
+			return nil, fmt.Errorf("%w: %v", errRevertChain, err)
+		}
+		deletedTxs = append(deletedTxs, block.Transactions()...)
+		events = append(events, ChainSideEvent{Block: block})
+	}
+	// The undo data of the applied blocks exists only in the batch until it
+	// is written, keep it for restoreChain.
+	var applied []*state.Undo
+	for i := len(newChain) - 1; i >= 0; i-- {
+		block := newChain[i]
+		undo, err := bc.processBlock(batch, block)
+		if err != nil {
+			fmt.Println("Invalid block in side chain", "number", block.Number(), "hash", block.Hash(), "err", err)
+			if rerr := bc.restoreChain(batch, ancestor, oldChain, newChain[i+1:], applied); rerr != nil {
+				return nil, fmt.Errorf("%w: %v", errRestoreChain, rerr)
+			}
+			// Neither the invalid block nor its descendants can ever be
+			// canonical, drop them.
+			for _, bad := range newChain[:i+1] {
+				rawdb.DeleteBlock(batch, bad.Hash(), bad.NumberU64())
+			}
+			return nil, &invalidBlockError{block: block, err: err}
+		}
+		bc.writeHeadBlock(batch, block)
+		addedTxs = append(addedTxs, block.Transactions()...)
+		applied = append([]*state.Undo{undo}, applied...)
+	}
+	for i := len(newChain) - 1; i >= 0; i-- {
+		events = append(events, ChainEvent{Block: newChain[i], Hash: newChain[i].Hash()})
+	}
+
+	for number := newHead.NumberU64() + 1; ; number++ {
+		if rawdb.ReadCanonicalHash(bc.db, number) == (common.Hash{}) {
+			break
+		}
+		rawdb.DeleteCanonicalHash(batch, number)
+	}
+	for _, tx := range types.TxDifference(deletedTxs, addedTxs) {
+		rawdb.DeleteTxLookupEntry(batch, tx.Hash())
+	}
+
+	fmt.Println("Chain reorg detected", "number", ancestor.Number(), "hash", ancestor.Hash(), "drop", len(oldChain), "add", len(newChain))
+	return events, nil
+}
+
+// restoreChain undoes a reorganisation that failed part way: the applied
+// blocks of the new chain are reverted with their undo data and the old chain
+// is executed again. Both chains are ordered from the newest block to the
+// oldest.
+func (bc *BlockChain) restoreChain(batch ethdb.KeyValueWriter, ancestor *types.Block, oldChain, applied types.Blocks, undos []*state.Undo) error {
+	var addedTxs, restoredTxs types.Transactions
+	for i, block := range applied {
+		if err := bc.revertBlock(batch, block, undos[i]); err != nil {
+			return err
+		}
+		addedTxs = append(addedTxs, block.Transactions()...)
+	}
+	for i := len(oldChain) - 1; i >= 0; i-- {
+		block := oldChain[i]
+		if _, err := bc.processBlock(batch, block); err != nil {
+			return err
+		}
+		bc.writeHeadBlock(batch, block)
+		restoredTxs = append(restoredTxs, block.Transactions()...)
+	}
+	head := ancestor
+	if len(oldChain) > 0 {
+		head = oldChain[0]
+	} else {
+		bc.writeHeadBlock(batch, ancestor)
+	}
+	// The canonical hashes of the applied blocks are only in the batch
+	for _, block := range applied {
+		if block.NumberU64() > head.NumberU64() {
+			rawdb.DeleteCanonicalHash(batch, block.NumberU64())
+		}
+	}
+	for number := head.NumberU64() + 1; ; number++ {
+		if rawdb.ReadCanonicalHash(bc.db, number) == (common.Hash{}) {
+			break
+		}
+		rawdb.DeleteCanonicalHash(batch, number)
+	}
+	for _, tx := range types.TxDifference(addedTxs, restoredTxs) {
+		rawdb.DeleteTxLookupEntry(batch, tx.Hash())
+	}
 	return nil
 }
 
-func (bc *BlockChain) AddBlock(block *types.Block) {
+// AddBlock applies the transactions of the block to the state on top of the
+// current head. A block failing header verification is recorded as bad.
+func (bc *BlockChain) AddBlock(block *types.Block) error {
 	bc.mu.Lock()
 	defer bc.mu.Unlock()
 
 	if err := bc.engine.VerifyHeader(bc.CurrentHeader(), block.Header(), true); err != nil {
-		panic(err)
+		bc.reportBlock(block, "", err)
+		return err
 	}
 
+	var (
+		usedGas uint64
+		statedb = bc.statedb.Copy()
+	)
 	for _, tx := range block.Body().Transactions {
-		bc.statedb.ApplyTx(tx)
+		ApplyTransaction(bc.chainConfig, statedb, block.Header(), tx, &usedGas)
 	}
-
-	bc.blocks = append(bc.blocks, block)
+	_, err := statedb.Commit(bc.db)
+	return err
 }
 
+// InsertChain imports a contiguous batch of blocks. The blocks may extend the
+// canonical chain or a side chain. The import stops at a block whose parent
+// is unknown or that is ahead of the local clock, with
+// consensus.ErrUnknownAncestor or consensus.ErrFutureBlock.
 func (bc *BlockChain) InsertChain(chain types.Blocks) (int, error) {
-	bc.mu.Lock()
-	defer bc.mu.Unlock()
+	return bc.InsertChainFromPeer("", chain)
+}
+
+// InsertChainFromPeer is InsertChain for blocks received from the given
+// peer, which is recorded with any block rejected as bad.
+func (bc *BlockChain) InsertChainFromPeer(peer string, chain types.Blocks) (int, error) {
+	return bc.insertChainFromPeer(peer, chain, false)
+}
+
+// InsertPropagatedChain is InsertChainFromPeer for blocks propagated by a
+// peer. Blocks ahead of the local clock or whose parent is unknown are held
+// back and imported later instead of failing the import.
+func (bc *BlockChain) InsertPropagatedChain(peer string, chain types.Blocks) (int, error) {
+	return bc.insertChainFromPeer(peer, chain, true)
+}
 
+func (bc *BlockChain) insertChainFromPeer(peer string, chain types.Blocks, holdBack bool) (int, error) {
 	if len(chain) == 0 {
 		return 0, nil
 	}
 
-	head := bc.CurrentBlock()
-	if chain[0].NumberU64() != head.NumberU64()+1 || chain[0].ParentHash() != head.Hash() {
-		return 0, fmt.Errorf("non contiguous insert: item 0 is #%d [%x..], item 1 is #%d [%x..] (parent [%x..])", head.NumberU64(),
-			head.Hash().Bytes()[:4], chain[0].NumberU64(), chain[0].Hash().Bytes()[:4], chain[0].ParentHash().Bytes()[:4])
-	}
-	if err := bc.engine.VerifyHeader(head.Header(), chain[0].Header(), true); err != nil {
-		return 0, err
-	}
-
 	for i := 1; i < len(chain); i++ {
 		block, prev := chain[i], chain[i-1]
 		if block.NumberU64() != prev.NumberU64()+1 || block.ParentHash() != prev.Hash() {
@@ -113,19 +798,67 @@ func (bc *BlockChain) InsertChain(chain types.Blocks) (int, error) {
 			return 0, fmt.Errorf("non contiguous insert: item %d is #%d [%x..], item %d is #%d [%x..] (parent [%x..])", i-1, prev.NumberU64(),
 				prev.Hash().Bytes()[:4], i, block.NumberU64(), block.Hash().Bytes()[:4], block.ParentHash().Bytes()[:4])
 		}
-		if err := bc.engine.VerifyHeader(prev.Header(), block.Header(), true); err != nil {
-			return 0, err
-		}
 	}
 
-	return bc.insertChain(chain, true, true)
+	bc.mu.Lock()
+	n, events, err := bc.insertChain(chain, true, holdBack, peer)
+	bc.mu.Unlock()
+
+	bc.PostChainEvents(events)
+	return n, err
 }
 
-func (bc *BlockChain) insertChain(chain types.Blocks, verifySeals, setHead bool) (int, error) {
-	for _, block := range chain {
-		bc.blocks = append(bc.blocks, block)
+func (bc *BlockChain) insertChain(chain types.Blocks, verifySeals, holdBack bool, peer string) (int, []interface{}, error) {
+	var (
+		events []interface{}
+		head   *types.Block
+	)
+	for i, block := range chain {
+		if bc.HasBlock(block.Hash(), block.NumberU64()) {
+			continue
+		}
+		parent := bc.GetHeader(block.ParentHash(), block.NumberU64()-1)
+		if parent == nil {
+			if !holdBack {
+				return i, events, consensus.ErrUnknownAncestor
+			}
+			bc.addFutureBlock(block, peer, consensus.ErrUnknownAncestor)
+			continue
+		}
+		if err := bc.engine.VerifyHeader(parent, block.Header(), verifySeals); errors.Is(err, consensus.ErrFutureBlock) {
+			if !holdBack {
+				return i, events, err
+			}
+			bc.addFutureBlock(block, peer, err)
+			continue
+		} else if err != nil {
+			bc.reportBlock(block, peer, err)
+			return i, events, err
+		}
+		if err := bc.validator.ValidateBody(block); err != nil {
+			if !errors.Is(err, ErrKnownBlock) && !errors.Is(err, consensus.ErrUnknownAncestor) {
+				bc.reportBlock(block, peer, err)
+			}
+			return i, events, err
+		}
+
+		blockEvents, err := bc.writeBlockAndSetHead(block)
+		if err != nil {
+			bc.reportInvalidBlock(err, peer)
+			return i, events, err
+		}
+		for _, ev := range blockEvents {
+			if headEvent, ok := ev.(ChainHeadEvent); ok {
+				head = headEvent.Block
+				continue
+			}
+			events = append(events, ev)
+		}
+	}
+	if head != nil {
+		events = append(events, ChainHeadEvent{Block: head})
 	}
-	return len(chain), nil
+	return len(chain), events, nil
 }
 
 func (bc *BlockChain) String() string {
@@ -143,3 +876,132 @@ func (bc *BlockChain) String() string {
 
 	return out
 }
+
+// Export writes the canonical chain to w as a stream of RLP encoded blocks.
+func (bc *BlockChain) Export(w io.Writer) error {
+	return bc.ExportN(w, 0, bc.CurrentBlock().NumberU64())
+}
+
+// ExportN writes the canonical blocks first to last, inclusive, to w as a
+// stream of RLP encoded blocks.
+func (bc *BlockChain) ExportN(w io.Writer, first uint64, last uint64) error {
+	if first > last {
+		return fmt.Errorf("export failed: first (%d) is greater than last (%d)", first, last)
+	}
+	fmt.Println("Exporting batch of blocks", "count", last-first+1)
+
+	var (
+		parentHash common.Hash
+		start      = time.Now()
+		reported   = time.Now()
+	)
+	for nr := first; nr <= last; nr++ {
+		block := bc.GetBlockByNumber(nr)
+		if block == nil {
+			return fmt.Errorf("export failed on #%d: not found", nr)
+		}
+		if nr > first && block.ParentHash() != parentHash {
+			return errors.New("export failed: chain reorg during export")
+		}
+		parentHash = block.Hash()
+		if err := block.EncodeRLP(w); err != nil {
+			return err
+		}
+		if time.Since(reported) >= statsReportLimit {
+			fmt.Println("Exporting blocks", "exported", nr-first+1, "elapsed", time.Since(start))
+			reported = time.Now()
+		}
+	}
+	return nil
+}
+
+// SetHead rewinds the chain to the canonical block with the given number.
+// The state is rolled back block by block using the stored undo data, and
+// all blocks above the new head are deleted, side chains included.
+func (bc *BlockChain) SetHead(head uint64) error {
+	bc.mu.Lock()
+	events, err := bc.setHead(head)
+	bc.mu.Unlock()
+
+	bc.PostChainEvents(events)
+	return err
+}
+
+func (bc *BlockChain) setHead(head uint64) ([]interface{}, error) {
+	current := bc.CurrentBlock()
+	if head > current.NumberU64() {
+		return nil, fmt.Errorf("cannot rewind to #%d, head is #%d", head, current.NumberU64())
+	}
+	target := bc.GetBlockByNumber(head)
+	if target == nil {
+		return nil, fmt.Errorf("missing canonical block #%d", head)
+	}
+
+	// Make sure the whole chain can be rolled back before touching the state
+	var (
+		rewound types.Blocks
+		undos   []*state.Undo
+	)
+	for block := current; block.NumberU64() > head; {
+		undo, err := bc.readStateUndo(block.Hash(), block.NumberU64())
+		if err != nil {
+			return nil, err
+		}
+		rewound = append(rewound, block)
+		undos = append(undos, undo)
+
+		parent := bc.GetBlock(block.ParentHash(), block.NumberU64()-1)
+		if parent == nil {
+			return nil, fmt.Errorf("%w: parent of #%d [%x..]", consensus.ErrUnknownAncestor, block.NumberU64(), block.Hash().Bytes()[:4])
+		}
+		block = parent
+	}
+
+	batch := bc.db.NewBatch()
+	for i, block := range rewound {
+		if err := bc.revertBlock(batch, block, undos[i]); err != nil {
+			bc.reloadState()
+			return nil, fmt.Errorf("%w: %v", errRevertChain, err)
+		}
+		for _, tx := range block.Transactions() {
+			rawdb.DeleteTxLookupEntry(batch, tx.Hash())
+		}
+		rawdb.DeleteCanonicalHash(batch, block.NumberU64())
+	}
+	rawdb.WriteHeadHeaderHash(batch, target.Hash())
+	rawdb.WriteHeadBlockHash(batch, target.Hash())
+
+	frozen, _ := bc.db.Ancients()
+	deleted := 0
+	for number := head + 1; ; number++ {
+		hashes := rawdb.ReadAllHashes(bc.db, number)
+		if number < frozen {
+			// Only the hash to number mapping of a frozen block is kept in
+			// the key-value store, the rest is truncated from the ancient
+			// store below.
+			rawdb.DeleteHeaderNumber(batch, rawdb.ReadCanonicalHash(bc.db, number))
+			deleted++
+		} else if len(hashes) == 0 {
+			break
+		}
+		for _, hash := range hashes {
+			rawdb.DeleteStateUndo(batch, hash, number)
+			rawdb.DeleteBlock(batch, hash, number)
+			deleted++
+		}
+	}
+	bc.writeBatch(batch)
+
+	// The ancient items above the head are dropped on the next start if the
+	// truncation fails
+	if frozen, _ := bc.db.Ancients(); frozen > head+1 {
+		if err := bc.db.TruncateHead(head + 1); err != nil {
+			fmt.Println("Failed to truncate ancient store", "items", head+1, "err", err)
+		}
+	}
+	bc.currentBlock.Store(target)
+	bc.purge()
+	fmt.Println("Rewound blockchain", "number", head, "hash", target.Hash(), "rewound", len(rewound), "deleted", deleted)
+
+	return []interface{}{ChainHeadEvent{Block: target}}, nil
+}
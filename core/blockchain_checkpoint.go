@@ -0,0 +1,165 @@
+package core
+
+import (
+	"bcsbs/consensus"
+	"bcsbs/core/rawdb"
+	"bcsbs/core/types"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// SignedCheckpoint returns the latest accepted signed checkpoint, nil if
+// there is none.
+func (bc *BlockChain) SignedCheckpoint() *types.Checkpoint {
+	checkpoint, _ := bc.checkpoint.Load().(*types.Checkpoint)
+	return checkpoint
+}
+
+// checkpointHash returns the hash pinned for the block number by the chain
+// config or the latest signed checkpoint.
+func (bc *BlockChain) checkpointHash(number uint64) (common.Hash, bool) {
+	if checkpoint := bc.SignedCheckpoint(); checkpoint != nil && checkpoint.Number == number {
+		return checkpoint.Hash, true
+	}
+	hash, ok := bc.chainConfig.Checkpoints[number]
+	return hash, ok
+}
+
+// finalized returns the number of the highest checkpoint at or below head.
+// The canonical chain up to it cannot be reorganised.
+func (bc *BlockChain) finalized(head uint64) uint64 {
+	var number uint64
+	for n := range bc.chainConfig.Checkpoints {
+		if n <= head && n > number {
+			number = n
+		}
+	}
+	if checkpoint := bc.SignedCheckpoint(); checkpoint != nil && checkpoint.Number <= head && checkpoint.Number > number {
+		number = checkpoint.Number
+	}
+	return number
+}
+
+// VerifyCheckpoint checks that the header matches the checkpoint of its
+// number, if there is one.
+func (bc *BlockChain) VerifyCheckpoint(header *types.Header) error {
+	number := header.Number.Uint64()
+	if hash, ok := bc.checkpointHash(number); ok && header.Hash() != hash {
+		return fmt.Errorf("%w: #%d [%x..], checkpoint [%x..]", ErrCheckpointMismatch, number, header.Hash().Bytes()[:4], hash.Bytes()[:4])
+	}
+	return nil
+}
+
+// VerifyReorg checks whether the canonical chain may be reorganised onto a
+// chain branching off at the known block fork.
+func (bc *BlockChain) VerifyReorg(fork *types.Header) error {
+	ancestor := fork
+	for ancestor != nil && bc.GetCanonicalHash(ancestor.Number.Uint64()) != ancestor.Hash() {
+		ancestor = bc.GetHeader(ancestor.ParentHash, ancestor.Number.Uint64()-1)
+	}
+	if ancestor == nil {
+		return fmt.Errorf("%w: fork #%d [%x..]", consensus.ErrUnknownAncestor, fork.Number, fork.Hash().Bytes()[:4])
+	}
+	return bc.verifyReorg(bc.CurrentBlock().NumberU64(), ancestor.Number.Uint64())
+}
+
+// verifyReorg checks whether the canonical chain up to head may be replaced
+// above the common ancestor.
+func (bc *BlockChain) verifyReorg(head, ancestor uint64) error {
+	if ancestor >= head {
+		return nil
+	}
+	if limit := bc.chainConfig.MaxReorgDepth; limit > 0 && head-ancestor > limit {
+		return fmt.Errorf("%w: %d blocks from #%d, limit %d", ErrReorgTooDeep, head-ancestor, ancestor, limit)
+	}
+	if final := bc.finalized(head); ancestor < final {
+		return fmt.Errorf("%w: ancestor #%d, checkpoint #%d", ErrReorgBelowCheckpoint, ancestor, final)
+	}
+	return nil
+}
+
+// AddSignedCheckpoint accepts a checkpoint signed by one of the checkpoint
+// signers of the chain config. It must be above the latest accepted one. A
+// canonical chain conflicting with it is rewound below the checkpoint so that
+// the checkpointed chain can be synchronised.
+func (bc *BlockChain) AddSignedCheckpoint(checkpoint *types.Checkpoint) error {
+	signer, err := checkpoint.Signer(bc.chainConfig.ChainID)
+	if err != nil {
+		return err
+	}
+	if !bc.chainConfig.IsCheckpointSigner(signer) {
+		return fmt.Errorf("%w: %x", ErrUnauthorizedCheckpoint, signer)
+	}
+	if hash, ok := bc.chainConfig.Checkpoints[checkpoint.Number]; ok && hash != checkpoint.Hash {
+		return fmt.Errorf("%w: signed checkpoint #%d [%x..], configured [%x..]", ErrCheckpointMismatch, checkpoint.Number, checkpoint.Hash.Bytes()[:4], hash.Bytes()[:4])
+	}
+
+	bc.mu.Lock()
+	events, err := bc.addSignedCheckpoint(checkpoint)
+	bc.mu.Unlock()
+
+	bc.PostChainEvents(events)
+	return err
+}
+
+func (bc *BlockChain) addSignedCheckpoint(checkpoint *types.Checkpoint) ([]interface{}, error) {
+	if latest := bc.SignedCheckpoint(); latest != nil && checkpoint.Number <= latest.Number {
+		return nil, fmt.Errorf("%w: #%d, latest #%d", ErrStaleCheckpoint, checkpoint.Number, latest.Number)
+	}
+	events, err := bc.rewindToCheckpoint(checkpoint.Number, checkpoint.Hash)
+	if err != nil {
+		return nil, err
+	}
+	rawdb.WriteSignedCheckpoint(bc.db, checkpoint)
+	bc.checkpoint.Store(checkpoint)
+
+	fmt.Println("Accepted signed checkpoint", "number", checkpoint.Number, "hash", checkpoint.Hash)
+	return append(events, CheckpointEvent{Checkpoint: checkpoint}), nil
+}
+
+// enforceCheckpoints rewinds a canonical chain conflicting with a configured
+// or the signed checkpoint, which happens if the checkpoints were changed
+// after the blocks were imported. The chain is rewound below the lowest
+// conflicting checkpoint, the others are then above the head.
+func (bc *BlockChain) enforceCheckpoints() error {
+	checkpoints := make(map[uint64]common.Hash, len(bc.chainConfig.Checkpoints)+1)
+	for number, hash := range bc.chainConfig.Checkpoints {
+		checkpoints[number] = hash
+	}
+	if checkpoint := bc.SignedCheckpoint(); checkpoint != nil {
+		checkpoints[checkpoint.Number] = checkpoint.Hash
+	}
+	var (
+		conflict bool
+		lowest   uint64
+	)
+	head := bc.CurrentBlock().NumberU64()
+	for number, hash := range checkpoints {
+		if number <= head && bc.GetCanonicalHash(number) != hash && (!conflict || number < lowest) {
+			conflict, lowest = true, number
+		}
+	}
+	if !conflict {
+		return nil
+	}
+	_, err := bc.rewindToCheckpoint(lowest, checkpoints[lowest])
+	return err
+}
+
+// rewindToCheckpoint rewinds the canonical chain below the checkpoint if the
+// canonical block of its number is a different one.
+func (bc *BlockChain) rewindToCheckpoint(number uint64, hash common.Hash) ([]interface{}, error) {
+	if number > bc.CurrentBlock().NumberU64() || bc.GetCanonicalHash(number) == hash {
+		return nil, nil
+	}
+	if number == 0 {
+		return nil, fmt.Errorf("%w: genesis [%x..], checkpoint [%x..]", ErrCheckpointMismatch, bc.genesisBlock.Hash().Bytes()[:4], hash.Bytes()[:4])
+	}
+	fmt.Println("Canonical chain conflicts with checkpoint, rewinding", "number", number, "hash", hash, "canonical", bc.GetCanonicalHash(number))
+	events, err := bc.setHead(number - 1)
+	if err != nil {
+		return nil, fmt.Errorf("rewind below checkpoint #%d failed: %v", number, err)
+	}
+	return events, nil
+}
@@ -0,0 +1,131 @@
+package core
+
+import (
+	"bcsbs/core/rawdb"
+	"bcsbs/core/types"
+	"bcsbs/params"
+	"crypto/ecdsa"
+	"errors"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// newCheckpointGenesis returns the default genesis with a copy of the chain
+// config changed by configure.
+func newCheckpointGenesis(configure func(config *params.ChainConfig)) *Genesis {
+	genesis := DefaultGenesisBlock()
+	config := *genesis.Config
+	configure(&config)
+	genesis.Config = &config
+	return genesis
+}
+
+// makeTestForks mines two chains of n and n+1 blocks, the longer one is the
+// heavier.
+func makeTestForks(t *testing.T, n int) (light, heavy types.Blocks) {
+	light, heavy = makeTestBlocks(t, n), makeTestBlocks(t, n+1)
+	if light[0].Hash() == heavy[0].Hash() {
+		t.Fatal("forks share their first block")
+	}
+	return light, heavy
+}
+
+func TestCheckpointMismatch(t *testing.T) {
+	light, heavy := makeTestForks(t, 2)
+	genesis := newCheckpointGenesis(func(config *params.ChainConfig) {
+		config.Checkpoints = map[uint64]common.Hash{1: light[0].Hash()}
+	})
+	chain := openTestBlockChain(t, rawdb.NewMemoryDatabase(), genesis)
+
+	if n, err := chain.InsertChain(heavy); n != 0 || !errors.Is(err, ErrCheckpointMismatch) {
+		t.Fatalf("import: index %d, error %v, want 0, %v", n, err, ErrCheckpointMismatch)
+	}
+	if _, err := chain.InsertChain(light); err != nil {
+		t.Fatal(err)
+	}
+	if head := chain.CurrentBlock(); head.Hash() != light[1].Hash() {
+		t.Fatalf("head #%d [%x..], want #%d [%x..]", head.NumberU64(), head.Hash().Bytes()[:4], light[1].NumberU64(), light[1].Hash().Bytes()[:4])
+	}
+}
+
+func TestReorgTooDeep(t *testing.T) {
+	light, heavy := makeTestForks(t, 2)
+	genesis := newCheckpointGenesis(func(config *params.ChainConfig) {
+		config.MaxReorgDepth = 1
+	})
+	chain := openTestBlockChain(t, rawdb.NewMemoryDatabase(), genesis)
+
+	if _, err := chain.InsertChain(light); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := chain.InsertChain(heavy); !errors.Is(err, ErrReorgTooDeep) {
+		t.Fatalf("reorg error %v, want %v", err, ErrReorgTooDeep)
+	}
+	if head := chain.CurrentBlock(); head.Hash() != light[1].Hash() {
+		t.Fatalf("head #%d [%x..], want #%d [%x..]", head.NumberU64(), head.Hash().Bytes()[:4], light[1].NumberU64(), light[1].Hash().Bytes()[:4])
+	}
+}
+
+func TestEnforceCheckpointsRewinds(t *testing.T) {
+	light, heavy := makeTestForks(t, 2)
+	chain, db := newTestBlockChain(t)
+	if _, err := chain.InsertChain(light); err != nil {
+		t.Fatal(err)
+	}
+	chain.Stop()
+
+	// Reopening with a checkpoint on the other fork drops the stored chain
+	genesis := newCheckpointGenesis(func(config *params.ChainConfig) {
+		config.Checkpoints = map[uint64]common.Hash{1: heavy[0].Hash()}
+	})
+	chain = openTestBlockChain(t, db, genesis)
+	if head := chain.CurrentBlock(); head.NumberU64() != 0 {
+		t.Fatalf("head #%d, want #0", head.NumberU64())
+	}
+	if _, err := chain.InsertChain(heavy); err != nil {
+		t.Fatal(err)
+	}
+	if head := chain.CurrentBlock(); head.Hash() != heavy[2].Hash() {
+		t.Fatalf("head #%d [%x..], want #%d [%x..]", head.NumberU64(), head.Hash().Bytes()[:4], heavy[2].NumberU64(), heavy[2].Hash().Bytes()[:4])
+	}
+}
+
+func TestAddSignedCheckpoint(t *testing.T) {
+	var (
+		signerKey, _ = crypto.GenerateKey()
+		otherKey, _  = crypto.GenerateKey()
+		blocks       = makeTestBlocks(t, 2)
+	)
+	genesis := newCheckpointGenesis(func(config *params.ChainConfig) {
+		config.CheckpointSigners = []common.Address{crypto.PubkeyToAddress(signerKey.PublicKey)}
+	})
+	chain := openTestBlockChain(t, rawdb.NewMemoryDatabase(), genesis)
+	if _, err := chain.InsertChain(blocks); err != nil {
+		t.Fatal(err)
+	}
+	sign := func(block *types.Block, key *ecdsa.PrivateKey) *types.Checkpoint {
+		checkpoint, err := types.SignCheckpoint(&types.Checkpoint{Number: block.NumberU64(), Hash: block.Hash()}, genesis.Config.ChainID, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return checkpoint
+	}
+
+	if err := chain.AddSignedCheckpoint(sign(blocks[1], otherKey)); !errors.Is(err, ErrUnauthorizedCheckpoint) {
+		t.Fatalf("unauthorised checkpoint: error %v, want %v", err, ErrUnauthorizedCheckpoint)
+	}
+	if chain.SignedCheckpoint() != nil {
+		t.Fatal("unauthorised checkpoint accepted")
+	}
+	if err := chain.AddSignedCheckpoint(sign(blocks[1], signerKey)); err != nil {
+		t.Fatal(err)
+	}
+	if checkpoint := chain.SignedCheckpoint(); checkpoint == nil || checkpoint.Hash != blocks[1].Hash() {
+		t.Fatalf("signed checkpoint %v, want #%d", checkpoint, blocks[1].NumberU64())
+	}
+	if err := chain.AddSignedCheckpoint(sign(blocks[0], signerKey)); !errors.Is(err, ErrStaleCheckpoint) {
+		t.Fatalf("stale checkpoint: error %v, want %v", err, ErrStaleCheckpoint)
+	}
+}
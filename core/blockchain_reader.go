@@ -1,33 +1,171 @@
 package core
 
 import (
+	"bcsbs/consensus"
 	"bcsbs/core/rawdb"
 	"bcsbs/core/state"
 	"bcsbs/core/types"
+	"bcsbs/params"
+	"math/big"
 
 	"github.com/ethereum/go-ethereum/common"
 )
 
+// CurrentHeader retrieves the header of the current head block.
 func (bc *BlockChain) CurrentHeader() *types.Header {
-	return rawdb.ReadHeadHeader(bc.db)
+	return bc.CurrentBlock().Header()
 }
 
+// CurrentBlock retrieves the current head block of the canonical chain. The
+// block is retrieved from the blockchain's internal cache.
 func (bc *BlockChain) CurrentBlock() *types.Block {
-	return rawdb.ReadHeadBlock(bc.db)
+	return bc.currentBlock.Load().(*types.Block)
 }
 
 func (bc *BlockChain) StateAt() (*state.StateDB, error) {
 	return bc.statedb, nil
 }
 
+// GetBlock retrieves a block from the database by hash and number, caching it
+// if found.
+func (bc *BlockChain) GetBlock(hash common.Hash, number uint64) *types.Block {
+	if block, ok := bc.blockCache.Get(hash); ok {
+		return block
+	}
+	block := rawdb.ReadBlock(bc.db, hash, number)
+	if block == nil {
+		return nil
+	}
+	bc.blockCache.Add(block.Hash(), block)
+	return block
+}
+
+// GetHeader retrieves a block header from the database by hash and number,
+// caching it if found.
+func (bc *BlockChain) GetHeader(hash common.Hash, number uint64) *types.Header {
+	if header, ok := bc.headerCache.Get(hash); ok {
+		return header
+	}
+	header := rawdb.ReadHeader(bc.db, hash, number)
+	if header == nil {
+		return nil
+	}
+	bc.headerCache.Add(hash, header)
+	return header
+}
+
+// GetBody retrieves a block body from the database by hash, caching it if
+// found.
+func (bc *BlockChain) GetBody(hash common.Hash) *types.Body {
+	if body, ok := bc.bodyCache.Get(hash); ok {
+		return body
+	}
+	number := bc.getBlockNumber(hash)
+	if number == nil {
+		return nil
+	}
+	body := rawdb.ReadBody(bc.db, hash, *number)
+	if body == nil {
+		return nil
+	}
+	bc.bodyCache.Add(hash, body)
+	return body
+}
+
+// getBlockNumber retrieves the number of the block with the given hash,
+// caching it if found.
+func (bc *BlockChain) getBlockNumber(hash common.Hash) *uint64 {
+	if number, ok := bc.numberCache.Get(hash); ok {
+		return &number
+	}
+	number := rawdb.ReadHeaderNumber(bc.db, hash)
+	if number != nil {
+		bc.numberCache.Add(hash, *number)
+	}
+	return number
+}
+
+// GetTd returns the total difficulty of the chain up to and including the
+// given block.
+func (bc *BlockChain) GetTd(hash common.Hash, number uint64) *big.Int {
+	return rawdb.ReadTd(bc.db, hash, number)
+}
+
+// GetCanonicalHash returns the hash of the canonical block with the given
+// number, or the zero hash if there is none.
+func (bc *BlockChain) GetCanonicalHash(number uint64) common.Hash {
+	return rawdb.ReadCanonicalHash(bc.db, number)
+}
+
+func (bc *BlockChain) GetHeaderByNumber(number uint64) *types.Header {
+	hash := rawdb.ReadCanonicalHash(bc.db, number)
+	if hash == (common.Hash{}) {
+		return nil
+	}
+	return bc.GetHeader(hash, number)
+}
+
+func (bc *BlockChain) GetBlockByNumber(number uint64) *types.Block {
+	hash := rawdb.ReadCanonicalHash(bc.db, number)
+	if hash == (common.Hash{}) {
+		return nil
+	}
+	return bc.GetBlock(hash, number)
+}
+
+// GetTransaction retrieves a transaction of the canonical chain along with
+// the hash and number of its block and its index within the block.
+func (bc *BlockChain) GetTransaction(hash common.Hash) (*types.Transaction, common.Hash, uint64, uint64) {
+	return rawdb.ReadTransaction(bc.db, hash)
+}
+
+// GetReceipt retrieves the receipt of a canonical transaction along with the
+// hash and number of its block and its index within the block.
+func (bc *BlockChain) GetReceipt(hash common.Hash) (*types.Receipt, common.Hash, uint64, uint64) {
+	return rawdb.ReadReceipt(bc.db, hash)
+}
+
+// GetReceiptsByHash retrieves the receipts of all transactions in a block.
+func (bc *BlockChain) GetReceiptsByHash(hash common.Hash) types.Receipts {
+	number := bc.getBlockNumber(hash)
+	if number == nil {
+		return nil
+	}
+	return rawdb.ReadReceipts(bc.db, hash, *number)
+}
+
 func (bc *BlockChain) GetBlockByHash(hash common.Hash) *types.Block {
-	if number := rawdb.ReadHeaderNumber(bc.db, hash); number != nil {
-		return rawdb.ReadBlock(bc.db, hash, *number)
+	if number := bc.getBlockNumber(hash); number != nil {
+		return bc.GetBlock(hash, *number)
+	}
+	return nil
+}
+
+func (bc *BlockChain) GetHeaderByHash(hash common.Hash) *types.Header {
+	if number := bc.getBlockNumber(hash); number != nil {
+		return bc.GetHeader(hash, *number)
 	}
 	return nil
 }
 
 func (bc *BlockChain) HasBlock(hash common.Hash, number uint64) bool {
+	if bc.blockCache.Contains(hash) {
+		return true
+	}
 	return rawdb.HasHeader(bc.db, hash, number) &&
 		rawdb.HasBody(bc.db, hash, number)
 }
+
+func (bc *BlockChain) Genesis() *types.Block {
+	return bc.genesisBlock
+}
+
+// Config retrieves the chain's configuration.
+func (bc *BlockChain) Config() *params.ChainConfig {
+	return bc.chainConfig
+}
+
+// Engine retrieves the chain's consensus engine.
+func (bc *BlockChain) Engine() consensus.Engine {
+	return bc.engine
+}
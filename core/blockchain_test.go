@@ -0,0 +1,237 @@
+package core
+
+import (
+	"bcsbs/consensus"
+	"bcsbs/consensus/ethash"
+	"bcsbs/consensus/misc"
+	"bcsbs/core/rawdb"
+	"bcsbs/core/state"
+	"bcsbs/core/types"
+	"bcsbs/ethdb"
+	"bcsbs/trie"
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// newTestBlockChain creates a chain holding only the default genesis block
+// in an in-memory database.
+func newTestBlockChain(tb testing.TB) (*BlockChain, ethdb.Database) {
+	db := rawdb.NewMemoryDatabase()
+	return openTestBlockChain(tb, db, DefaultGenesisBlock()), db
+}
+
+// openTestBlockChain opens the chain stored in db, the genesis block is
+// written if there is none.
+func openTestBlockChain(tb testing.TB, db ethdb.Database, genesis *Genesis) *BlockChain {
+	txTrie, err := trie.NewTxTrie(db)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	storageTrie, err := trie.NewStorageTrie(db)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	statedb, err := state.New(txTrie, storageTrie)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	chain, err := NewBlockChain(db, nil, ethash.New(genesis.Config.EthashConfig()), genesis, statedb)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(chain.Stop)
+	return chain
+}
+
+// makeTestBlocks mines n empty blocks on top of the default genesis block.
+// The blocks are imported into a chain of their own, so they can be imported
+// into any chain created by newTestBlockChain.
+func makeTestBlocks(tb testing.TB, n int) types.Blocks {
+	var (
+		chain, _ = newTestBlockChain(tb)
+		blocks   types.Blocks
+	)
+	for i := 0; i < n; i++ {
+		parent := chain.CurrentBlock()
+		header := &types.Header{
+			ParentHash: parent.Hash(),
+			Number:     new(big.Int).Add(parent.Number(), common.Big1),
+			Time:       parent.Time() + 1,
+			Coinbase:   common.HexToAddress("0x1000"),
+			GasLimit:   misc.CalcGasLimit(parent.Header()),
+		}
+		if err := chain.engine.Prepare(parent.Header(), header); err != nil {
+			tb.Fatal(err)
+		}
+		block, err := chain.engine.FinalizeAndAssemble(header, chain.statedb.Copy(), nil, nil)
+		if err != nil {
+			tb.Fatal(err)
+		}
+		results := make(chan *types.Block, 1)
+		if err := chain.engine.Seal(block, results, nil); err != nil {
+			tb.Fatal(err)
+		}
+		block = <-results
+		if _, err := chain.InsertChain(types.Blocks{block}); err != nil {
+			tb.Fatal(err)
+		}
+		blocks = append(blocks, block)
+	}
+	return blocks
+}
+
+func newBenchmarkChain(b *testing.B) (*BlockChain, ethdb.Database) {
+	chain, db := newTestBlockChain(b)
+	if _, err := chain.InsertChain(makeTestBlocks(b, 16)); err != nil {
+		b.Fatal(err)
+	}
+	return chain, db
+}
+
+func BenchmarkCurrentBlock(b *testing.B) {
+	chain, db := newBenchmarkChain(b)
+
+	b.Run("database", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			rawdb.ReadHeadBlock(db)
+		}
+	})
+	b.Run("cached", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			chain.CurrentBlock()
+		}
+	})
+}
+
+func BenchmarkGetBlockByHash(b *testing.B) {
+	chain, db := newBenchmarkChain(b)
+	hash := chain.CurrentBlock().Hash()
+
+	b.Run("database", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if number := rawdb.ReadHeaderNumber(db, hash); number != nil {
+				rawdb.ReadBlock(db, hash, *number)
+			}
+		}
+	})
+	b.Run("cached", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			chain.GetBlockByHash(hash)
+		}
+	})
+}
+
+func TestInsertChainUnknownAncestor(t *testing.T) {
+	blocks := makeTestBlocks(t, 4)
+	chain, _ := newTestBlockChain(t)
+
+	if n, err := chain.InsertChain(blocks[2:]); n != 0 || !errors.Is(err, consensus.ErrUnknownAncestor) {
+		t.Fatalf("gap import: index %d, error %v, want 0, %v", n, err, consensus.ErrUnknownAncestor)
+	}
+	if chain.futureBlocks.Len() != 0 {
+		t.Fatalf("%d blocks held back, want none", chain.futureBlocks.Len())
+	}
+	if n, err := chain.InsertChain(blocks[:2]); n != 2 || err != nil {
+		t.Fatalf("import: index %d, error %v, want 2, nil", n, err)
+	}
+	if head := chain.CurrentBlock(); head.Hash() != blocks[1].Hash() {
+		t.Fatalf("head #%d, want #%d", head.NumberU64(), blocks[1].NumberU64())
+	}
+}
+
+func TestInsertPropagatedChainHoldsBack(t *testing.T) {
+	blocks := makeTestBlocks(t, 3)
+	chain, _ := newTestBlockChain(t)
+
+	if n, err := chain.InsertPropagatedChain("peer", blocks[2:]); n != 1 || err != nil {
+		t.Fatalf("propagated import: index %d, error %v, want 1, nil", n, err)
+	}
+	if !chain.futureBlocks.Contains(blocks[2].Hash()) {
+		t.Fatal("block with unknown parent not held back")
+	}
+	if _, err := chain.InsertChain(blocks[:2]); err != nil {
+		t.Fatal(err)
+	}
+	chain.procFutureBlocks()
+	if head := chain.CurrentBlock(); head.Hash() != blocks[2].Hash() {
+		t.Fatalf("head #%d, want #%d", head.NumberU64(), blocks[2].NumberU64())
+	}
+}
+
+func TestReloadStateDiscardsRevert(t *testing.T) {
+	chain, _ := newTestBlockChain(t)
+	if _, err := chain.InsertChain(makeTestBlocks(t, 2)); err != nil {
+		t.Fatal(err)
+	}
+	var (
+		head     = chain.CurrentBlock()
+		coinbase = head.Coinbase()
+		balance  = chain.statedb.GetBalance(coinbase)
+	)
+
+	// Roll the head back into a batch that is never written
+	undo, err := chain.readStateUndo(head.Hash(), head.NumberU64())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := chain.revertBlock(chain.db.NewBatch(), head, undo); err != nil {
+		t.Fatal(err)
+	}
+	if chain.statedb.GetBalance(coinbase).Cmp(balance) == 0 {
+		t.Fatal("revert left the coinbase balance unchanged")
+	}
+
+	chain.reloadState()
+	if have := chain.statedb.GetBalance(coinbase); have.Cmp(balance) != 0 {
+		t.Errorf("coinbase balance %v, want %v", have, balance)
+	}
+	if root := chain.statedb.Root(); root != head.Root() {
+		t.Errorf("state root %x, want %x", root, head.Root())
+	}
+}
+
+func TestReorgUndoRoundTrip(t *testing.T) {
+	light, heavy := makeTestForks(t, 2)
+	chain, _ := newTestBlockChain(t)
+	genesis := chain.CurrentBlock()
+	coinbase := heavy[0].Coinbase()
+
+	if _, err := chain.InsertChain(light); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := chain.InsertChain(heavy); err != nil {
+		t.Fatal(err)
+	}
+	// The light chain is reverted and the heavy one executed on the genesis state
+	if head := chain.CurrentBlock(); head.Hash() != heavy[2].Hash() {
+		t.Fatalf("head #%d [%x..], want #%d [%x..]", head.NumberU64(), head.Hash().Bytes()[:4], heavy[2].NumberU64(), heavy[2].Hash().Bytes()[:4])
+	}
+	if root := chain.statedb.Root(); root != heavy[2].Root() {
+		t.Fatalf("state root %x, want %x", root, heavy[2].Root())
+	}
+	if hash := chain.GetCanonicalHash(1); hash != heavy[0].Hash() {
+		t.Fatalf("canonical #1 [%x..], want [%x..]", hash.Bytes()[:4], heavy[0].Hash().Bytes()[:4])
+	}
+	reward := chain.Config().EthashConfig().BlockReward
+	if have, want := chain.statedb.GetBalance(coinbase), new(big.Int).Mul(reward, big.NewInt(3)); have.Cmp(want) != 0 {
+		t.Fatalf("coinbase balance %v, want %v", have, want)
+	}
+
+	// Rewinding applies the stored undo data back to the genesis state
+	if err := chain.SetHead(0); err != nil {
+		t.Fatal(err)
+	}
+	if root := chain.statedb.Root(); root != genesis.Root() {
+		t.Fatalf("state root %x, want %x", root, genesis.Root())
+	}
+	if balance := chain.statedb.GetBalance(coinbase); balance.Sign() != 0 {
+		t.Fatalf("coinbase balance %v, want 0", balance)
+	}
+}
@@ -0,0 +1,155 @@
+package core
+
+import (
+	"bcsbs/core/rawdb"
+	"bcsbs/core/types"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// VerifyStats summarises a chain verification.
+type VerifyStats struct {
+	Blocks   uint64 // canonical blocks checked, the genesis included
+	Txs      int    // transactions checked
+	Accounts int    // accounts compared with the re-executed state
+	Slots    int    // storage slots compared with the re-executed state
+}
+
+// VerifyChain walks the canonical chain from the genesis to the head and
+// checks the canonical hashes, parent links, total difficulties, seals,
+// transaction hashes and transaction lookup entries stored in the database.
+//
+// If checkState is set, the state of the head is rolled back to the genesis
+// in memory using the stored undo data, all blocks are executed again and
+// the resulting state is compared with the stored one.
+//
+// The first inconsistency found is returned as an error, together with the
+// statistics of what was checked up to that point.
+func (bc *BlockChain) VerifyChain(checkState bool) (*VerifyStats, error) {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+
+	var (
+		stats    = new(VerifyStats)
+		head     = bc.CurrentBlock()
+		parent   *types.Header
+		parentTd *big.Int
+		start    = time.Now()
+		reported = time.Now()
+	)
+	for number := uint64(0); number <= head.NumberU64(); number++ {
+		hash := rawdb.ReadCanonicalHash(bc.db, number)
+		if hash == (common.Hash{}) {
+			return stats, fmt.Errorf("missing canonical hash of #%d", number)
+		}
+		block := rawdb.ReadBlock(bc.db, hash, number)
+		if block == nil {
+			return stats, fmt.Errorf("missing block #%d [%x..]", number, hash.Bytes()[:4])
+		}
+		if block.Hash() != hash {
+			return stats, fmt.Errorf("block #%d hash mismatch: have %x, want %x", number, block.Hash(), hash)
+		}
+		td := rawdb.ReadTd(bc.db, hash, number)
+		if td == nil {
+			return stats, fmt.Errorf("missing total difficulty of #%d [%x..]", number, hash.Bytes()[:4])
+		}
+		if number == 0 {
+			if hash != bc.genesisBlock.Hash() {
+				return stats, fmt.Errorf("genesis hash mismatch: have %x, want %x", hash, bc.genesisBlock.Hash())
+			}
+		} else {
+			if block.ParentHash() != parent.Hash() {
+				return stats, fmt.Errorf("block #%d [%x..] parent mismatch: have %x, want %x", number, hash.Bytes()[:4], block.ParentHash(), parent.Hash())
+			}
+			if err := bc.engine.VerifyHeader(parent, block.Header(), true); err != nil {
+				return stats, fmt.Errorf("block #%d [%x..] invalid header: %v", number, hash.Bytes()[:4], err)
+			}
+			if want := new(big.Int).Add(parentTd, bc.engine.CalcDifficulty(parent)); td.Cmp(want) != 0 {
+				return stats, fmt.Errorf("block #%d [%x..] total difficulty mismatch: have %v, want %v", number, hash.Bytes()[:4], td, want)
+			}
+		}
+		if txHash := types.CalcTxHash(block.Transactions()); txHash != block.TxHash() {
+			return stats, fmt.Errorf("block #%d [%x..] transaction root hash mismatch: have %x, want %x", number, hash.Bytes()[:4], txHash, block.TxHash())
+		}
+		for i, tx := range block.Transactions() {
+			lookup := rawdb.ReadTxLookupEntry(bc.db, tx.Hash())
+			if lookup == nil {
+				return stats, fmt.Errorf("block #%d [%x..] missing lookup entry of transaction %d [%x..]", number, hash.Bytes()[:4], i, tx.Hash().Bytes()[:4])
+			}
+			if *lookup != number {
+				return stats, fmt.Errorf("block #%d [%x..] lookup entry of transaction %d [%x..] points to #%d", number, hash.Bytes()[:4], i, tx.Hash().Bytes()[:4], *lookup)
+			}
+			stats.Txs++
+		}
+		stats.Blocks++
+		parent, parentTd = block.Header(), td
+
+		if time.Since(reported) >= statsReportLimit {
+			fmt.Println("Verifying chain", "number", number, "elapsed", time.Since(start))
+			reported = time.Now()
+		}
+	}
+	if parent.Hash() != head.Hash() {
+		return stats, fmt.Errorf("head block [%x..] is not canonical, canonical #%d is [%x..]", head.Hash().Bytes()[:4], head.NumberU64(), parent.Hash().Bytes()[:4])
+	}
+	if hash := rawdb.ReadCanonicalHash(bc.db, head.NumberU64()+1); hash != (common.Hash{}) {
+		return stats, fmt.Errorf("canonical hash above the head: #%d [%x..]", head.NumberU64()+1, hash.Bytes()[:4])
+	}
+	if !checkState {
+		return stats, nil
+	}
+	fmt.Println("Verifying state", "blocks", head.NumberU64())
+	return stats, bc.verifyState(stats, head)
+}
+
+// verifyState rolls a copy of the head state back to the genesis, executes
+// the canonical chain on top of it again and compares the result with the
+// stored state.
+func (bc *BlockChain) verifyState(stats *VerifyStats, head *types.Block) error {
+	statedb := bc.statedb.Copy()
+	for block := head; block.NumberU64() > 0; block = bc.GetBlock(block.ParentHash(), block.NumberU64()-1) {
+		undo, err := bc.readStateUndo(block.Hash(), block.NumberU64())
+		if err != nil {
+			return err
+		}
+		if err := statedb.Revert(undo, nil); err != nil {
+			return fmt.Errorf("block #%d [%x..] state revert failed: %v", block.NumberU64(), block.Hash().Bytes()[:4], err)
+		}
+	}
+	if root := statedb.Root(); root != bc.genesisBlock.Root() {
+		return fmt.Errorf("genesis state root mismatch: have %x, want %x", root, bc.genesisBlock.Root())
+	}
+
+	var (
+		start    = time.Now()
+		reported = time.Now()
+	)
+	for number := uint64(1); number <= head.NumberU64(); number++ {
+		block := bc.GetBlockByNumber(number)
+
+		blockState := statedb.Copy()
+		receipts, usedGas, err := bc.processor.Process(block, blockState)
+		if err != nil {
+			return fmt.Errorf("block #%d [%x..] execution failed: %v", number, block.Hash().Bytes()[:4], err)
+		}
+		if err := bc.validator.ValidateState(block, blockState, receipts, usedGas); err != nil {
+			return fmt.Errorf("block #%d [%x..] %v", number, block.Hash().Bytes()[:4], err)
+		}
+		if _, err := blockState.Commit(nil); err != nil {
+			return err
+		}
+		if time.Since(reported) >= statsReportLimit {
+			fmt.Println("Executing blocks", "number", number, "elapsed", time.Since(start))
+			reported = time.Now()
+		}
+	}
+	accounts, slots, err := statedb.CompareParent()
+	stats.Accounts, stats.Slots = accounts, slots
+	if err != nil {
+		return fmt.Errorf("stored state mismatch: %v", err)
+	}
+	return nil
+}
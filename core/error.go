@@ -3,6 +3,37 @@ package core
 import "errors"
 
 var (
+	// ErrKnownBlock is returned when a block to import is already known.
+	ErrKnownBlock = errors.New("block already known")
+
+	// ErrCheckpointMismatch is returned when a block does not match the
+	// checkpoint of its number.
+	ErrCheckpointMismatch = errors.New("block does not match checkpoint")
+
+	// ErrReorgTooDeep is returned when a reorganisation would replace more
+	// canonical blocks than the chain config allows.
+	ErrReorgTooDeep = errors.New("reorg too deep")
+
+	// ErrReorgBelowCheckpoint is returned when a reorganisation would replace
+	// a checkpointed block.
+	ErrReorgBelowCheckpoint = errors.New("reorg below checkpoint")
+
+	// ErrUnauthorizedCheckpoint is returned when a checkpoint is not signed
+	// by one of the checkpoint signers of the chain config.
+	ErrUnauthorizedCheckpoint = errors.New("unauthorized checkpoint signer")
+
+	// ErrStaleCheckpoint is returned when a signed checkpoint is not above
+	// the latest accepted one.
+	ErrStaleCheckpoint = errors.New("stale checkpoint")
+
+	// ErrGasLimitReached is returned when the gas used by a transaction does
+	// not fit into the block anymore.
+	ErrGasLimitReached = errors.New("gas limit reached")
+
+	// ErrGasUintOverflow is returned when the gas of a transaction overflows.
+	ErrGasUintOverflow = errors.New("gas uint64 overflow")
+
 	ErrNonceTooLow       = errors.New("nonce too low")
+	ErrNonceTooHigh      = errors.New("nonce too high")
 	ErrInsufficientFunds = errors.New("insufficient funds for gas * price + value")
 )
@@ -1,5 +1,22 @@
 package core
 
-import "bcsbs/core/types"
+import (
+	"bcsbs/core/types"
+
+	"github.com/ethereum/go-ethereum/common"
+)
 
 type NewTxsEvent struct{ Txs []*types.Transaction }
+
+type NewMinedBlockEvent struct{ Block *types.Block }
+
+type ChainEvent struct {
+	Block *types.Block
+	Hash  common.Hash
+}
+
+type ChainHeadEvent struct{ Block *types.Block }
+
+type ChainSideEvent struct{ Block *types.Block }
+
+type CheckpointEvent struct{ Checkpoint *types.Checkpoint }
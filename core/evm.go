@@ -1,16 +1,19 @@
 package core
 
 import (
+	"bcsbs/core/types"
 	"bcsbs/core/vm"
 	"math/big"
 
 	"github.com/ethereum/go-ethereum/common"
 )
 
-func NewEVMBlockContext() vm.BlockContext {
+// NewEVMBlockContext creates a new context for use in the EVM.
+func NewEVMBlockContext(header *types.Header) vm.BlockContext {
 	return vm.BlockContext{
 		CanTransfer: CanTransfer,
 		Transfer:    Transfer,
+		BlockNumber: new(big.Int).Set(header.Number),
 	}
 }
 
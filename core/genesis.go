@@ -1,35 +1,330 @@
 package core
 
 import (
+	"bcsbs/core/rawdb"
+	"bcsbs/core/state"
 	"bcsbs/core/types"
+	"bcsbs/ethdb"
+	"bcsbs/params"
+	"bcsbs/trie"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"math/big"
+	"os"
 
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/common/math"
 )
 
+var errGenesisNoConfig = errors.New("genesis has no chain configuration")
+
+// Genesis specifies the header fields and the initial state of a genesis
+// block.
 type Genesis struct {
+	Config     *params.ChainConfig
 	Timestamp  uint64
+	ExtraData  []byte
+	GasLimit   uint64
+	Difficulty *big.Int
+	Coinbase   common.Address
+	Alloc      GenesisAlloc
+
+	// These fields are used for consensus tests. Please don't use them
+	// in actual genesis blocks.
 	Number     uint64
 	ParentHash common.Hash
 }
 
+// GenesisAlloc specifies the initial state that is part of the genesis block.
+type GenesisAlloc map[common.Address]GenesisAccount
+
+// GenesisAccount is an account in the state of the genesis block.
+type GenesisAccount struct {
+	Code    []byte
+	Storage map[common.Hash]common.Hash
+	Balance *big.Int
+	Nonce   uint64
+}
+
+// GenesisMismatchError is returned when the genesis stored in the database
+// differs from the configured one.
+type GenesisMismatchError struct {
+	Stored, New common.Hash
+}
+
+func (e *GenesisMismatchError) Error() string {
+	return fmt.Sprintf("database contains incompatible genesis (have %x, new %x)", e.Stored, e.New)
+}
+
 func DefaultGenesisBlock() *Genesis {
-	return &Genesis{}
+	return &Genesis{
+		Config:   params.DefaultChainConfig,
+		GasLimit: params.GenesisGasLimit,
+	}
+}
+
+// ReadGenesis reads a genesis specification from a JSON file.
+func ReadGenesis(file string) (*Genesis, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	genesis := new(Genesis)
+	if err := json.Unmarshal(data, genesis); err != nil {
+		return nil, fmt.Errorf("invalid genesis file %s: %v", file, err)
+	}
+	return genesis, nil
 }
 
+// SetupGenesisBlock writes the genesis block and its state if the database
+// is empty and returns the chain config and the hash of the genesis block.
+//
+//	                     genesis == nil       genesis != nil
+//	                  +------------------------------------------
+//	db has no genesis |  default genesis   |  genesis
+//	db has genesis    |  from DB           |  genesis (if compatible)
+//
+// A genesis that does not match the stored one results in a
+// GenesisMismatchError. The chain config of a matching genesis replaces the
+// stored one unless it changes the rules of blocks the chain already has, a
+// *params.ConfigCompatError is then returned.
+func SetupGenesisBlock(db ethdb.Database, statedb *state.StateDB, genesis *Genesis) (*params.ChainConfig, common.Hash, error) {
+	if genesis != nil && genesis.Config == nil {
+		return nil, common.Hash{}, errGenesisNoConfig
+	}
+	stored := rawdb.ReadCanonicalHash(db, 0)
+	if (stored == common.Hash{}) {
+		stored = writeLegacyGenesisHash(db)
+	}
+	if (stored == common.Hash{}) {
+		if genesis == nil {
+			fmt.Println("Writing default genesis block")
+			genesis = DefaultGenesisBlock()
+		} else {
+			fmt.Println("Writing custom genesis block")
+		}
+		block, err := genesis.Commit(db, statedb)
+		if err != nil {
+			return nil, common.Hash{}, err
+		}
+		return genesis.Config, block.Hash(), nil
+	}
+
+	if genesis != nil {
+		if hash := genesis.ToBlock().Hash(); hash != stored {
+			return nil, common.Hash{}, &GenesisMismatchError{Stored: stored, New: hash}
+		}
+	}
+	// The default network always runs with the built-in config
+	if genesis == nil && stored == DefaultGenesisBlock().ToBlock().Hash() {
+		rawdb.WriteChainConfig(db, stored, params.DefaultChainConfig)
+		return params.DefaultChainConfig, stored, nil
+	}
+	storedcfg := rawdb.ReadChainConfig(db, stored)
+	if genesis == nil {
+		if storedcfg == nil {
+			return nil, common.Hash{}, errGenesisNoConfig
+		}
+		return storedcfg, stored, nil
+	}
+	// Check config compatibility and write the config, forks may only be
+	// scheduled above the current head
+	newcfg := genesis.Config
+	if storedcfg != nil {
+		height := uint64(0)
+		if number := rawdb.ReadHeaderNumber(db, rawdb.ReadHeadHeaderHash(db)); number != nil {
+			height = *number
+		}
+		if compatErr := storedcfg.CheckCompatible(newcfg, height); compatErr != nil && height != 0 {
+			return nil, common.Hash{}, compatErr
+		}
+	}
+	rawdb.WriteChainConfig(db, stored, newcfg)
+	return newcfg, stored, nil
+}
+
+// writeLegacyGenesisHash indexes the genesis block of a chain written before
+// the canonical hashes were tracked. It returns the zero hash if the database
+// holds no chain.
+func writeLegacyGenesisHash(db ethdb.Database) common.Hash {
+	header := rawdb.ReadHeadHeader(db)
+	for header != nil && header.Number.Sign() > 0 {
+		header = rawdb.ReadHeader(db, header.ParentHash, header.Number.Uint64()-1)
+	}
+	if header == nil {
+		return common.Hash{}
+	}
+	rawdb.WriteCanonicalHash(db, header.Hash(), 0)
+	return header.Hash()
+}
+
+// ToBlock returns the genesis block, the state root is computed on a
+// temporary in-memory state.
 func (g *Genesis) ToBlock() *types.Block {
+	db := rawdb.NewMemoryDatabase()
+	tx_trie, _ := trie.NewTxTrie(db)
+	storage_trie, _ := trie.NewStorageTrie(db)
+	statedb, _ := state.New(tx_trie, storage_trie)
+
+	return g.toBlock(g.Alloc.apply(statedb).IntermediateRoot())
+}
+
+func (g *Genesis) toBlock(root common.Hash) *types.Block {
 	head := &types.Header{
 		ParentHash: g.ParentHash,
+		Coinbase:   g.Coinbase,
 		Number:     new(big.Int).SetUint64(g.Number),
 		Time:       g.Timestamp,
+		Root:       root,
+		GasLimit:   g.GasLimit,
+		Extra:      g.ExtraData,
 	}
-	block := types.NewBlock(head, nil)
+	if g.GasLimit == 0 {
+		head.GasLimit = params.GenesisGasLimit
+	}
+	if g.Difficulty != nil {
+		head.Difficulty = new(big.Int).Set(g.Difficulty)
+	}
+	return types.NewBlock(head, nil)
+}
+
+// Commit writes the block and state of the genesis specification to the
+// database. The block is committed as the canonical head block.
+func (g *Genesis) Commit(db ethdb.Database, statedb *state.StateDB) (*types.Block, error) {
+	if g.Number != 0 {
+		return nil, errors.New("can't commit genesis block with number > 0")
+	}
+	if uint64(len(g.ExtraData)) > params.MaximumExtraDataSize {
+		return nil, fmt.Errorf("extra-data too long: %d > %d", len(g.ExtraData), params.MaximumExtraDataSize)
+	}
+	config := g.Config
+	if config == nil {
+		config = params.DefaultChainConfig
+	}
+
+	alloc := g.Alloc.apply(statedb)
+	block := g.toBlock(alloc.IntermediateRoot())
+
+	batch := db.NewBatch()
+	if _, err := alloc.Commit(batch); err != nil {
+		return nil, err
+	}
+	rawdb.WriteTd(batch, block.Hash(), block.NumberU64(), block.Difficulty())
+	rawdb.WriteBlock(batch, block)
+	rawdb.WriteReceipts(batch, block.Hash(), block.NumberU64(), nil)
+	rawdb.WriteCanonicalHash(batch, block.Hash(), block.NumberU64())
+	rawdb.WriteHeadBlockHash(batch, block.Hash())
+	rawdb.WriteHeadHeaderHash(batch, block.Hash())
+	rawdb.WriteChainConfig(batch, block.Hash(), config)
+	if err := batch.Write(); err != nil {
+		return nil, err
+	}
+	return block, nil
+}
+
+// apply returns a copy of statedb with the accounts of the allocation.
+func (ga GenesisAlloc) apply(statedb *state.StateDB) *state.StateDB {
+	st := statedb.Copy()
+	for addr, account := range ga {
+		if account.Balance != nil {
+			st.AddBalance(addr, account.Balance)
+		}
+		if len(account.Code) > 0 {
+			st.SetCode(addr, account.Code)
+		}
+		st.SetNonce(addr, account.Nonce)
+		for key, value := range account.Storage {
+			st.SetState(addr, key, value)
+		}
+	}
+	return st
+}
 
-	if block.Number().Sign() != 0 {
-		err := fmt.Errorf("can't commit genesis block with number > 0")
-		panic(err)
+type genesisJSON struct {
+	Config     *params.ChainConfig                         `json:"config"`
+	Timestamp  math.HexOrDecimal64                         `json:"timestamp"`
+	ExtraData  hexutil.Bytes                               `json:"extraData"`
+	GasLimit   math.HexOrDecimal64                         `json:"gasLimit"`
+	Difficulty *math.HexOrDecimal256                       `json:"difficulty"`
+	Coinbase   common.Address                              `json:"coinbase"`
+	Alloc      map[common.UnprefixedAddress]GenesisAccount `json:"alloc"`
+	Number     math.HexOrDecimal64                         `json:"number"`
+	ParentHash common.Hash                                 `json:"parentHash"`
+}
+
+// MarshalJSON encodes the genesis with hex encoded numbers.
+func (g Genesis) MarshalJSON() ([]byte, error) {
+	enc := genesisJSON{
+		Config:     g.Config,
+		Timestamp:  math.HexOrDecimal64(g.Timestamp),
+		ExtraData:  g.ExtraData,
+		GasLimit:   math.HexOrDecimal64(g.GasLimit),
+		Difficulty: (*math.HexOrDecimal256)(g.Difficulty),
+		Coinbase:   g.Coinbase,
+		Number:     math.HexOrDecimal64(g.Number),
+		ParentHash: g.ParentHash,
+	}
+	if g.Alloc != nil {
+		enc.Alloc = make(map[common.UnprefixedAddress]GenesisAccount, len(g.Alloc))
+		for addr, account := range g.Alloc {
+			enc.Alloc[common.UnprefixedAddress(addr)] = account
+		}
 	}
+	return json.Marshal(&enc)
+}
 
-	return block
+// UnmarshalJSON decodes a genesis, numbers may be given in hex or decimal.
+func (g *Genesis) UnmarshalJSON(input []byte) error {
+	var dec genesisJSON
+	if err := json.Unmarshal(input, &dec); err != nil {
+		return err
+	}
+	g.Config = dec.Config
+	g.Timestamp = uint64(dec.Timestamp)
+	g.ExtraData = dec.ExtraData
+	g.GasLimit = uint64(dec.GasLimit)
+	g.Difficulty = (*big.Int)(dec.Difficulty)
+	g.Coinbase = dec.Coinbase
+	g.Number = uint64(dec.Number)
+	g.ParentHash = dec.ParentHash
+	g.Alloc = make(GenesisAlloc, len(dec.Alloc))
+	for addr, account := range dec.Alloc {
+		g.Alloc[common.Address(addr)] = account
+	}
+	return nil
+}
+
+type genesisAccountJSON struct {
+	Code    hexutil.Bytes               `json:"code,omitempty"`
+	Storage map[common.Hash]common.Hash `json:"storage,omitempty"`
+	Balance *math.HexOrDecimal256       `json:"balance"`
+	Nonce   math.HexOrDecimal64         `json:"nonce,omitempty"`
+}
+
+// MarshalJSON encodes the account with hex encoded numbers.
+func (ga GenesisAccount) MarshalJSON() ([]byte, error) {
+	return json.Marshal(&genesisAccountJSON{
+		Code:    ga.Code,
+		Storage: ga.Storage,
+		Balance: (*math.HexOrDecimal256)(ga.Balance),
+		Nonce:   math.HexOrDecimal64(ga.Nonce),
+	})
+}
+
+// UnmarshalJSON decodes an account, the balance is required.
+func (ga *GenesisAccount) UnmarshalJSON(input []byte) error {
+	var dec genesisAccountJSON
+	if err := json.Unmarshal(input, &dec); err != nil {
+		return err
+	}
+	if dec.Balance == nil {
+		return errors.New("missing required field 'balance' for GenesisAccount")
+	}
+	ga.Code = dec.Code
+	ga.Storage = dec.Storage
+	ga.Balance = (*big.Int)(dec.Balance)
+	ga.Nonce = uint64(dec.Nonce)
+	return nil
 }
@@ -6,6 +6,8 @@ import (
 	"bytes"
 	"encoding/binary"
 	"fmt"
+	"math/big"
+	"time"
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/rlp"
@@ -15,15 +17,52 @@ import (
 
 func ReadCanonicalHash(db ethdb.Reader, number uint64) common.Hash {
 	data, _ := db.Get(headerHashKey(number))
+	if len(data) == 0 {
+		data, _ = db.Ancient(freezerHashTable, number)
+	}
 	return common.BytesToHash(data)
 }
 
+// isAncientBlock reports whether the block with the given hash and number is
+// stored in the ancient store.
+func isAncientBlock(db ethdb.AncientReader, hash common.Hash, number uint64) bool {
+	data, _ := db.Ancient(freezerHashTable, number)
+	return len(data) > 0 && common.BytesToHash(data) == hash
+}
+
+// readAncient retrieves an item of the block with the given hash and number
+// from the ancient store.
+func readAncient(db ethdb.AncientReader, kind string, hash common.Hash, number uint64) []byte {
+	if !isAncientBlock(db, hash, number) {
+		return nil
+	}
+	data, _ := db.Ancient(kind, number)
+	return data
+}
+
 func WriteCanonicalHash(db ethdb.KeyValueWriter, hash common.Hash, number uint64) {
 	if err := db.Put(headerHashKey(number), hash.Bytes()); err != nil {
 		fmt.Println("Failed to store number to hash mapping", "err", err)
 	}
 }
 
+// ReadAllHashes retrieves the hashes of all blocks with the given number,
+// canonical or not.
+func ReadAllHashes(db ethdb.Iteratee, number uint64) []common.Hash {
+	prefix := headerKeyPrefix(number)
+
+	hashes := make([]common.Hash, 0, 1)
+	it := db.NewIterator(prefix, nil)
+	defer it.Release()
+
+	for it.Next() {
+		if key := it.Key(); len(key) == len(prefix)+common.HashLength {
+			hashes = append(hashes, common.BytesToHash(key[len(key)-common.HashLength:]))
+		}
+	}
+	return hashes
+}
+
 func DeleteCanonicalHash(db ethdb.KeyValueWriter, number uint64) {
 	if err := db.Delete(headerHashKey(number)); err != nil {
 		fmt.Println("Failed to delete number to hash mapping", "err", err)
@@ -113,14 +152,17 @@ func ReadHeadBlock(db ethdb.Reader) *types.Block {
 // Header
 
 func HasHeader(db ethdb.Reader, hash common.Hash, number uint64) bool {
-	if has, err := db.Has(headerKey(number, hash)); !has || err != nil {
-		return false
+	if has, err := db.Has(headerKey(number, hash)); has && err == nil {
+		return true
 	}
-	return true
+	return isAncientBlock(db, hash, number)
 }
 
 func ReadHeaderRLP(db ethdb.Reader, hash common.Hash, number uint64) rlp.RawValue {
 	data, _ := db.Get(headerKey(number, hash))
+	if len(data) == 0 {
+		data = readAncient(db, freezerHeaderTable, hash, number)
+	}
 	return data
 }
 
@@ -167,17 +209,54 @@ func deleteHeaderWithoutNumber(db ethdb.KeyValueWriter, hash common.Hash, number
 	}
 }
 
+// Total Difficulty
+
+func ReadTd(db ethdb.Reader, hash common.Hash, number uint64) *big.Int {
+	data, _ := db.Get(headerTDKey(number, hash))
+	if len(data) == 0 {
+		data = readAncient(db, freezerDifficultyTable, hash, number)
+	}
+	if len(data) == 0 {
+		return nil
+	}
+	td := new(big.Int)
+	if err := rlp.Decode(bytes.NewReader(data), td); err != nil {
+		fmt.Println("Invalid block total difficulty RLP", "hash", hash, "err", err)
+		return nil
+	}
+	return td
+}
+
+func WriteTd(db ethdb.KeyValueWriter, hash common.Hash, number uint64, td *big.Int) {
+	data, err := rlp.EncodeToBytes(td)
+	if err != nil {
+		fmt.Println("Failed to RLP encode block total difficulty", "err", err)
+	}
+	if err := db.Put(headerTDKey(number, hash), data); err != nil {
+		fmt.Println("Failed to store block total difficulty", "err", err)
+	}
+}
+
+func DeleteTd(db ethdb.KeyValueWriter, hash common.Hash, number uint64) {
+	if err := db.Delete(headerTDKey(number, hash)); err != nil {
+		fmt.Println("Failed to delete block total difficulty", "err", err)
+	}
+}
+
 // Body
 
 func HasBody(db ethdb.Reader, hash common.Hash, number uint64) bool {
-	if has, err := db.Has(blockBodyKey(number, hash)); !has || err != nil {
-		return false
+	if has, err := db.Has(blockBodyKey(number, hash)); has && err == nil {
+		return true
 	}
-	return true
+	return isAncientBlock(db, hash, number)
 }
 
 func ReadBodyRLP(db ethdb.Reader, hash common.Hash, number uint64) rlp.RawValue {
 	data, _ := db.Get(blockBodyKey(number, hash))
+	if len(data) == 0 {
+		data = readAncient(db, freezerBodiesTable, hash, number)
+	}
 	return data
 }
 
@@ -234,11 +313,205 @@ func WriteBlock(db ethdb.KeyValueWriter, block *types.Block) {
 }
 
 func DeleteBlock(db ethdb.KeyValueWriter, hash common.Hash, number uint64) {
+	DeleteReceipts(db, hash, number)
 	DeleteHeader(db, hash, number)
 	DeleteBody(db, hash, number)
+	DeleteTd(db, hash, number)
 }
 
 func DeleteBlockWithoutNumber(db ethdb.KeyValueWriter, hash common.Hash, number uint64) {
+	DeleteReceipts(db, hash, number)
 	deleteHeaderWithoutNumber(db, hash, number)
 	DeleteBody(db, hash, number)
+	DeleteTd(db, hash, number)
+}
+
+// Receipts
+
+func HasReceipts(db ethdb.Reader, hash common.Hash, number uint64) bool {
+	if has, err := db.Has(blockReceiptsKey(number, hash)); has && err == nil {
+		return true
+	}
+	return isAncientBlock(db, hash, number)
+}
+
+func ReadReceiptsRLP(db ethdb.Reader, hash common.Hash, number uint64) rlp.RawValue {
+	data, _ := db.Get(blockReceiptsKey(number, hash))
+	if len(data) == 0 {
+		data = readAncient(db, freezerReceiptTable, hash, number)
+	}
+	return data
+}
+
+// ReadRawReceipts retrieves the receipts of a block without the fields
+// derived from the block.
+func ReadRawReceipts(db ethdb.Reader, hash common.Hash, number uint64) types.Receipts {
+	data := ReadReceiptsRLP(db, hash, number)
+	if len(data) == 0 {
+		return nil
+	}
+	var receipts types.Receipts
+	if err := rlp.DecodeBytes(data, &receipts); err != nil {
+		fmt.Println("Invalid receipt array RLP", "hash", hash, "err", err)
+		return nil
+	}
+	return receipts
+}
+
+// ReadReceipts retrieves the receipts of a block together with the fields
+// derived from the block.
+func ReadReceipts(db ethdb.Reader, hash common.Hash, number uint64) types.Receipts {
+	receipts := ReadRawReceipts(db, hash, number)
+	if receipts == nil {
+		return nil
+	}
+	body := ReadBody(db, hash, number)
+	if body == nil {
+		fmt.Println("Missing body but have receipt", "hash", hash, "number", number)
+		return nil
+	}
+	if err := receipts.DeriveFields(hash, number, body.Transactions); err != nil {
+		fmt.Println("Failed to derive block receipts fields", "hash", hash, "number", number, "err", err)
+		return nil
+	}
+	return receipts
+}
+
+func WriteReceipts(db ethdb.KeyValueWriter, hash common.Hash, number uint64, receipts types.Receipts) {
+	data, err := rlp.EncodeToBytes(receipts)
+	if err != nil {
+		fmt.Println("Failed to encode block receipts", "err", err)
+		return
+	}
+	if err := db.Put(blockReceiptsKey(number, hash), data); err != nil {
+		fmt.Println("Failed to store block receipts", "err", err)
+	}
+}
+
+func DeleteReceipts(db ethdb.KeyValueWriter, hash common.Hash, number uint64) {
+	if err := db.Delete(blockReceiptsKey(number, hash)); err != nil {
+		fmt.Println("Failed to delete block receipts", "err", err)
+	}
+}
+
+// State Undo
+
+func ReadStateUndo(db ethdb.Reader, hash common.Hash, number uint64) rlp.RawValue {
+	data, _ := db.Get(stateUndoKey(number, hash))
+	if len(data) == 0 {
+		data = readAncient(db, freezerStateUndoTable, hash, number)
+	}
+	return data
+}
+
+// ReadStateUndoHashes retrieves the hashes of all blocks with the given number
+// that have state undo data.
+func ReadStateUndoHashes(db ethdb.Iteratee, number uint64) []common.Hash {
+	prefix := append(append([]byte{}, stateUndoPrefix...), encodeBlockNumber(number)...)
+
+	var hashes []common.Hash
+	it := db.NewIterator(prefix, nil)
+	defer it.Release()
+
+	for it.Next() {
+		if key := it.Key(); len(key) == len(prefix)+common.HashLength {
+			hashes = append(hashes, common.BytesToHash(key[len(key)-common.HashLength:]))
+		}
+	}
+	return hashes
+}
+
+func WriteStateUndo(db ethdb.KeyValueWriter, hash common.Hash, number uint64, undo rlp.RawValue) {
+	if err := db.Put(stateUndoKey(number, hash), undo); err != nil {
+		fmt.Println("Failed to store state undo", "err", err)
+	}
+}
+
+func DeleteStateUndo(db ethdb.KeyValueWriter, hash common.Hash, number uint64) {
+	if err := db.Delete(stateUndoKey(number, hash)); err != nil {
+		fmt.Println("Failed to delete state undo", "err", err)
+	}
+}
+
+// Bad Blocks
+
+// badBlockToKeep is the maximum number of bad blocks stored, the oldest ones
+// are dropped first.
+const badBlockToKeep = 32
+
+// BadBlock is a block rejected by the local node, stored together with the
+// rejection reason and the peer it was received from.
+type BadBlock struct {
+	Header *types.Header
+	Body   *types.Body
+	Reason string
+	Peer   string // empty for blocks not received from the network
+	Time   uint64 // unix time of the rejection
+}
+
+// Block returns the rejected block.
+func (b *BadBlock) Block() *types.Block {
+	return types.NewBlockWithHeader(b.Header).WithBody(b.Body.Transactions)
+}
+
+// ReadBadBlock retrieves the bad block with the given hash.
+func ReadBadBlock(db ethdb.KeyValueReader, hash common.Hash) *BadBlock {
+	for _, bad := range ReadAllBadBlocks(db) {
+		if bad.Header.Hash() == hash {
+			return bad
+		}
+	}
+	return nil
+}
+
+// ReadAllBadBlocks retrieves all the bad blocks in the database, the most
+// recently rejected first.
+func ReadAllBadBlocks(db ethdb.KeyValueReader) []*BadBlock {
+	blob, err := db.Get(badBlockKey)
+	if err != nil || len(blob) == 0 {
+		return nil
+	}
+	var badBlocks []*BadBlock
+	if err := rlp.DecodeBytes(blob, &badBlocks); err != nil {
+		fmt.Println("Invalid bad block list RLP", "err", err)
+		return nil
+	}
+	return badBlocks
+}
+
+// WriteBadBlock serializes the bad block into the database. A block already
+// stored is not written again, and the oldest bad blocks are dropped if the
+// list is full.
+func WriteBadBlock(db ethdb.KeyValueStore, block *types.Block, reason string, peer string) {
+	badBlocks := ReadAllBadBlocks(db)
+	for _, bad := range badBlocks {
+		if bad.Header.Hash() == block.Hash() {
+			return
+		}
+	}
+	badBlocks = append([]*BadBlock{{
+		Header: block.Header(),
+		Body:   block.Body(),
+		Reason: reason,
+		Peer:   peer,
+		Time:   uint64(time.Now().Unix()),
+	}}, badBlocks...)
+	if len(badBlocks) > badBlockToKeep {
+		badBlocks = badBlocks[:badBlockToKeep]
+	}
+	data, err := rlp.EncodeToBytes(badBlocks)
+	if err != nil {
+		fmt.Println("Failed to encode bad blocks", "err", err)
+		return
+	}
+	if err := db.Put(badBlockKey, data); err != nil {
+		fmt.Println("Failed to write bad blocks", "err", err)
+	}
+}
+
+// DeleteBadBlocks deletes all the bad blocks from the database.
+func DeleteBadBlocks(db ethdb.KeyValueWriter) {
+	if err := db.Delete(badBlockKey); err != nil {
+		fmt.Println("Failed to delete bad blocks", "err", err)
+	}
 }
@@ -0,0 +1,38 @@
+package rawdb
+
+import (
+	"bcsbs/core/types"
+	"fmt"
+	"math/big"
+	"testing"
+)
+
+func TestWriteBadBlockLimit(t *testing.T) {
+	db := NewMemoryDatabase()
+
+	var blocks types.Blocks
+	for i := 0; i < badBlockToKeep+8; i++ {
+		block := types.NewBlockWithHeader(&types.Header{Number: big.NewInt(int64(i))})
+		WriteBadBlock(db, block, fmt.Sprintf("reason %d", i), "peer")
+		blocks = append(blocks, block)
+	}
+	// Writing a stored block again changes nothing
+	WriteBadBlock(db, blocks[len(blocks)-1], "again", "")
+
+	badBlocks := ReadAllBadBlocks(db)
+	if len(badBlocks) != badBlockToKeep {
+		t.Fatalf("%d bad blocks, want %d", len(badBlocks), badBlockToKeep)
+	}
+	for i, bad := range badBlocks {
+		want := blocks[len(blocks)-1-i]
+		if bad.Header.Hash() != want.Hash() {
+			t.Fatalf("bad block %d: #%d, want #%d", i, bad.Header.Number, want.NumberU64())
+		}
+		if reason := fmt.Sprintf("reason %d", want.NumberU64()); bad.Reason != reason {
+			t.Errorf("bad block %d: reason %q, want %q", i, bad.Reason, reason)
+		}
+	}
+	if ReadBadBlock(db, blocks[0].Hash()) != nil {
+		t.Error("oldest bad block not dropped")
+	}
+}
@@ -9,7 +9,7 @@ import (
 	"github.com/ethereum/go-ethereum/common"
 )
 
-func ReadTxLookupEntry(db ethdb.Reader, hash common.Hash) *uint64 {
+func ReadTxLookupEntry(db ethdb.KeyValueReader, hash common.Hash) *uint64 {
 	data, _ := db.Get(txLookupKey(hash))
 	if len(data) == 0 {
 		return nil
@@ -55,6 +55,20 @@ func DeleteTxLookupEntries(db ethdb.KeyValueWriter, hashes []common.Hash) {
 	}
 }
 
+// ReadReceipt retrieves the receipt of a canonical transaction along with the
+// hash and number of its block and its index within the block.
+func ReadReceipt(db ethdb.Reader, hash common.Hash) (*types.Receipt, common.Hash, uint64, uint64) {
+	tx, blockHash, blockNumber, txIndex := ReadTransaction(db, hash)
+	if tx == nil {
+		return nil, common.Hash{}, 0, 0
+	}
+	receipts := ReadReceipts(db, blockHash, blockNumber)
+	if uint64(len(receipts)) <= txIndex {
+		return nil, common.Hash{}, 0, 0
+	}
+	return receipts[txIndex], blockHash, blockNumber, txIndex
+}
+
 func ReadTransaction(db ethdb.Reader, hash common.Hash) (*types.Transaction, common.Hash, uint64, uint64) {
 
 	blockNumber := ReadTxLookupEntry(db, hash)
@@ -0,0 +1,67 @@
+package rawdb
+
+import (
+	"bcsbs/core/types"
+	"bcsbs/ethdb"
+	"bcsbs/params"
+	"encoding/json"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+// ReadChainConfig retrieves the chain config stored for the genesis hash.
+func ReadChainConfig(db ethdb.KeyValueReader, hash common.Hash) *params.ChainConfig {
+	data, _ := db.Get(configKey(hash))
+	if len(data) == 0 {
+		return nil
+	}
+	var config params.ChainConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		fmt.Println("Invalid chain config JSON", "hash", hash, "err", err)
+		return nil
+	}
+	return &config
+}
+
+// WriteChainConfig stores the chain config for the genesis hash.
+func WriteChainConfig(db ethdb.KeyValueWriter, hash common.Hash, cfg *params.ChainConfig) {
+	if cfg == nil {
+		return
+	}
+	data, err := json.Marshal(cfg)
+	if err != nil {
+		fmt.Println("Failed to JSON encode chain config", "err", err)
+		return
+	}
+	if err := db.Put(configKey(hash), data); err != nil {
+		fmt.Println("Failed to store chain config", "err", err)
+	}
+}
+
+// ReadSignedCheckpoint retrieves the latest accepted signed checkpoint.
+func ReadSignedCheckpoint(db ethdb.KeyValueReader) *types.Checkpoint {
+	data, _ := db.Get(signedCheckpointKey)
+	if len(data) == 0 {
+		return nil
+	}
+	checkpoint := new(types.Checkpoint)
+	if err := rlp.DecodeBytes(data, checkpoint); err != nil {
+		fmt.Println("Invalid signed checkpoint RLP", "err", err)
+		return nil
+	}
+	return checkpoint
+}
+
+// WriteSignedCheckpoint stores the latest accepted signed checkpoint.
+func WriteSignedCheckpoint(db ethdb.KeyValueWriter, checkpoint *types.Checkpoint) {
+	data, err := rlp.EncodeToBytes(checkpoint)
+	if err != nil {
+		fmt.Println("Failed to RLP encode signed checkpoint", "err", err)
+		return
+	}
+	if err := db.Put(signedCheckpointKey, data); err != nil {
+		fmt.Println("Failed to store signed checkpoint", "err", err)
+	}
+}
@@ -12,19 +12,19 @@ import (
 
 // TxTrie
 
-func HasAccountData(db ethdb.Reader, addr common.Address) bool {
+func HasAccountData(db ethdb.KeyValueReader, addr common.Address) bool {
 	if has, err := db.Has(accountData(addr)); !has || err != nil {
 		return false
 	}
 	return true
 }
 
-func ReadAccountDataRLP(db ethdb.Reader, addr common.Address) rlp.RawValue {
+func ReadAccountDataRLP(db ethdb.KeyValueReader, addr common.Address) rlp.RawValue {
 	data, _ := db.Get(accountData(addr))
 	return data
 }
 
-func ReadAccountData(db ethdb.Reader, addr common.Address) *types.StateAccount {
+func ReadAccountData(db ethdb.KeyValueReader, addr common.Address) *types.StateAccount {
 	data := ReadAccountDataRLP(db, addr)
 	if len(data) == 0 {
 		return nil
@@ -38,7 +38,7 @@ func ReadAccountData(db ethdb.Reader, addr common.Address) *types.StateAccount {
 	return acc
 }
 
-func WriteAccountData(db ethdb.Writer, addr common.Address, val []byte) {
+func WriteAccountData(db ethdb.KeyValueWriter, addr common.Address, val []byte) {
 	key := accountData(addr)
 	if err := db.Put(key, val); err != nil {
 		fmt.Println("Failed to store header", "err", err)
@@ -47,14 +47,14 @@ func WriteAccountData(db ethdb.Writer, addr common.Address, val []byte) {
 
 // StorageTrie
 
-func HasStorage(db ethdb.Reader, key []byte) bool {
+func HasStorage(db ethdb.KeyValueReader, key []byte) bool {
 	if has, err := db.Has(storage(key)); !has || err != nil {
 		return false
 	}
 	return true
 }
 
-func ReadStorage(db ethdb.Reader, key []byte) []byte {
+func ReadStorage(db ethdb.KeyValueReader, key []byte) []byte {
 	data, err := db.Get(storage(key))
 	if err != nil {
 		return nil
@@ -62,9 +62,21 @@ func ReadStorage(db ethdb.Reader, key []byte) []byte {
 	return data
 }
 
-func WriteStorage(db ethdb.Writer, key, val []byte) {
+func WriteStorage(db ethdb.KeyValueWriter, key, val []byte) {
 	data := storage(key)
 	if err := db.Put(data, val[:]); err != nil {
 		fmt.Println("Failed to store header", "err", err)
 	}
 }
+
+func DeleteAccountData(db ethdb.KeyValueWriter, addr common.Address) {
+	if err := db.Delete(accountData(addr)); err != nil {
+		fmt.Println("Failed to delete account data", "err", err)
+	}
+}
+
+func DeleteStorage(db ethdb.KeyValueWriter, key []byte) {
+	if err := db.Delete(storage(key)); err != nil {
+		fmt.Println("Failed to delete storage", "err", err)
+	}
+}
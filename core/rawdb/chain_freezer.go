@@ -0,0 +1,207 @@
+package rawdb
+
+import (
+	"bcsbs/ethdb"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+const (
+	// freezerRecheckInterval is the frequency to check the key-value database for
+	// chain progression that might permit new blocks to be frozen into immutable
+	// storage.
+	freezerRecheckInterval = time.Minute
+
+	// freezerBatchLimit is the maximum number of blocks to freeze in one batch
+	// before doing an fsync and deleting it from the key-value store.
+	freezerBatchLimit = 30000
+)
+
+// chainFreezer is a wrapper of freezer with additional chain freezing feature.
+// The background thread will keep moving ancient chain segments from key-value
+// database to flat files for saving space on live database.
+type chainFreezer struct {
+	*Freezer
+
+	threshold uint64 // Number of recent blocks not to freeze
+	quit      chan struct{}
+	wg        sync.WaitGroup
+}
+
+// newChainFreezer initializes the freezer for ancient chain data.
+func newChainFreezer(datadir string, readonly bool, threshold uint64) (*chainFreezer, error) {
+	freezer, err := NewFreezer(datadir, readonly, freezerTables)
+	if err != nil {
+		return nil, err
+	}
+	return &chainFreezer{
+		Freezer:   freezer,
+		threshold: threshold,
+		quit:      make(chan struct{}),
+	}, nil
+}
+
+// Close closes the chain freezer instance and terminates the background thread.
+func (f *chainFreezer) Close() error {
+	select {
+	case <-f.quit:
+	default:
+		close(f.quit)
+	}
+	f.wg.Wait()
+	return f.Freezer.Close()
+}
+
+// freeze is a background thread that periodically checks the blockchain for
+// any import progress and moves ancient data from the fast database into the
+// freezer.
+//
+// This functionality is deliberately broken off from block importing to avoid
+// incurring additional data shuffling delays on block propagation.
+func (f *chainFreezer) freeze(db ethdb.KeyValueStore) {
+	defer f.wg.Done()
+
+	nfdb := &nofreezedb{KeyValueStore: db}
+	backoff := false
+	for {
+		if backoff {
+			timer := time.NewTimer(freezerRecheckInterval)
+			select {
+			case <-timer.C:
+			case <-f.quit:
+				timer.Stop()
+				return
+			}
+		}
+		select {
+		case <-f.quit:
+			return
+		default:
+		}
+		backoff = true
+
+		// Retrieve the freezing threshold
+		hash := ReadHeadBlockHash(nfdb)
+		if hash == (common.Hash{}) {
+			continue
+		}
+		number := ReadHeaderNumber(nfdb, hash)
+		if number == nil || *number < f.threshold {
+			continue
+		}
+		frozen, _ := f.Ancients()
+		limit := *number - f.threshold
+		if limit < frozen {
+			continue
+		}
+		if limit-frozen >= freezerBatchLimit {
+			limit = frozen + freezerBatchLimit - 1
+			backoff = false
+		}
+		if err := f.freezeRange(nfdb, frozen, limit); err != nil {
+			fmt.Println("Error in block freeze operation", "err", err)
+			backoff = true
+		}
+	}
+}
+
+// freezeRange moves the canonical blocks first to last into the freezer and
+// deletes them, and the side chain blocks of the same numbers, from the
+// key-value store. The hash to number mappings and the transaction lookup
+// entries of the canonical blocks are kept.
+func (f *chainFreezer) freezeRange(nfdb *nofreezedb, first, last uint64) error {
+	start := time.Now()
+
+	hashes := make([]common.Hash, 0, last-first+1)
+	_, err := f.ModifyAncients(func(op ethdb.AncientWriteOp) error {
+		for number := first; number <= last; number++ {
+			hash := ReadCanonicalHash(nfdb, number)
+			if hash == (common.Hash{}) {
+				return fmt.Errorf("canonical hash missing, can't freeze block %d", number)
+			}
+			header := ReadHeaderRLP(nfdb, hash, number)
+			if len(header) == 0 {
+				return fmt.Errorf("block header missing, can't freeze block %d", number)
+			}
+			body := ReadBodyRLP(nfdb, hash, number)
+			if len(body) == 0 {
+				return fmt.Errorf("block body missing, can't freeze block %d", number)
+			}
+			td, _ := nfdb.Get(headerTDKey(number, hash))
+			if len(td) == 0 {
+				return fmt.Errorf("total difficulty missing, can't freeze block %d", number)
+			}
+			// The genesis block has no state undo, and blocks stored before
+			// receipts were kept have none either
+			receipts := ReadReceiptsRLP(nfdb, hash, number)
+			undo := ReadStateUndo(nfdb, hash, number)
+
+			if err := op.AppendRaw(freezerHashTable, number, hash.Bytes()); err != nil {
+				return err
+			}
+			if err := op.AppendRaw(freezerHeaderTable, number, header); err != nil {
+				return err
+			}
+			if err := op.AppendRaw(freezerBodiesTable, number, body); err != nil {
+				return err
+			}
+			if err := op.AppendRaw(freezerReceiptTable, number, receipts); err != nil {
+				return err
+			}
+			if err := op.AppendRaw(freezerDifficultyTable, number, td); err != nil {
+				return err
+			}
+			if err := op.AppendRaw(freezerStateUndoTable, number, undo); err != nil {
+				return err
+			}
+			hashes = append(hashes, hash)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		return err
+	}
+
+	// The chain may have been reorganised while freezing, only blocks still
+	// canonical are deleted from the key-value store
+	for i, hash := range hashes {
+		if ReadCanonicalHash(nfdb, first+uint64(i)) != hash {
+			if err := f.TruncateHead(first + uint64(i)); err != nil {
+				return err
+			}
+			hashes = hashes[:i]
+			break
+		}
+	}
+	if len(hashes) == 0 {
+		return errors.New("canonical chain changed while freezing")
+	}
+	batch := nfdb.NewBatch()
+	side := 0
+	for i, hash := range hashes {
+		number := first + uint64(i)
+
+		DeleteCanonicalHash(batch, number)
+		DeleteBlockWithoutNumber(batch, hash, number)
+		DeleteStateUndo(batch, hash, number)
+
+		for _, sideHash := range ReadAllHashes(nfdb, number) {
+			if sideHash != hash {
+				DeleteBlock(batch, sideHash, number)
+				side++
+			}
+		}
+	}
+	if err := batch.Write(); err != nil {
+		return err
+	}
+	fmt.Println("Deep froze chain segment", "blocks", len(hashes), "side", side, "number", first+uint64(len(hashes))-1, "elapsed", time.Since(start))
+	return nil
+}
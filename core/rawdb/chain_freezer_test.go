@@ -0,0 +1,90 @@
+package rawdb
+
+import (
+	"bcsbs/core/types"
+	"bcsbs/ethdb/memorydb"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// writeTestChain writes a canonical chain of n empty blocks and a side block
+// at number 1 into db and returns the canonical blocks.
+func writeTestChain(db *nofreezedb, n int) (types.Blocks, *types.Block) {
+	var (
+		blocks types.Blocks
+		parent common.Hash
+	)
+	for i := 0; i < n; i++ {
+		block := types.NewBlockWithHeader(&types.Header{
+			ParentHash: parent,
+			Number:     big.NewInt(int64(i)),
+			Difficulty: big.NewInt(1),
+		})
+		WriteBlock(db, block)
+		WriteTd(db, block.Hash(), block.NumberU64(), big.NewInt(int64(i+1)))
+		WriteReceipts(db, block.Hash(), block.NumberU64(), nil)
+		WriteCanonicalHash(db, block.Hash(), block.NumberU64())
+		WriteHeadBlockHash(db, block.Hash())
+
+		blocks = append(blocks, block)
+		parent = block.Hash()
+	}
+	side := types.NewBlockWithHeader(&types.Header{
+		ParentHash: blocks[0].Hash(),
+		Number:     big.NewInt(1),
+		Difficulty: big.NewInt(2),
+	})
+	WriteBlock(db, side)
+	WriteTd(db, side.Hash(), 1, big.NewInt(3))
+
+	return blocks, side
+}
+
+func TestFreezeRangeFallbackReads(t *testing.T) {
+	kvdb := &nofreezedb{KeyValueStore: memorydb.New()}
+	blocks, side := writeTestChain(kvdb, 5)
+
+	freezer, err := newChainFreezer(t.TempDir(), false, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer freezer.Close()
+	if err := freezer.freezeRange(kvdb, 0, 2); err != nil {
+		t.Fatal(err)
+	}
+	if frozen, _ := freezer.Ancients(); frozen != 3 {
+		t.Fatalf("%d blocks frozen, want 3", frozen)
+	}
+	db := &freezerdb{KeyValueStore: kvdb.KeyValueStore, AncientStore: freezer}
+
+	for _, block := range blocks {
+		hash, number := block.Hash(), block.NumberU64()
+
+		// Frozen blocks are gone from the key-value store, except for the
+		// hash to number mapping
+		if have, want := HasHeader(kvdb, hash, number), number > 2; have != want {
+			t.Errorf("block #%d: in key-value store %v, want %v", number, have, want)
+		}
+		if n := ReadHeaderNumber(kvdb, hash); n == nil || *n != number {
+			t.Errorf("block #%d: number mapping %v", number, n)
+		}
+		if have := ReadCanonicalHash(db, number); have != hash {
+			t.Errorf("block #%d: canonical hash %x, want %x", number, have, hash)
+		}
+		if have := ReadBlock(db, hash, number); have == nil || have.Hash() != hash {
+			t.Errorf("block #%d: missing from database", number)
+		}
+		if td := ReadTd(db, hash, number); td == nil || td.Uint64() != number+1 {
+			t.Errorf("block #%d: total difficulty %v, want %d", number, td, number+1)
+		}
+	}
+	// Side blocks of frozen numbers are deleted, not served from the freezer
+	if ReadBlock(db, side.Hash(), 1) != nil {
+		t.Error("side block #1 still readable")
+	}
+	if ReadTd(db, side.Hash(), 1) != nil {
+		t.Error("total difficulty of side block #1 still readable")
+	}
+}
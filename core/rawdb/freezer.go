@@ -0,0 +1,228 @@
+package rawdb
+
+import (
+	"bcsbs/ethdb"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"sync"
+	"sync/atomic"
+
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+var (
+	// errReadOnly is returned if the freezer is opened in read only mode. All
+	// the mutations are disallowed.
+	errReadOnly = errors.New("read only")
+
+	// errUnknownTable is returned if the user attempts to read from a table
+	// that is not tracked by the freezer.
+	errUnknownTable = errors.New("unknown table")
+)
+
+// Freezer is an append-only database to store immutable ordered data into
+// flat files. Every table holds one item per number, so a table only grows
+// at the end and truncating the recent items needed by a rewind is cheap.
+type Freezer struct {
+	frozen uint64 // Number of items already frozen, the same for all tables
+
+	readonly  bool
+	writeLock sync.Mutex
+	tables    map[string]*freezerTable
+
+	closeOnce sync.Once
+}
+
+// NewFreezer creates a freezer instance for maintaining immutable ordered
+// data according to the given parameters. The tables are truncated to the
+// shortest one, as a crash may have left a write across the tables half
+// done.
+func NewFreezer(datadir string, readonly bool, tables []string) (*Freezer, error) {
+	if err := os.MkdirAll(datadir, 0755); err != nil {
+		return nil, err
+	}
+	freezer := &Freezer{
+		readonly: readonly,
+		tables:   make(map[string]*freezerTable),
+	}
+	for _, name := range tables {
+		table, err := newFreezerTable(datadir, name)
+		if err != nil {
+			freezer.Close()
+			return nil, err
+		}
+		freezer.tables[name] = table
+	}
+	if err := freezer.repair(); err != nil {
+		freezer.Close()
+		return nil, err
+	}
+	fmt.Println("Opened ancient database", "database", datadir, "readonly", readonly, "items", freezer.frozen)
+	return freezer, nil
+}
+
+// repair truncates all data tables to the same length.
+func (f *Freezer) repair() error {
+	min := uint64(math.MaxUint64)
+	for _, table := range f.tables {
+		if items := table.Items(); items < min {
+			min = items
+		}
+	}
+	for _, table := range f.tables {
+		if err := table.Truncate(min); err != nil {
+			return err
+		}
+	}
+	atomic.StoreUint64(&f.frozen, min)
+	return nil
+}
+
+// HasAncient returns an indicator whether the specified ancient data exists
+// in the freezer.
+func (f *Freezer) HasAncient(kind string, number uint64) (bool, error) {
+	if table := f.tables[kind]; table != nil {
+		return table.has(number), nil
+	}
+	return false, nil
+}
+
+// Ancient retrieves an ancient binary blob from the append-only immutable files.
+func (f *Freezer) Ancient(kind string, number uint64) ([]byte, error) {
+	if table := f.tables[kind]; table != nil {
+		return table.Retrieve(number)
+	}
+	return nil, errUnknownTable
+}
+
+// Ancients returns the length of the frozen items.
+func (f *Freezer) Ancients() (uint64, error) {
+	return atomic.LoadUint64(&f.frozen), nil
+}
+
+// AncientSize returns the ancient size of the specified category.
+func (f *Freezer) AncientSize(kind string) (uint64, error) {
+	if table := f.tables[kind]; table != nil {
+		return table.Size(), nil
+	}
+	return 0, errUnknownTable
+}
+
+// ModifyAncients runs the given write operation. The items appended by a
+// failed operation are truncated again, and the operation fails if it did
+// not append the same number of items to every table.
+func (f *Freezer) ModifyAncients(fn func(op ethdb.AncientWriteOp) error) (writeSize int64, err error) {
+	if f.readonly {
+		return 0, errReadOnly
+	}
+	f.writeLock.Lock()
+	defer f.writeLock.Unlock()
+
+	prev := atomic.LoadUint64(&f.frozen)
+	defer func() {
+		if err != nil {
+			for name, table := range f.tables {
+				if terr := table.Truncate(prev); terr != nil {
+					fmt.Println("Freezer table roll-back failed", "table", name, "index", prev, "err", terr)
+				}
+			}
+		}
+	}()
+
+	op := &freezerWriteOp{freezer: f}
+	if err := fn(op); err != nil {
+		return 0, err
+	}
+	items := f.tables[freezerHashTable].Items()
+	for name, table := range f.tables {
+		if n := table.Items(); n != items {
+			return 0, fmt.Errorf("freezer table %s has %d items, want %d", name, n, items)
+		}
+	}
+	atomic.StoreUint64(&f.frozen, items)
+	return op.size, nil
+}
+
+// TruncateHead discards any recent data above the provided threshold number.
+func (f *Freezer) TruncateHead(items uint64) error {
+	if f.readonly {
+		return errReadOnly
+	}
+	f.writeLock.Lock()
+	defer f.writeLock.Unlock()
+
+	if atomic.LoadUint64(&f.frozen) <= items {
+		return nil
+	}
+	for _, table := range f.tables {
+		if err := table.Truncate(items); err != nil {
+			return err
+		}
+	}
+	atomic.StoreUint64(&f.frozen, items)
+	return nil
+}
+
+// Sync flushes all data tables to disk.
+func (f *Freezer) Sync() error {
+	var errs []error
+	for _, table := range f.tables {
+		if err := table.Sync(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if errs != nil {
+		return fmt.Errorf("%v", errs)
+	}
+	return nil
+}
+
+// Close terminates the freezer, closing all the data files.
+func (f *Freezer) Close() error {
+	f.writeLock.Lock()
+	defer f.writeLock.Unlock()
+
+	var errs []error
+	f.closeOnce.Do(func() {
+		for _, table := range f.tables {
+			if err := table.Close(); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	})
+	if errs != nil {
+		return fmt.Errorf("%v", errs)
+	}
+	return nil
+}
+
+// freezerWriteOp appends the items of a ModifyAncients operation directly to
+// the freezer tables.
+type freezerWriteOp struct {
+	freezer *Freezer
+	size    int64
+}
+
+// Append adds an RLP-encoded item of the given kind.
+func (op *freezerWriteOp) Append(kind string, number uint64, item interface{}) error {
+	blob, err := rlp.EncodeToBytes(item)
+	if err != nil {
+		return err
+	}
+	return op.AppendRaw(kind, number, blob)
+}
+
+// AppendRaw adds an item of the given kind.
+func (op *freezerWriteOp) AppendRaw(kind string, number uint64, item []byte) error {
+	table := op.freezer.tables[kind]
+	if table == nil {
+		return errUnknownTable
+	}
+	if err := table.Append(number, item); err != nil {
+		return err
+	}
+	op.size += int64(len(item))
+	return nil
+}
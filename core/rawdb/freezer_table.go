@@ -0,0 +1,260 @@
+package rawdb
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+var (
+	// errClosed is returned if an operation attempts to read from or write to
+	// the freezer table after it has already been closed.
+	errClosed = errors.New("closed")
+
+	// errOutOfBounds is returned if the item requested is not contained
+	// within the freezer table.
+	errOutOfBounds = errors.New("out of bounds")
+
+	// errOutOrderInsertion is returned if the user attempts to inject out of
+	// order binary blobs into the freezer.
+	errOutOrderInsertion = errors.New("the append operation is out-order")
+)
+
+// indexEntrySize is the size of an index entry: the end offset of the item
+// in the data file, as a big endian uint64.
+const indexEntrySize = 8
+
+// freezerTable is an append-only table of binary blobs. The blobs are stored
+// back to back in a data file, the index file holds the end offset of every
+// blob, so item n spans from the end of item n-1 to its own end.
+type freezerTable struct {
+	name  string
+	items uint64 // number of items stored in the table
+	size  uint64 // size of the data file
+
+	data  *os.File
+	index *os.File
+
+	lock sync.RWMutex
+}
+
+// newFreezerTable opens the given path as a freezer table, repairing a data
+// and index file left inconsistent by a crash.
+func newFreezerTable(path, name string) (*freezerTable, error) {
+	if err := os.MkdirAll(path, 0755); err != nil {
+		return nil, err
+	}
+	data, err := os.OpenFile(filepath.Join(path, name+".rdat"), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	index, err := os.OpenFile(filepath.Join(path, name+".ridx"), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		data.Close()
+		return nil, err
+	}
+	t := &freezerTable{
+		name:  name,
+		data:  data,
+		index: index,
+	}
+	if err := t.repair(); err != nil {
+		t.Close()
+		return nil, err
+	}
+	return t, nil
+}
+
+// repair cross-checks the data and index files. Index entries pointing past
+// the end of the data file are dropped, as is data not covered by the index.
+func (t *freezerTable) repair() error {
+	stat, err := t.index.Stat()
+	if err != nil {
+		return err
+	}
+	items := uint64(stat.Size()) / indexEntrySize
+	if stat, err = t.data.Stat(); err != nil {
+		return err
+	}
+	size := uint64(stat.Size())
+
+	for items > 0 {
+		end, err := t.offset(items - 1)
+		if err != nil {
+			return err
+		}
+		if end <= size {
+			size = end
+			break
+		}
+		items--
+	}
+	if items == 0 {
+		size = 0
+	}
+	return t.truncate(items, size)
+}
+
+// offset returns the end offset of the item in the data file.
+func (t *freezerTable) offset(item uint64) (uint64, error) {
+	buf := make([]byte, indexEntrySize)
+	if _, err := t.index.ReadAt(buf, int64(item*indexEntrySize)); err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint64(buf), nil
+}
+
+func (t *freezerTable) truncate(items, size uint64) error {
+	if err := t.index.Truncate(int64(items * indexEntrySize)); err != nil {
+		return err
+	}
+	if err := t.data.Truncate(int64(size)); err != nil {
+		return err
+	}
+	t.items, t.size = items, size
+	return nil
+}
+
+// Truncate discards any recent data above the provided threshold number.
+func (t *freezerTable) Truncate(items uint64) error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if t.index == nil {
+		return errClosed
+	}
+	if t.items <= items {
+		return nil
+	}
+	var size uint64
+	if items > 0 {
+		end, err := t.offset(items - 1)
+		if err != nil {
+			return err
+		}
+		size = end
+	}
+	return t.truncate(items, size)
+}
+
+// Append injects a binary blob at the end of the freezer table. The item
+// number must be the next one, appends are not atomic across tables, see
+// Freezer.ModifyAncients for reverting a failed write.
+func (t *freezerTable) Append(item uint64, blob []byte) error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if t.index == nil {
+		return errClosed
+	}
+	if item != t.items {
+		return fmt.Errorf("%w: table %s have %d want %d", errOutOrderInsertion, t.name, item, t.items)
+	}
+	if _, err := t.data.WriteAt(blob, int64(t.size)); err != nil {
+		return err
+	}
+	buf := make([]byte, indexEntrySize)
+	binary.BigEndian.PutUint64(buf, t.size+uint64(len(blob)))
+	if _, err := t.index.WriteAt(buf, int64(t.items*indexEntrySize)); err != nil {
+		return err
+	}
+	t.items++
+	t.size += uint64(len(blob))
+	return nil
+}
+
+// Retrieve looks up the data offset of an item and retrieves its blob.
+func (t *freezerTable) Retrieve(item uint64) ([]byte, error) {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	if t.index == nil {
+		return nil, errClosed
+	}
+	if item >= t.items {
+		return nil, errOutOfBounds
+	}
+	var start uint64
+	if item > 0 {
+		end, err := t.offset(item - 1)
+		if err != nil {
+			return nil, err
+		}
+		start = end
+	}
+	end, err := t.offset(item)
+	if err != nil {
+		return nil, err
+	}
+	blob := make([]byte, end-start)
+	if _, err := t.data.ReadAt(blob, int64(start)); err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	return blob, nil
+}
+
+// has returns an indicator whether the specified number data exists.
+func (t *freezerTable) has(number uint64) bool {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	return number < t.items
+}
+
+// Items returns the number of items in the table.
+func (t *freezerTable) Items() uint64 {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	return t.items
+}
+
+// Size returns the total data size in the freezer table.
+func (t *freezerTable) Size() uint64 {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	return t.size + t.items*indexEntrySize
+}
+
+// Sync pushes any pending data from memory out to disk.
+func (t *freezerTable) Sync() error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if t.index == nil {
+		return errClosed
+	}
+	if err := t.data.Sync(); err != nil {
+		return err
+	}
+	return t.index.Sync()
+}
+
+// Close closes all opened files.
+func (t *freezerTable) Close() error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	var errs []error
+	if t.data != nil {
+		if err := t.data.Close(); err != nil {
+			errs = append(errs, err)
+		}
+		t.data = nil
+	}
+	if t.index != nil {
+		if err := t.index.Close(); err != nil {
+			errs = append(errs, err)
+		}
+		t.index = nil
+	}
+	if errs != nil {
+		return fmt.Errorf("%v", errs)
+	}
+	return nil
+}
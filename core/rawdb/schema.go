@@ -10,11 +10,23 @@ var (
 	headHeaderKey = []byte("LastHeader")
 	headBlockKey  = []byte("LastBlock")
 
+	// badBlockKey tracks the list of bad blocks seen by the local node
+	badBlockKey = []byte("InvalidBlock")
+
+	// signedCheckpointKey tracks the latest accepted signed checkpoint
+	signedCheckpointKey = []byte("SignedCheckpoint")
+
+	configPrefix = []byte("ethereum-config-") // configPrefix + hash -> chain config
+
 	headerPrefix       = []byte("h") // headerPrefix + num (uint64 big endian) + hash -> header
+	headerTDSuffix     = []byte("t") // headerPrefix + num (uint64 big endian) + hash + headerTDSuffix -> td
 	headerNumberPrefix = []byte("H") // headerNumberPrefix + hash -> num (uint64 big endian)
 	headerHashSuffix   = []byte("n") // headerPrefix + num (uint64 big endian) + headerHashSuffix -> hash
 
-	blockBodyPrefix = []byte("b") // blockBodyPrefix + num (uint64 big endian) + hash -> block body
+	blockBodyPrefix     = []byte("b") // blockBodyPrefix + num (uint64 big endian) + hash -> block body
+	blockReceiptsPrefix = []byte("r") // blockReceiptsPrefix + num (uint64 big endian) + hash -> block receipts
+
+	stateUndoPrefix = []byte("u") // stateUndoPrefix + num (uint64 big endian) + hash -> state undo
 
 	txLookupPrefix = []byte("l") // txLookupPrefix + hash -> transaction/receipt lookup metadata
 
@@ -23,12 +35,47 @@ var (
 	storagePrefix = []byte("s") // storagePrefix + root + key -> Storage metadata
 )
 
+const (
+	// freezerHeaderTable indicates the name of the freezer header table.
+	freezerHeaderTable = "headers"
+
+	// freezerHashTable indicates the name of the freezer canonical hash table.
+	freezerHashTable = "hashes"
+
+	// freezerBodiesTable indicates the name of the freezer block body table.
+	freezerBodiesTable = "bodies"
+
+	// freezerReceiptTable indicates the name of the freezer receipts table.
+	freezerReceiptTable = "receipts"
+
+	// freezerDifficultyTable indicates the name of the freezer total difficulty table.
+	freezerDifficultyTable = "diffs"
+
+	// freezerStateUndoTable indicates the name of the freezer state undo table.
+	freezerStateUndoTable = "undos"
+)
+
+// freezerTables lists the tables of the chain freezer, all of them hold one
+// item per canonical block.
+var freezerTables = []string{
+	freezerHeaderTable,
+	freezerHashTable,
+	freezerBodiesTable,
+	freezerReceiptTable,
+	freezerDifficultyTable,
+	freezerStateUndoTable,
+}
+
 func encodeBlockNumber(number uint64) []byte {
 	enc := make([]byte, 8)
 	binary.BigEndian.PutUint64(enc, number)
 	return enc
 }
 
+func headerTDKey(number uint64, hash common.Hash) []byte {
+	return append(headerKey(number, hash), headerTDSuffix...)
+}
+
 func headerNumberKey(hash common.Hash) []byte {
 	return append(headerNumberPrefix, hash.Bytes()...)
 }
@@ -37,6 +84,10 @@ func headerHashKey(number uint64) []byte {
 	return append(append(headerPrefix, encodeBlockNumber(number)...), headerHashSuffix...)
 }
 
+func headerKeyPrefix(number uint64) []byte {
+	return append(headerPrefix, encodeBlockNumber(number)...)
+}
+
 func headerKey(number uint64, hash common.Hash) []byte {
 	return append(append(headerPrefix, encodeBlockNumber(number)...), hash.Bytes()...)
 }
@@ -45,10 +96,22 @@ func blockBodyKey(number uint64, hash common.Hash) []byte {
 	return append(append(blockBodyPrefix, encodeBlockNumber(number)...), hash.Bytes()...)
 }
 
+func blockReceiptsKey(number uint64, hash common.Hash) []byte {
+	return append(append(blockReceiptsPrefix, encodeBlockNumber(number)...), hash.Bytes()...)
+}
+
+func stateUndoKey(number uint64, hash common.Hash) []byte {
+	return append(append(stateUndoPrefix, encodeBlockNumber(number)...), hash.Bytes()...)
+}
+
 func txLookupKey(hash common.Hash) []byte {
 	return append(txLookupPrefix, hash.Bytes()...)
 }
 
+func configKey(hash common.Hash) []byte {
+	return append(configPrefix, hash.Bytes()...)
+}
+
 func accountData(addr common.Address) []byte {
 	return append(accountDataPrefix, addr.Bytes()...)
 }
@@ -1,7 +1,22 @@
 package state
 
+import "bcsbs/ethdb"
+
 type Trie interface {
 	TryGet(key []byte) ([]byte, error)
 
 	TryUpdate(key, val []byte) error
+
+	TryDelete(key []byte) error
+
+	// TryUpdateTo and TryDeleteTo change the trie like TryUpdate and
+	// TryDelete, but write the change into w instead of the database. The
+	// change is visible through the trie right away.
+	TryUpdateTo(w ethdb.KeyValueWriter, key, val []byte) error
+
+	TryDeleteTo(w ethdb.KeyValueWriter, key []byte) error
+
+	// Purge drops the values held in memory, they are read from the
+	// database again.
+	Purge()
 }
@@ -2,55 +2,223 @@ package state
 
 import (
 	"bcsbs/core/types"
-	"bcsbs/core/vm"
+	"bcsbs/ethdb"
 	"bcsbs/trie"
+	"errors"
 	"fmt"
 	"math/big"
+	"sync"
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rlp"
 )
 
 var (
 	emptyRoot = common.HexToHash("56e81f171bcc55a6ff8345e692c0f86e5b48e01b996cadc001622fb5e363b421")
+
+	errNotCopy = errors.New("state is not a copy")
 )
 
 type StateDB struct {
 	tx_trie      Trie
 	storage_trie Trie
 
-	evm *vm.EVM
+	// parent is the state a copy was made from. Writes to a copy are
+	// buffered until Commit.
+	parent *StateDB
+
+	// root is the state root of the committed state. A copy holds the root
+	// of the state it was made from.
+	root     common.Hash
+	rootLock sync.RWMutex
 
-	stateObjects map[common.Address]*stateObject
+	stateObjects     map[common.Address]*stateObject
+	stateObjectsLock sync.Mutex
+
+	snapshots []snapshot
 }
 
-func New(tx_trie, storage_trie Trie, blockCtx *vm.BlockContext) (*StateDB, error) {
+// snapshot holds the lengths of the write journals of a copy.
+type snapshot struct {
+	accounts, storage int
+}
+
+func New(tx_trie, storage_trie Trie) (*StateDB, error) {
 	sdb := &StateDB{
 		tx_trie:      tx_trie,
 		storage_trie: storage_trie,
 
 		stateObjects: make(map[common.Address]*stateObject),
 	}
-	sdb.evm = vm.NewEVM(sdb, blockCtx)
 	return sdb, nil
 }
 
+// Copy returns a state on top of s. Changes made to the copy are not
+// visible in s until they are committed.
 func (s *StateDB) Copy() *StateDB {
 	st := &StateDB{
-		tx_trie:      s.tx_trie,
-		storage_trie: s.storage_trie,
+		tx_trie:      newOverlayTrie(s.tx_trie),
+		storage_trie: newOverlayTrie(s.storage_trie),
+		parent:       s,
+		root:         s.Root(),
 		stateObjects: make(map[common.Address]*stateObject),
 	}
+	return st
+}
 
-	st.stateObjects[common.Address{}] = nil
+// Commit writes the changes of a copy into the state it was made from and
+// returns the overwritten values, which Revert uses to undo the changes.
+// The database writes are staged in w, the changes are visible through the
+// state right away.
+func (s *StateDB) Commit(w ethdb.KeyValueWriter) (*Undo, error) {
+	if s.parent == nil {
+		return nil, errNotCopy
+	}
+	root := s.IntermediateRoot()
 
-	for addr, state := range s.stateObjects {
-		if state != nil {
-			st.stateObjects[addr] = state.deepCopy(s)
-		}
+	accounts, err := s.tx_trie.(*overlayTrie).commit(w)
+	if err != nil {
+		return nil, err
 	}
+	storage, err := s.storage_trie.(*overlayTrie).commit(w)
+	if err != nil {
+		return nil, err
+	}
+	s.parent.dropStateObjects(accounts)
+	s.snapshots = nil
 
-	return st
+	return &Undo{Accounts: accounts, Storage: storage, Root: s.parent.swapRoot(root)}, nil
+}
+
+// Revert restores the values overwritten by a committed copy, staging the
+// database writes in w.
+func (s *StateDB) Revert(undo *Undo, w ethdb.KeyValueWriter) error {
+	if err := revert(s.storage_trie, undo.Storage, w); err != nil {
+		return err
+	}
+	if err := revert(s.tx_trie, undo.Accounts, w); err != nil {
+		return err
+	}
+	s.dropStateObjects(undo.Accounts)
+	s.SetRoot(undo.Root)
+
+	return nil
+}
+
+// Reload drops the state held in memory and points the state at root. It is
+// used when staged changes were discarded instead of written.
+func (s *StateDB) Reload(root common.Hash) {
+	s.tx_trie.Purge()
+	s.storage_trie.Purge()
+
+	s.stateObjectsLock.Lock()
+	s.stateObjects = make(map[common.Address]*stateObject)
+	s.stateObjectsLock.Unlock()
+
+	s.SetRoot(root)
+}
+
+// Snapshot returns an identifier for the current changes of a copy, to be
+// passed to RevertToSnapshot.
+func (s *StateDB) Snapshot() int {
+	if s.parent == nil {
+		panic(errNotCopy)
+	}
+	s.snapshots = append(s.snapshots, snapshot{
+		accounts: s.tx_trie.(*overlayTrie).snapshot(),
+		storage:  s.storage_trie.(*overlayTrie).snapshot(),
+	})
+	return len(s.snapshots) - 1
+}
+
+// RevertToSnapshot drops the changes made to a copy since the snapshot was
+// taken.
+func (s *StateDB) RevertToSnapshot(id int) {
+	if id < 0 || id >= len(s.snapshots) {
+		panic(fmt.Errorf("state snapshot %d cannot be reverted to", id))
+	}
+	snap := s.snapshots[id]
+	s.tx_trie.(*overlayTrie).revertToSnapshot(snap.accounts)
+	s.storage_trie.(*overlayTrie).revertToSnapshot(snap.storage)
+	s.snapshots = s.snapshots[:id]
+
+	// Cached objects may hold reverted account data
+	s.stateObjectsLock.Lock()
+	s.stateObjects = make(map[common.Address]*stateObject)
+	s.stateObjectsLock.Unlock()
+}
+
+// CompareParent checks that the changes of a copy match the values of the
+// state it was made from. It returns the number of accounts and storage slots
+// compared and an error for the first one that differs.
+func (s *StateDB) CompareParent() (accounts int, slots int, err error) {
+	if s.parent == nil {
+		return 0, 0, errNotCopy
+	}
+	accounts, key, err := s.tx_trie.(*overlayTrie).compare()
+	if err != nil {
+		return accounts, 0, fmt.Errorf("account %x: %v", key, err)
+	}
+	slots, key, err = s.storage_trie.(*overlayTrie).compare()
+	if err != nil {
+		return accounts, slots, fmt.Errorf("storage slot %x: %v", key, err)
+	}
+	return accounts, slots, nil
+}
+
+// Root returns the root of the committed state.
+func (s *StateDB) Root() common.Hash {
+	s.rootLock.RLock()
+	defer s.rootLock.RUnlock()
+
+	return s.root
+}
+
+// SetRoot sets the root of the committed state, which is the root of the
+// block the state belongs to.
+func (s *StateDB) SetRoot(root common.Hash) {
+	s.swapRoot(root)
+}
+
+func (s *StateDB) swapRoot(root common.Hash) common.Hash {
+	s.rootLock.Lock()
+	defer s.rootLock.Unlock()
+
+	prev := s.root
+	s.root = root
+	return prev
+}
+
+// IntermediateRoot returns the state root including the changes made to a
+// copy. There is no state trie: the root is the hash of the changes, sorted
+// by key, chained onto the root the copy was made from. Nodes that executed
+// the same chain differently therefore end up with different roots.
+func (s *StateDB) IntermediateRoot() common.Hash {
+	root := s.Root()
+	if s.parent == nil {
+		return root
+	}
+	accounts := s.tx_trie.(*overlayTrie).changes()
+	storage := s.storage_trie.(*overlayTrie).changes()
+	if len(accounts) == 0 && len(storage) == 0 {
+		return root
+	}
+	data, err := rlp.EncodeToBytes([]interface{}{root, accounts, storage})
+	if err != nil {
+		panic(fmt.Errorf("state root encoding error: %v", err))
+	}
+	return crypto.Keccak256Hash(data)
+}
+
+// dropStateObjects evicts the cached objects of changed accounts.
+func (s *StateDB) dropStateObjects(accounts []UndoEntry) {
+	s.stateObjectsLock.Lock()
+	defer s.stateObjectsLock.Unlock()
+
+	for _, entry := range accounts {
+		delete(s.stateObjects, common.BytesToAddress(entry.Key))
+	}
 }
 
 // STATE
@@ -63,7 +231,11 @@ func (s *StateDB) getStateObject(addr common.Address) *stateObject {
 }
 
 func (s *StateDB) getDeletedStateObject(addr common.Address) *stateObject {
-	if obj := s.stateObjects[addr]; obj != nil {
+	s.stateObjectsLock.Lock()
+	obj := s.stateObjects[addr]
+	s.stateObjectsLock.Unlock()
+
+	if obj != nil {
 		return obj
 	}
 
@@ -81,12 +253,15 @@ func (s *StateDB) getDeletedStateObject(addr common.Address) *stateObject {
 		}
 	}
 
-	obj := newObject(s, addr, *data)
+	obj = newObject(s, addr, *data)
 	s.setStateObject(obj)
 	return obj
 }
 
 func (s *StateDB) setStateObject(object *stateObject) {
+	s.stateObjectsLock.Lock()
+	defer s.stateObjectsLock.Unlock()
+
 	s.stateObjects[object.Address()] = object
 }
 
@@ -119,20 +294,6 @@ func (s *StateDB) createObject(addr common.Address) (newobj, prev *stateObject)
 
 // UPDATE
 
-func (s *StateDB) ApplyTx(tx *types.Transaction) bool {
-	stateObject := s.GetOrNewStateObject(*tx.Sender())
-	if stateObject != nil && stateObject.Balance().Cmp(tx.Value()) >= 0 {
-		if *tx.To() != (common.Address{}) {
-			s.evm.Call(vm.AccountRef(*tx.Sender()), *tx.To(), tx.Data(), tx.Value())
-		} else {
-			s.evm.Create(vm.AccountRef(*tx.Sender()), tx.Data(), tx.Value())
-		}
-
-		return true
-	}
-	return false
-}
-
 func (s *StateDB) UpdateStateObject(obj *stateObject) {
 	addr := obj.Address()
 
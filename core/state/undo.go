@@ -0,0 +1,188 @@
+package state
+
+import (
+	"bcsbs/ethdb"
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+var errNotFound = errors.New("Not find key")
+
+// Undo holds the values a block overwrote in the state, so that the block
+// can be rolled back during a chain reorganisation.
+type Undo struct {
+	Accounts []UndoEntry
+	Storage  []UndoEntry
+	Root     common.Hash `rlp:"optional"` // state root before the block
+}
+
+// UndoEntry is the previous value of a key. An empty value means the key
+// did not exist.
+type UndoEntry struct {
+	Key   []byte
+	Value []byte
+}
+
+// overlayTrie buffers the writes to a trie until they are committed.
+type overlayTrie struct {
+	trie    Trie
+	dirty   map[string][]byte // nil marks a deleted key
+	keys    []string          // dirty keys in write order
+	journal []journalEntry    // buffered writes, for reverting to a snapshot
+}
+
+// journalEntry is the buffered value of a key before a write.
+type journalEntry struct {
+	key   string
+	prev  []byte
+	dirty bool // whether the key was buffered before the write
+}
+
+func newOverlayTrie(trie Trie) *overlayTrie {
+	return &overlayTrie{
+		trie:  trie,
+		dirty: make(map[string][]byte),
+	}
+}
+
+func (t *overlayTrie) TryGet(key []byte) ([]byte, error) {
+	if val, ok := t.dirty[string(key)]; ok {
+		if val == nil {
+			return nil, errNotFound
+		}
+		return val, nil
+	}
+	return t.trie.TryGet(key)
+}
+
+func (t *overlayTrie) TryUpdate(key, val []byte) error {
+	t.set(key, common.CopyBytes(val))
+	return nil
+}
+
+func (t *overlayTrie) TryDelete(key []byte) error {
+	t.set(key, nil)
+	return nil
+}
+
+// TryUpdateTo buffers the write like TryUpdate, w is only written on commit.
+func (t *overlayTrie) TryUpdateTo(w ethdb.KeyValueWriter, key, val []byte) error {
+	return t.TryUpdate(key, val)
+}
+
+// TryDeleteTo buffers the deletion like TryDelete, w is only written on commit.
+func (t *overlayTrie) TryDeleteTo(w ethdb.KeyValueWriter, key []byte) error {
+	return t.TryDelete(key)
+}
+
+// Purge drops the buffered writes.
+func (t *overlayTrie) Purge() {
+	t.dirty = make(map[string][]byte)
+	t.keys = nil
+	t.journal = nil
+}
+
+func (t *overlayTrie) set(key, val []byte) {
+	prev, ok := t.dirty[string(key)]
+	if !ok {
+		t.keys = append(t.keys, string(key))
+	}
+	t.journal = append(t.journal, journalEntry{key: string(key), prev: prev, dirty: ok})
+	t.dirty[string(key)] = val
+}
+
+// snapshot returns an identifier of the buffered writes so far.
+func (t *overlayTrie) snapshot() int {
+	return len(t.journal)
+}
+
+// revertToSnapshot drops the writes buffered after the snapshot was taken.
+func (t *overlayTrie) revertToSnapshot(snapshot int) {
+	for i := len(t.journal) - 1; i >= snapshot; i-- {
+		entry := t.journal[i]
+		if entry.dirty {
+			t.dirty[entry.key] = entry.prev
+			continue
+		}
+		// Keys are appended on their first write, so this is the last one
+		delete(t.dirty, entry.key)
+		t.keys = t.keys[:len(t.keys)-1]
+	}
+	t.journal = t.journal[:snapshot]
+}
+
+// changes returns the buffered changes sorted by key.
+func (t *overlayTrie) changes() []UndoEntry {
+	keys := make([]string, len(t.keys))
+	copy(keys, t.keys)
+	sort.Strings(keys)
+
+	changes := make([]UndoEntry, 0, len(keys))
+	for _, key := range keys {
+		changes = append(changes, UndoEntry{Key: []byte(key), Value: t.dirty[key]})
+	}
+	return changes
+}
+
+// commit writes the buffered changes into the underlying trie, staging them
+// in w, and returns the values they replaced.
+func (t *overlayTrie) commit(w ethdb.KeyValueWriter) ([]UndoEntry, error) {
+	undo := make([]UndoEntry, 0, len(t.keys))
+	for _, key := range t.keys {
+		prev, err := t.trie.TryGet([]byte(key))
+		if err != nil {
+			prev = nil
+		}
+		undo = append(undo, UndoEntry{Key: []byte(key), Value: prev})
+
+		if val := t.dirty[key]; val == nil {
+			err = t.trie.TryDeleteTo(w, []byte(key))
+		} else {
+			err = t.trie.TryUpdateTo(w, []byte(key), val)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	t.dirty = make(map[string][]byte)
+	t.keys = nil
+	t.journal = nil
+
+	return undo, nil
+}
+
+// revert restores the values recorded in undo, newest change first. The
+// writes are staged in w.
+func revert(trie Trie, undo []UndoEntry, w ethdb.KeyValueWriter) error {
+	for i := len(undo) - 1; i >= 0; i-- {
+		var err error
+		if entry := undo[i]; len(entry.Value) == 0 {
+			err = trie.TryDeleteTo(w, entry.Key)
+		} else {
+			err = trie.TryUpdateTo(w, entry.Key, entry.Value)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// compare checks the buffered changes against the values of the underlying
+// trie and returns the first key that differs.
+func (t *overlayTrie) compare() (int, []byte, error) {
+	for i, key := range t.keys {
+		want, err := t.trie.TryGet([]byte(key))
+		if err != nil {
+			want = nil
+		}
+		if have := t.dirty[key]; !bytes.Equal(have, want) {
+			return i, []byte(key), fmt.Errorf("have %x, want %x", have, want)
+		}
+	}
+	return len(t.keys), nil, nil
+}
@@ -0,0 +1,44 @@
+package core
+
+import (
+	"bcsbs/consensus"
+	"bcsbs/core/state"
+	"bcsbs/core/types"
+	"fmt"
+)
+
+// StateProcessor executes the transactions of a block on top of the state
+// of its parent.
+type StateProcessor struct {
+	bc     *BlockChain
+	engine consensus.Engine
+}
+
+func NewStateProcessor(blockchain *BlockChain, engine consensus.Engine) *StateProcessor {
+	return &StateProcessor{
+		bc:     blockchain,
+		engine: engine,
+	}
+}
+
+// Process applies every transaction of the block to statedb and finalizes
+// the block. A transaction that cannot be applied makes the whole block
+// invalid, statedb must then be discarded.
+func (p *StateProcessor) Process(block *types.Block, statedb *state.StateDB) (types.Receipts, uint64, error) {
+	var (
+		receipts types.Receipts
+		usedGas  uint64
+		header   = block.Header()
+	)
+	for i, tx := range block.Transactions() {
+		receipt, err := ApplyTransaction(p.bc.Config(), statedb, header, tx, &usedGas)
+		if err != nil {
+			return nil, 0, fmt.Errorf("could not apply tx %d [%x..]: %w", i, tx.Hash().Bytes()[:4], err)
+		}
+		receipt.BlockHash = block.Hash()
+		receipt.TransactionIndex = uint(i)
+		receipts = append(receipts, receipt)
+	}
+	p.engine.Finalize(header, statedb, block.Transactions())
+	return receipts, usedGas, nil
+}
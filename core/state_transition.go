@@ -0,0 +1,123 @@
+package core
+
+import (
+	"bcsbs/core/state"
+	"bcsbs/core/types"
+	"bcsbs/core/vm"
+	"bcsbs/params"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// ExecutionResult is the outcome of a transaction run by the EVM.
+type ExecutionResult struct {
+	ContractAddress common.Address // address of the created contract, if any
+	ReturnData      []byte
+	Err             error // error the EVM stopped with, nil on success
+}
+
+// Failed reports whether the execution ended with an error.
+func (result *ExecutionResult) Failed() bool { return result.Err != nil }
+
+// IntrinsicGas computes the gas charged for a transaction with the given
+// data. There is no gas metering inside the EVM, so this is all a
+// transaction costs.
+func IntrinsicGas(data []byte, isContractCreation bool) (uint64, error) {
+	gas := params.TxGas
+	if isContractCreation {
+		gas = params.TxGasContractCreation
+	}
+	var nz uint64
+	for _, byt := range data {
+		if byt != 0 {
+			nz++
+		}
+	}
+	if (math.MaxUint64-gas)/params.TxDataNonZeroGas < nz {
+		return 0, ErrGasUintOverflow
+	}
+	gas += nz * params.TxDataNonZeroGas
+
+	z := uint64(len(data)) - nz
+	if (math.MaxUint64-gas)/params.TxDataZeroGas < z {
+		return 0, ErrGasUintOverflow
+	}
+	gas += z * params.TxDataZeroGas
+
+	return gas, nil
+}
+
+// ApplyMessage runs tx in the EVM. An error is returned only if the nonce of
+// tx is not the next one of the sender or the sender cannot pay the value,
+// the transaction is then not executed at all. Errors raised during
+// execution are reported in the result, all state changes except the nonce
+// increment are then reverted. statedb must be a copy.
+func ApplyMessage(evm *vm.EVM, statedb *state.StateDB, tx *types.Transaction) (*ExecutionResult, error) {
+	sender := *tx.Sender()
+	if nonce := statedb.GetNonce(sender); tx.Nonce() < nonce {
+		return nil, fmt.Errorf("%w: address %v, tx: %d state: %d", ErrNonceTooLow, sender, tx.Nonce(), nonce)
+	} else if tx.Nonce() > nonce {
+		return nil, fmt.Errorf("%w: address %v, tx: %d state: %d", ErrNonceTooHigh, sender, tx.Nonce(), nonce)
+	}
+	if stateObject := statedb.GetOrNewStateObject(sender); stateObject == nil || stateObject.Balance().Cmp(tx.Value()) < 0 {
+		return nil, vm.ErrInsufficientBalance
+	}
+
+	result, snapshot := new(ExecutionResult), statedb.Snapshot()
+	if to := tx.To(); to != nil && *to != (common.Address{}) {
+		result.ReturnData, result.Err = evm.Call(vm.AccountRef(sender), *to, tx.Data(), tx.Value())
+	} else {
+		result.ReturnData, result.ContractAddress, result.Err = evm.Create(vm.AccountRef(sender), tx.Data(), tx.Value())
+	}
+	if result.Failed() {
+		statedb.RevertToSnapshot(snapshot)
+		statedb.SetNonce(sender, tx.Nonce()+1)
+	}
+	return result, nil
+}
+
+// ApplyTransaction applies tx to statedb as part of the block described by
+// header, adds the gas it used to usedGas and returns its receipt. A failed
+// execution still yields a receipt. An error is returned if the transaction
+// cannot be included at all, the state is then not changed.
+//
+// The transaction is executed with the rules config activates at the block.
+func ApplyTransaction(config *params.ChainConfig, statedb *state.StateDB, header *types.Header, tx *types.Transaction, usedGas *uint64) (*types.Receipt, error) {
+	contractCreation := tx.To() == nil || *tx.To() == (common.Address{})
+
+	gas, err := IntrinsicGas(tx.Data(), contractCreation)
+	if err != nil {
+		return nil, err
+	}
+	if header.GasLimit-*usedGas < gas {
+		return nil, fmt.Errorf("%w: have %d, want %d", ErrGasLimitReached, header.GasLimit-*usedGas, gas)
+	}
+	blockCtx := NewEVMBlockContext(header)
+	result, err := ApplyMessage(vm.NewEVM(statedb, &blockCtx, config), statedb, tx)
+	if errors.Is(err, vm.ErrInsufficientBalance) {
+		return nil, ErrInsufficientFunds
+	} else if err != nil {
+		return nil, err
+	}
+	*usedGas += gas
+
+	receipt := &types.Receipt{
+		Status:            types.ReceiptStatusSuccessful,
+		CumulativeGasUsed: *usedGas,
+		TxHash:            tx.Hash(),
+		GasUsed:           gas,
+		BlockNumber:       new(big.Int).Set(header.Number),
+	}
+	if result.Failed() {
+		receipt.Status = types.ReceiptStatusFailed
+		receipt.Err = result.Err.Error()
+	}
+	if contractCreation {
+		receipt.ContractAddress = result.ContractAddress
+	}
+	return receipt, nil
+}
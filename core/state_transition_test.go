@@ -0,0 +1,107 @@
+package core
+
+import (
+	"bcsbs/core/rawdb"
+	"bcsbs/core/state"
+	"bcsbs/core/types"
+	"bcsbs/params"
+	"bcsbs/trie"
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+func newTestState(t testing.TB) *state.StateDB {
+	db := rawdb.NewMemoryDatabase()
+	txTrie, err := trie.NewTxTrie(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	storageTrie, err := trie.NewStorageTrie(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	statedb, err := state.New(txTrie, storageTrie)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return statedb.Copy()
+}
+
+func TestApplyTransactionNonce(t *testing.T) {
+	var (
+		config  = params.DefaultChainConfig
+		signer  = types.LatestSigner(config)
+		key, _  = crypto.GenerateKey()
+		from    = crypto.PubkeyToAddress(key.PublicKey)
+		to      = common.HexToAddress("0x1000")
+		header  = &types.Header{Number: big.NewInt(1), GasLimit: params.GenesisGasLimit}
+		statedb = newTestState(t)
+	)
+	statedb.AddBalance(from, big.NewInt(1000))
+
+	tests := []struct {
+		nonce uint64
+		err   error
+	}{
+		{nonce: 1, err: ErrNonceTooHigh},
+		{nonce: 0},
+		{nonce: 0, err: ErrNonceTooLow},
+		{nonce: 1},
+	}
+	for i, tt := range tests {
+		tx, err := types.SignTx(types.NewTransaction(tt.nonce, to, big.NewInt(10), nil), signer, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var usedGas uint64
+		if _, err := ApplyTransaction(config, statedb, header, tx, &usedGas); !errors.Is(err, tt.err) {
+			t.Errorf("test %d: nonce %d: error %v, want %v", i, tt.nonce, err, tt.err)
+		}
+	}
+	if nonce := statedb.GetNonce(from); nonce != 2 {
+		t.Errorf("sender nonce %d, want 2", nonce)
+	}
+	if balance := statedb.GetBalance(to); balance.Cmp(big.NewInt(20)) != 0 {
+		t.Errorf("receiver balance %v, want 20", balance)
+	}
+}
+
+func TestApplyTransactionFailedRevert(t *testing.T) {
+	var (
+		config  = params.DefaultChainConfig
+		signer  = types.LatestSigner(config)
+		key, _  = crypto.GenerateKey()
+		from    = crypto.PubkeyToAddress(key.PublicKey)
+		to      = crypto.CreateAddress(from, 0)
+		header  = &types.Header{Number: big.NewInt(1), GasLimit: params.GenesisGasLimit}
+		statedb = newTestState(t)
+	)
+	statedb.AddBalance(from, big.NewInt(1000))
+
+	// The init code hits an invalid opcode, the endowment must not stick
+	tx, err := types.SignTx(types.NewContractCreation(0, big.NewInt(10), []byte{0xfe}), signer, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var usedGas uint64
+	receipt, err := ApplyTransaction(config, statedb, header, tx, &usedGas)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if receipt.Status != types.ReceiptStatusFailed {
+		t.Fatalf("receipt status %d, want %d", receipt.Status, types.ReceiptStatusFailed)
+	}
+	if nonce := statedb.GetNonce(from); nonce != 1 {
+		t.Errorf("sender nonce %d, want 1", nonce)
+	}
+	if balance := statedb.GetBalance(from); balance.Cmp(big.NewInt(1000)) != 0 {
+		t.Errorf("sender balance %v, want 1000", balance)
+	}
+	if balance := statedb.GetBalance(to); balance.Sign() != 0 {
+		t.Errorf("contract balance %v, want 0", balance)
+	}
+}
@@ -55,6 +55,19 @@ func (l *txList) Remove(tx *types.Transaction) (bool, types.Transactions) {
 	return true, nil
 }
 
+// Forward removes all transactions with a nonce lower than threshold and
+// returns them.
+func (l *txList) Forward(threshold uint64) types.Transactions {
+	var removed types.Transactions
+	for _, tx := range l.txs.Flatten() {
+		if tx.Nonce() < threshold {
+			l.txs.Remove(tx.Nonce())
+			removed = append(removed, tx)
+		}
+	}
+	return removed
+}
+
 func (l *txList) Flatten() types.Transactions {
 	return l.txs.Flatten()
 }
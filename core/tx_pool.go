@@ -3,6 +3,7 @@ package core
 import (
 	"bcsbs/core/state"
 	"bcsbs/core/types"
+	"bcsbs/event"
 	"errors"
 	"fmt"
 	"sync"
@@ -13,6 +14,8 @@ import (
 
 const (
 	txSlotSize = 32 * 1024
+
+	chainHeadChanSize = 10
 )
 
 var (
@@ -23,7 +26,11 @@ var (
 )
 
 type blockChain interface {
+	CurrentBlock() *types.Block
+	GetBlock(hash common.Hash, number uint64) *types.Block
 	StateAt() (*state.StateDB, error)
+
+	SubscribeChainHeadEvent(ch chan<- ChainHeadEvent) event.Subscription
 }
 
 type TxPool struct {
@@ -31,8 +38,11 @@ type TxPool struct {
 	signer types.Signer
 	mu     sync.RWMutex
 
-	txsCh chan<- NewTxsEvent
+	txFeed       event.FeedOf[NewTxsEvent]
+	chainHeadCh  chan ChainHeadEvent
+	chainHeadSub event.Subscription
 
+	currentHead  *types.Block
 	currentState *state.StateDB
 
 	pending map[common.Address]*txList
@@ -46,29 +56,138 @@ func NewTxPool(chain blockChain, signer types.Signer) *TxPool {
 		chain:  chain,
 		signer: signer,
 
-		txsCh: make(chan NewTxsEvent),
-
 		pending: make(map[common.Address]*txList),
 		queue:   make(map[common.Address]*txList),
 		beats:   make(map[common.Address]time.Time),
 		all:     newTxLookup(),
+
+		chainHeadCh: make(chan ChainHeadEvent, chainHeadChanSize),
 	}
 
-	pool.reset()
+	pool.reset(nil, chain.CurrentBlock())
+
+	pool.chainHeadSub = chain.SubscribeChainHeadEvent(pool.chainHeadCh)
+	go pool.loop()
+
 	return pool
 }
 
-func (pool *TxPool) SubscribeNewTxsEvent(ch chan<- NewTxsEvent) {
-	pool.txsCh = ch
+// loop keeps the pool in line with the canonical chain.
+func (pool *TxPool) loop() {
+	for {
+		select {
+		case ev := <-pool.chainHeadCh:
+			pool.mu.Lock()
+			reinjected := pool.reset(pool.currentHead, ev.Block)
+			pool.mu.Unlock()
+
+			if len(reinjected) > 0 {
+				pool.txFeed.Send(NewTxsEvent{reinjected})
+			}
+
+		// Stop was called
+		case <-pool.chainHeadSub.Err():
+			return
+		}
+	}
+}
+
+// Stop terminates the transaction pool.
+func (pool *TxPool) Stop() {
+	pool.chainHeadSub.Unsubscribe()
+}
+
+// SubscribeNewTxsEvent registers a subscription of NewTxsEvent and starts
+// sending events to the given channel.
+func (pool *TxPool) SubscribeNewTxsEvent(ch chan<- NewTxsEvent) event.Subscription {
+	return pool.txFeed.Subscribe(ch)
 }
 
-func (pool *TxPool) reset() {
+func (pool *TxPool) sendNewTxsEvent(txs []*types.Transaction, errs []error) {
+	news := make([]*types.Transaction, 0, len(txs))
+	for i, tx := range txs {
+		if errs[i] == nil {
+			news = append(news, tx)
+		}
+	}
+	if len(news) == 0 {
+		return
+	}
+	pool.txFeed.Send(NewTxsEvent{news})
+}
+
+// reset switches the pool to the state of newHead. If oldHead is not an
+// ancestor of newHead, the transactions of the dropped blocks are added back
+// to the pool. Transactions included in the chain are removed. The added
+// transactions are returned, so they can be announced once the pool lock is
+// released.
+func (pool *TxPool) reset(oldHead, newHead *types.Block) types.Transactions {
+	var reinject, reinjected types.Transactions
+
+	if oldHead != nil && oldHead.Hash() != newHead.ParentHash() {
+		var (
+			rem = oldHead
+			add = newHead
+
+			discarded, included types.Transactions
+		)
+		for rem != nil && add != nil && rem.NumberU64() > add.NumberU64() {
+			discarded = append(discarded, rem.Transactions()...)
+			rem = pool.chain.GetBlock(rem.ParentHash(), rem.NumberU64()-1)
+		}
+		for rem != nil && add != nil && add.NumberU64() > rem.NumberU64() {
+			included = append(included, add.Transactions()...)
+			add = pool.chain.GetBlock(add.ParentHash(), add.NumberU64()-1)
+		}
+		for rem != nil && add != nil && rem.Hash() != add.Hash() {
+			discarded = append(discarded, rem.Transactions()...)
+			rem = pool.chain.GetBlock(rem.ParentHash(), rem.NumberU64()-1)
+			included = append(included, add.Transactions()...)
+			add = pool.chain.GetBlock(add.ParentHash(), add.NumberU64()-1)
+		}
+		if rem == nil || add == nil {
+			fmt.Println("Skipping transaction reset caused by missing block", "old", oldHead.Hash(), "new", newHead.Hash())
+		} else {
+			reinject = types.TxDifference(discarded, included)
+		}
+	}
+
 	statedb, err := pool.chain.StateAt()
 	if err != nil {
 		fmt.Println(fmt.Errorf("Failed to reset txpool state %s", err))
-		return
+		return nil
 	}
 	pool.currentState = statedb
+	pool.currentHead = newHead
+
+	if len(reinject) > 0 {
+		fmt.Println("Reinjecting stale transactions", "count", len(reinject))
+		errs, _ := pool.addTxsLocked(reinject)
+		for i, tx := range reinject {
+			if errs[i] == nil {
+				reinjected = append(reinjected, tx)
+			}
+		}
+	}
+	pool.demoteUnexecutables()
+	pool.update()
+
+	return reinjected
+}
+
+// demoteUnexecutables drops the transactions whose nonce was already used
+// in the chain.
+func (pool *TxPool) demoteUnexecutables() {
+	for _, all := range []map[common.Address]*txList{pool.pending, pool.queue} {
+		for addr, list := range all {
+			for _, tx := range list.Forward(pool.currentState.GetNonce(addr)) {
+				pool.all.Remove(tx.Hash())
+			}
+			if list.Len() == 0 {
+				delete(all, addr)
+			}
+		}
+	}
 }
 
 func (pool *TxPool) Stats() (int, int) {
@@ -106,6 +225,13 @@ func (pool *TxPool) Content() (map[common.Address]types.Transactions, map[common
 }
 
 func (pool *TxPool) Update() {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+
+	pool.update()
+}
+
+func (pool *TxPool) update() {
 	for addr, txl := range pool.queue {
 		if pool.pending[addr] == nil {
 			pool.pending[addr] = newTxList(false)
@@ -119,15 +245,22 @@ func (pool *TxPool) Update() {
 }
 
 func (pool *TxPool) RemoveTx(hash common.Hash) {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+
 	tx := pool.all.Get(hash)
 	if tx == nil {
 		return
 	}
 
 	addr, _ := types.Sender(pool.signer, tx)
-	if pool.pending[addr] != nil {
-		delete(pool.pending, addr)
+	if list := pool.pending[addr]; list != nil {
+		list.Remove(tx)
+		if list.Len() == 0 {
+			delete(pool.pending, addr)
+		}
 	}
+	pool.all.Remove(hash)
 }
 
 func (pool *TxPool) Pending() map[common.Address]types.Transactions {
@@ -146,6 +279,25 @@ func (pool *TxPool) Pending() map[common.Address]types.Transactions {
 	return pending
 }
 
+// Nonce returns the next nonce of addr, counting the transactions of addr
+// that are already in the pool.
+func (pool *TxPool) Nonce(addr common.Address) uint64 {
+	pool.mu.RLock()
+	defer pool.mu.RUnlock()
+
+	nonce := pool.currentState.GetNonce(addr)
+	for _, all := range []map[common.Address]*txList{pool.pending, pool.queue} {
+		if list := all[addr]; list != nil {
+			for _, tx := range list.Flatten() {
+				if tx.Nonce() == nonce {
+					nonce++
+				}
+			}
+		}
+	}
+	return nonce
+}
+
 func (pool *TxPool) validateTx(tx *types.Transaction) error {
 	if tx.Value().Sign() < 0 {
 		return ErrNegativeValue
@@ -156,6 +308,10 @@ func (pool *TxPool) validateTx(tx *types.Transaction) error {
 		return ErrInvalidSender
 	}
 
+	if *tx.Sender() != from {
+		return ErrInvalidSender
+	}
+
 	if pool.currentState.GetNonce(from) > tx.Nonce() {
 		return ErrNonceTooLow
 	}
@@ -227,7 +383,7 @@ func (pool *TxPool) AddLocal(tx *types.Transaction) error {
 
 func (pool *TxPool) AddLocals(txs []*types.Transaction) []error {
 	err := pool.addTxs(txs, true)
-	pool.txsCh <- NewTxsEvent{txs}
+	pool.sendNewTxsEvent(txs, err)
 
 	return err
 }
@@ -235,11 +391,29 @@ func (pool *TxPool) AddLocals(txs []*types.Transaction) []error {
 func (pool *TxPool) AddLocalsAndUpdate(txs []*types.Transaction) []error {
 	err := pool.addTxs(txs, true)
 	pool.Update()
-	pool.txsCh <- NewTxsEvent{txs}
+	pool.sendNewTxsEvent(txs, err)
 
 	return err
 }
 
+// AddRemotes enqueues transactions received from the network and makes
+// them available for mining.
+func (pool *TxPool) AddRemotes(txs []*types.Transaction) []error {
+	err := pool.addTxs(txs, false)
+	pool.Update()
+	pool.sendNewTxsEvent(txs, err)
+
+	return err
+}
+
+func (pool *TxPool) Get(hash common.Hash) *types.Transaction {
+	return pool.all.Get(hash)
+}
+
+func (pool *TxPool) Has(hash common.Hash) bool {
+	return pool.all.Get(hash) != nil
+}
+
 func (pool *TxPool) addTxs(txs []*types.Transaction, sync bool) []error {
 	var (
 		errs = make([]error, len(txs))
@@ -0,0 +1,24 @@
+package core
+
+import (
+	"bcsbs/core/state"
+	"bcsbs/core/types"
+)
+
+// Validator checks the contents of a block before it is executed.
+type Validator interface {
+	// ValidateBody checks the transactions of a block against its header.
+	ValidateBody(block *types.Block) error
+
+	// ValidateState checks the state after the block was processed against
+	// the commitments in its header.
+	ValidateState(block *types.Block, statedb *state.StateDB, receipts types.Receipts, usedGas uint64) error
+}
+
+// Processor executes the transactions of a block.
+type Processor interface {
+	// Process applies the transactions of the block and the block reward to
+	// statedb, which must hold the state of the parent block. It returns the
+	// receipts and the gas used by the transactions.
+	Process(block *types.Block, statedb *state.StateDB) (types.Receipts, uint64, error)
+}
@@ -3,10 +3,12 @@ package types
 import (
 	"encoding/binary"
 	"fmt"
+	"io"
 	"math/big"
 	"sync/atomic"
 
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/rlp"
 )
 
 type BlockNonce [8]byte
@@ -28,6 +30,15 @@ type Header struct {
 	Number     *big.Int
 	Time       uint64
 	Nonce      BlockNonce
+
+	// The fields below were added later. They are optional so that headers
+	// written before keep their encoding and hash.
+	Root        common.Hash `rlp:"optional"`
+	ReceiptHash common.Hash `rlp:"optional"`
+	Difficulty  *big.Int    `rlp:"optional"`
+	GasLimit    uint64      `rlp:"optional"`
+	GasUsed     uint64      `rlp:"optional"`
+	Extra       []byte      `rlp:"optional"`
 }
 
 func (h *Header) Hash() common.Hash {
@@ -45,13 +56,37 @@ type Block struct {
 	hash atomic.Value
 }
 
+// extblock is the RLP encoding of a block.
+type extblock struct {
+	Header *Header
+	Txs    []*Transaction
+}
+
+// DecodeRLP decodes a block from RLP.
+func (b *Block) DecodeRLP(s *rlp.Stream) error {
+	var eb extblock
+	if err := s.Decode(&eb); err != nil {
+		return err
+	}
+	b.header, b.transactions = eb.Header, eb.Txs
+	return nil
+}
+
+// EncodeRLP serializes a block into RLP.
+func (b *Block) EncodeRLP(w io.Writer) error {
+	return rlp.Encode(w, &extblock{
+		Header: b.header,
+		Txs:    b.transactions,
+	})
+}
+
 func NewBlock(header *Header, txs []*Transaction) *Block {
 	b := &Block{
 		header: CopyHeader(header),
 	}
 
 	if len(txs) != 0 {
-		b.header.TxHash = rlpHash(txs)
+		b.header.TxHash = CalcTxHash(txs)
 		b.transactions = make(Transactions, len(txs))
 		copy(b.transactions, txs)
 	}
@@ -65,6 +100,15 @@ func NewBlockWithHeader(header *Header) *Block {
 
 func CopyHeader(h *Header) *Header {
 	cpy := *h
+	if h.Number != nil {
+		cpy.Number = new(big.Int).Set(h.Number)
+	}
+	if h.Difficulty != nil {
+		cpy.Difficulty = new(big.Int).Set(h.Difficulty)
+	}
+	if len(h.Extra) > 0 {
+		cpy.Extra = common.CopyBytes(h.Extra)
+	}
 	return &cpy
 }
 
@@ -106,6 +150,9 @@ func (b *Block) String() string {
 		fmt.Sprintf("Time: %d\n", b.Time()) +
 		fmt.Sprintf("Hash: %s\n", b.Hash()) +
 		fmt.Sprintf("TxHash: %s\n", b.TxHash()) +
+		fmt.Sprintf("Root: %s\n", b.Root()) +
+		fmt.Sprintf("Difficulty: %v\n", b.Difficulty()) +
+		fmt.Sprintf("GasUsed: %d/%d\n", b.GasUsed(), b.GasLimit()) +
 		fmt.Sprintf("Nonce: %d\n", b.Nonce()) + tx_info
 
 }
@@ -119,6 +166,18 @@ func (b *Block) Nonce() uint64            { return binary.BigEndian.Uint64(b.hea
 func (b *Block) TxHash() common.Hash      { return b.header.TxHash }
 func (b *Block) Time() uint64             { return b.header.Time }
 func (b *Block) Coinbase() common.Address { return b.header.Coinbase }
+func (b *Block) Root() common.Hash        { return b.header.Root }
+func (b *Block) ReceiptHash() common.Hash { return b.header.ReceiptHash }
+func (b *Block) GasLimit() uint64         { return b.header.GasLimit }
+func (b *Block) GasUsed() uint64          { return b.header.GasUsed }
+func (b *Block) Extra() []byte            { return common.CopyBytes(b.header.Extra) }
+
+func (b *Block) Difficulty() *big.Int {
+	if b.header.Difficulty == nil {
+		return new(big.Int)
+	}
+	return new(big.Int).Set(b.header.Difficulty)
+}
 
 func (b *Block) Transactions() Transactions { return b.transactions }
 
@@ -0,0 +1,50 @@
+package types
+
+import (
+	"crypto/ecdsa"
+	"errors"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// ErrInvalidCheckpointSig is returned if the signature of a checkpoint is
+// malformed.
+var ErrInvalidCheckpointSig = errors.New("invalid checkpoint signature")
+
+// Checkpoint pins the hash of the canonical block with the given number. A
+// checkpoint signed by one of the checkpoint signers of the chain config
+// finalizes the chain up to that block.
+type Checkpoint struct {
+	Number    uint64
+	Hash      common.Hash
+	Signature []byte // [R || S || V] signature of SigHash
+}
+
+// SigHash returns the hash signed by the checkpoint signer. The chain ID is
+// part of it, so a checkpoint cannot be replayed on another network.
+func (c *Checkpoint) SigHash(chainID *big.Int) common.Hash {
+	return rlpHash([]interface{}{chainID, c.Number, c.Hash})
+}
+
+// Signer returns the address of the checkpoint signer.
+func (c *Checkpoint) Signer(chainID *big.Int) (common.Address, error) {
+	if len(c.Signature) != crypto.SignatureLength {
+		return common.Address{}, ErrInvalidCheckpointSig
+	}
+	pub, err := crypto.SigToPub(c.SigHash(chainID).Bytes(), c.Signature)
+	if err != nil {
+		return common.Address{}, ErrInvalidCheckpointSig
+	}
+	return crypto.PubkeyToAddress(*pub), nil
+}
+
+// SignCheckpoint returns a copy of the checkpoint signed with prv.
+func SignCheckpoint(c *Checkpoint, chainID *big.Int, prv *ecdsa.PrivateKey) (*Checkpoint, error) {
+	sig, err := crypto.Sign(c.SigHash(chainID).Bytes(), prv)
+	if err != nil {
+		return nil, err
+	}
+	return &Checkpoint{Number: c.Number, Hash: c.Hash, Signature: sig}, nil
+}
@@ -36,3 +36,12 @@ func prefixedRlpHash(prefix byte, x interface{}) (h common.Hash) {
 	sha.Read(h[:])
 	return h
 }
+
+// CalcTxHash returns the hash committed to in the TxHash field of a header
+// for the given transactions. A block without transactions has a zero hash.
+func CalcTxHash(txs Transactions) common.Hash {
+	if len(txs) == 0 {
+		return common.Hash{}
+	}
+	return rlpHash(txs)
+}
@@ -0,0 +1,92 @@
+package types
+
+import (
+	"errors"
+	"io"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+const (
+	// ReceiptStatusFailed is the status code of a transaction if execution failed.
+	ReceiptStatusFailed = uint64(0)
+
+	// ReceiptStatusSuccessful is the status code of a transaction if execution succeeded.
+	ReceiptStatusSuccessful = uint64(1)
+)
+
+// Receipt represents the result of a transaction.
+type Receipt struct {
+	// Consensus fields: these fields are stored and hashed into the header
+	Status            uint64
+	CumulativeGasUsed uint64
+	ContractAddress   common.Address // address of the created contract, if any
+	Err               string         // reason the EVM stopped with, empty on success
+
+	// Derived fields: these fields are filled in from the block when the
+	// receipt is read
+	TxHash           common.Hash
+	GasUsed          uint64
+	BlockHash        common.Hash
+	BlockNumber      *big.Int
+	TransactionIndex uint
+}
+
+type receiptRLP struct {
+	Status            uint64
+	CumulativeGasUsed uint64
+	ContractAddress   common.Address
+	Err               string
+}
+
+// EncodeRLP implements rlp.Encoder, only the consensus fields are encoded.
+func (r *Receipt) EncodeRLP(w io.Writer) error {
+	return rlp.Encode(w, &receiptRLP{r.Status, r.CumulativeGasUsed, r.ContractAddress, r.Err})
+}
+
+// DecodeRLP implements rlp.Decoder.
+func (r *Receipt) DecodeRLP(s *rlp.Stream) error {
+	var dec receiptRLP
+	if err := s.Decode(&dec); err != nil {
+		return err
+	}
+	r.Status, r.CumulativeGasUsed, r.ContractAddress, r.Err = dec.Status, dec.CumulativeGasUsed, dec.ContractAddress, dec.Err
+	return nil
+}
+
+// Receipts is a list of receipts, in the order of the transactions of a block.
+type Receipts []*Receipt
+
+func (rs Receipts) Len() int { return len(rs) }
+
+// DeriveFields fills the receipts with their computed fields based on the
+// block they belong to.
+func (rs Receipts) DeriveFields(hash common.Hash, number uint64, txs Transactions) error {
+	if len(txs) != len(rs) {
+		return errors.New("transaction and receipt count mismatch")
+	}
+	for i := 0; i < len(rs); i++ {
+		rs[i].TxHash = txs[i].Hash()
+		rs[i].BlockHash = hash
+		rs[i].BlockNumber = new(big.Int).SetUint64(number)
+		rs[i].TransactionIndex = uint(i)
+
+		if i == 0 {
+			rs[i].GasUsed = rs[i].CumulativeGasUsed
+		} else {
+			rs[i].GasUsed = rs[i].CumulativeGasUsed - rs[i-1].CumulativeGasUsed
+		}
+	}
+	return nil
+}
+
+// CalcReceiptHash returns the hash committed to in the ReceiptHash field of a
+// header for the given receipts. A block without receipts has a zero hash.
+func CalcReceiptHash(receipts Receipts) common.Hash {
+	if len(receipts) == 0 {
+		return common.Hash{}
+	}
+	return rlpHash(receipts)
+}
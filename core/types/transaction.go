@@ -37,7 +37,6 @@ type TxData interface {
 	rawSignatureValues() (v, r, s *big.Int)
 	setSignatureValues(chainID, v, r, s *big.Int)
 	setSender(sender *common.Address)
-	setNonce(nonce uint64)
 }
 
 type Transaction struct {
@@ -70,12 +69,35 @@ func (tx *Transaction) Nonce() uint64           { return tx.inner.nonce() }
 func (tx *Transaction) To() *common.Address     { return copyAddressPtr(tx.inner.to()) }
 func (tx *Transaction) Sender() *common.Address { return copyAddressPtr(tx.inner.sender()) }
 
-func (tx *Transaction) SetNonce(nonce uint64) { tx.inner.setNonce(nonce) }
-
 func (tx *Transaction) Cost() *big.Int {
 	return tx.Value()
 }
 
+// Protected says whether the transaction is replay-protected.
+func (tx *Transaction) Protected() bool {
+	v, _, _ := tx.RawSignatureValues()
+	return v != nil && isProtectedV(v)
+}
+
+// ChainId returns the EIP155 chain ID of the transaction. The return value
+// will always be non-nil. For unprotected transactions it is zero.
+func (tx *Transaction) ChainId() *big.Int {
+	v, _, _ := tx.RawSignatureValues()
+	if v == nil {
+		return new(big.Int)
+	}
+	return deriveChainId(v)
+}
+
+func isProtectedV(V *big.Int) bool {
+	if V.BitLen() <= 8 {
+		v := V.Uint64()
+		return v != 27 && v != 28 && v != 1 && v != 0
+	}
+	// anything not 27 or 28 is considered protected
+	return true
+}
+
 func (tx *Transaction) RawSignatureValues() (v, r, s *big.Int) {
 	return tx.inner.rawSignatureValues()
 }
@@ -213,6 +235,24 @@ type Transactions []*Transaction
 
 func (s Transactions) Len() int { return len(s) }
 
+// TxDifference returns a new set which is the difference between a and b.
+func TxDifference(a, b Transactions) Transactions {
+	keep := make(Transactions, 0, len(a))
+
+	remove := make(map[common.Hash]struct{})
+	for _, tx := range b {
+		remove[tx.Hash()] = struct{}{}
+	}
+
+	for _, tx := range a {
+		if _, ok := remove[tx.Hash()]; !ok {
+			keep = append(keep, tx)
+		}
+	}
+
+	return keep
+}
+
 func copyAddressPtr(a *common.Address) *common.Address {
 	if a == nil {
 		return &common.Address{}
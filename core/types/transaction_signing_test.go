@@ -0,0 +1,57 @@
+package types
+
+import (
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+func TestEIP155SigningValue(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	addr := crypto.PubkeyToAddress(key.PublicKey)
+	signer := NewEIP155Signer(big.NewInt(18))
+
+	tx, err := SignTx(NewTransaction(0, common.HexToAddress("0x1000"), big.NewInt(10), nil), signer, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if from, err := Sender(signer, tx); err != nil || from != addr {
+		t.Fatalf("sender %x, %v, want %x", from, err, addr)
+	}
+
+	// A relay raising the value must not keep the signature valid
+	inner := tx.inner.copy().(*LegacyTx)
+	inner.Value = big.NewInt(1000)
+	if from, err := Sender(signer, NewTX(inner)); err == nil && from == addr {
+		t.Fatal("signature still valid after changing the value")
+	}
+
+	// Nor may the transaction be replayed on another chain
+	if _, err := Sender(NewEIP155Signer(big.NewInt(19)), tx); !errors.Is(err, ErrInvalidChainId) {
+		t.Fatalf("error %v, want %v", err, ErrInvalidChainId)
+	}
+}
+
+func TestUnprotectedSigningValue(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	addr := crypto.PubkeyToAddress(key.PublicKey)
+
+	tx, err := SignTx(NewTransaction(0, common.HexToAddress("0x1000"), big.NewInt(10), nil), HomesteadSigner{}, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Unprotected transactions are still accepted after EIP155
+	signer := NewEIP155Signer(big.NewInt(18))
+	if from, err := Sender(signer, tx); err != nil || from != addr {
+		t.Fatalf("sender %x, %v, want %x", from, err, addr)
+	}
+
+	inner := tx.inner.copy().(*LegacyTx)
+	inner.Value = big.NewInt(1000)
+	if from, err := Sender(signer, NewTX(inner)); err == nil && from == addr {
+		t.Fatal("signature still valid after changing the value")
+	}
+}
@@ -1,7 +1,7 @@
 package vm
 
 import (
-	"fmt"
+	"bcsbs/params"
 	"math/big"
 
 	"github.com/ethereum/go-ethereum/common"
@@ -18,6 +18,8 @@ type (
 type BlockContext struct {
 	Transfer    TransferFunc
 	CanTransfer CanTransferFunc
+
+	BlockNumber *big.Int
 }
 
 type EVM struct {
@@ -25,13 +27,20 @@ type EVM struct {
 
 	StateDB StateDB
 
+	chainConfig *params.ChainConfig
+	chainRules  params.Rules
+
 	interpreter *EVMInterpreter
 }
 
-func NewEVM(statedb StateDB, blockCtx *BlockContext) *EVM {
+// NewEVM returns a new EVM running the instructions of the forks active at
+// the block of blockCtx.
+func NewEVM(statedb StateDB, blockCtx *BlockContext, chainConfig *params.ChainConfig) *EVM {
 	evm := &EVM{
-		StateDB: statedb,
-		Context: *blockCtx,
+		StateDB:     statedb,
+		Context:     *blockCtx,
+		chainConfig: chainConfig,
+		chainRules:  chainConfig.Rules(blockCtx.BlockNumber),
 	}
 	evm.interpreter = NewEVMInterpreter(evm)
 	return evm
@@ -60,10 +69,6 @@ func (evm *EVM) Call(caller ContractRef, addr common.Address, input []byte, valu
 		ret, err = evm.interpreter.Run(contract, nil, false)
 	}
 
-	if err != nil {
-		fmt.Println(err)
-	}
-
 	return ret, err
 }
 
@@ -98,9 +103,7 @@ func (evm *EVM) create(caller ContractRef, codeAndHash *codeAndHash, value *big.
 	contract.SetCodeOptionalHash(&address, codeAndHash)
 
 	ret, err := evm.interpreter.Run(contract, nil, false)
-	if err != nil {
-		fmt.Printf("res: %x err: %v addr: %s\n", ret, err, address)
-	} else {
+	if err == nil {
 		evm.StateDB.SetCode(address, contract.Code)
 	}
 
@@ -111,3 +114,6 @@ func (evm *EVM) Create(caller ContractRef, code []byte, value *big.Int) (ret []b
 	contractAddr = crypto.CreateAddress(caller.Address(), evm.StateDB.GetNonce(caller.Address()))
 	return evm.create(caller, &codeAndHash{code: code}, value, contractAddr, CREATE)
 }
+
+// ChainConfig returns the environment's chain configuration
+func (evm *EVM) ChainConfig() *params.ChainConfig { return evm.chainConfig }
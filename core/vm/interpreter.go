@@ -14,9 +14,16 @@ type EVMInterpreter struct {
 }
 
 func NewEVMInterpreter(evm *EVM) *EVMInterpreter {
+	var table *JumpTable
+	switch {
+	case evm.chainRules.IsHomestead:
+		table = &homesteadInstructionSet
+	default:
+		table = &frontierInstructionSet
+	}
 	in := &EVMInterpreter{
 		evm:       evm,
-		JumpTable: &frontierInstructionSet,
+		JumpTable: table,
 	}
 
 	return in
@@ -53,6 +60,9 @@ func (in *EVMInterpreter) Run(contract *Contract, input []byte, readOnly bool) (
 	for {
 		op = contract.GetOp(pc)
 		operation := in.JumpTable[op]
+		if operation == nil {
+			return nil, &ErrInvalidOpCode{opcode: op}
+		}
 
 		if sLen := stack.len(); sLen < operation.minStack {
 			return nil, &ErrStackUnderflow{stackLen: sLen, required: operation.minStack}
@@ -12,11 +12,31 @@ type operation struct {
 }
 
 var (
-	frontierInstructionSet = newFrontierInstructionSet()
+	frontierInstructionSet  = newFrontierInstructionSet()
+	homesteadInstructionSet = newHomesteadInstructionSet()
 )
 
+// JumpTable contains the EVM opcodes supported at a given fork.
 type JumpTable [256]*operation
 
+// newHomesteadInstructionSet returns the frontier instructions and the
+// arithmetic added in Homestead.
+func newHomesteadInstructionSet() JumpTable {
+	instructionSet := newFrontierInstructionSet()
+	instructionSet[ADD] = &operation{
+		execute:  opAdd,
+		minStack: minStack(2, 1),
+		maxStack: maxStack(2, 1),
+	}
+	instructionSet[EQ] = &operation{
+		execute:  opEq,
+		minStack: minStack(2, 1),
+		maxStack: maxStack(2, 1),
+	}
+	return instructionSet
+}
+
+// newFrontierInstructionSet returns the frontier instructions.
 func newFrontierInstructionSet() JumpTable {
 	tbl := JumpTable{
 		// 0x0
@@ -15,11 +15,13 @@ func (op OpCode) IsPush() bool {
 // 0x0 range - arithmetic ops.
 const (
 	STOP OpCode = 0x0
+	ADD  OpCode = 0x1
 	SUB  OpCode = 0x3
 )
 
 // 0x10
 const (
+	EQ     OpCode = 0x14
 	ISZERO OpCode = 0x15
 	AND    OpCode = 0x16
 	NOT    OpCode = 0x19
@@ -93,9 +95,11 @@ const (
 var opCodeToString = map[OpCode]string{
 	// 0x0
 	STOP: "STOP",
+	ADD:  "ADD",
 	SUB:  "SUB",
 
 	// 0x10
+	EQ:     "EQ",
 	ISZERO: "ISZERO",
 	AND:    "AND",
 	NOT:    "NOT",
@@ -166,9 +170,11 @@ func (op OpCode) String() string {
 var stringToOp = map[string]OpCode{
 	// 0x0
 	"STOP": STOP,
+	"ADD":  ADD,
 	"SUB":  SUB,
 
 	// 0x10
+	"EQ":     EQ,
 	"ISZERO": ISZERO,
 	"AND":    AND,
 	"NOT":    NOT,
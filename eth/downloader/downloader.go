@@ -0,0 +1,365 @@
+package downloader
+
+import (
+	"bcsbs/consensus"
+	"bcsbs/core/types"
+	"bcsbs/params"
+	"errors"
+	"fmt"
+	"math/big"
+	"sync"
+	"sync/atomic"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+const (
+	maxHeaderFetch = 192
+	maxBlockFetch  = 128
+	maxRetries     = 3
+
+	// maxForkAncestry is the number of blocks below the local head a common
+	// ancestor with a peer may be found at
+	maxForkAncestry = params.FullImmutabilityThreshold
+)
+
+var (
+	errBusy             = errors.New("busy")
+	errCanceled         = errors.New("syncing canceled (requested)")
+	errInvalidChain     = errors.New("retrieved hash chain is invalid")
+	errInvalidAncestor  = errors.New("retrieved ancestor is invalid")
+	errEmptyHeaderSet   = errors.New("empty header set by peer")
+	errStallingPeer     = errors.New("peer is stalling")
+	errInvalidBody      = errors.New("retrieved block body is invalid")
+	errTooManyRetries   = errors.New("too many retries")
+	errNothingToImport  = errors.New("no blocks to import")
+	errGenesisNotShared = errors.New("peer does not share our genesis")
+	errReorgRejected    = errors.New("peer chain requires a rejected reorg")
+)
+
+type Peer interface {
+	ID() string
+	Head() (common.Hash, uint64)
+	TD() *big.Int
+
+	RequestHeaders(origin common.Hash, amount uint64) ([]*types.Header, error)
+	RequestBodies(hashes []common.Hash) ([]*types.Body, error)
+}
+
+type BlockChain interface {
+	Genesis() *types.Block
+	CurrentBlock() *types.Block
+	GetTd(hash common.Hash, number uint64) *big.Int
+	GetHeaderByHash(hash common.Hash) *types.Header
+	VerifyReorg(fork *types.Header) error
+	InsertChainFromPeer(peer string, chain types.Blocks) (int, error)
+}
+
+type headerVerifierFn func(parent, header *types.Header) error
+
+type peerDropFn func(id string)
+
+type SyncProgress struct {
+	StartingBlock uint64
+	CurrentBlock  uint64
+	HighestBlock  uint64
+}
+
+// Downloader brings the local chain up to the head of a remote peer. It
+// always starts from the persisted local head, so an interrupted sync is
+// resumed by simply running it again after a restart.
+type Downloader struct {
+	chain        BlockChain
+	verifyHeader headerVerifierFn
+	dropPeer     peerDropFn
+	badPeer      peerDropFn // called for peers delivering an invalid chain
+
+	synchronising int32
+
+	progressLock sync.RWMutex
+	progress     SyncProgress
+
+	cancelLock sync.Mutex
+	cancelCh   chan struct{}
+}
+
+func New(chain BlockChain, verifyHeader headerVerifierFn, dropPeer, badPeer peerDropFn) *Downloader {
+	return &Downloader{
+		chain:        chain,
+		verifyHeader: verifyHeader,
+		dropPeer:     dropPeer,
+		badPeer:      badPeer,
+	}
+}
+
+func (d *Downloader) Progress() SyncProgress {
+	d.progressLock.RLock()
+	defer d.progressLock.RUnlock()
+
+	progress := d.progress
+	if current := d.chain.CurrentBlock().NumberU64(); current > progress.CurrentBlock {
+		progress.CurrentBlock = current
+	}
+	if progress.CurrentBlock > progress.HighestBlock {
+		progress.HighestBlock = progress.CurrentBlock
+	}
+	return progress
+}
+
+func (d *Downloader) Synchronising() bool {
+	return atomic.LoadInt32(&d.synchronising) > 0
+}
+
+func (d *Downloader) Cancel() {
+	d.cancelLock.Lock()
+	defer d.cancelLock.Unlock()
+
+	if d.cancelCh != nil {
+		select {
+		case <-d.cancelCh:
+		default:
+			close(d.cancelCh)
+		}
+	}
+}
+
+// Synchronise downloads and imports the chain of the given peer. Peers that
+// deliver invalid data or keep timing out are dropped.
+func (d *Downloader) Synchronise(p Peer) error {
+	err := d.synchronise(p)
+	switch {
+	case err == nil, errors.Is(err, errBusy), errors.Is(err, errCanceled), errors.Is(err, errNothingToImport):
+	case errors.Is(err, errInvalidChain), errors.Is(err, errInvalidBody):
+		fmt.Println("Synchronisation failed, peer delivered an invalid chain", "peer", p.ID(), "err", err)
+		if d.badPeer != nil {
+			d.badPeer(p.ID())
+		}
+	case errors.Is(err, errEmptyHeaderSet), errors.Is(err, errStallingPeer), errors.Is(err, errTooManyRetries),
+		errors.Is(err, errGenesisNotShared), errors.Is(err, errInvalidAncestor):
+		fmt.Println("Synchronisation failed, dropping peer", "peer", p.ID(), "err", err)
+		if d.dropPeer != nil {
+			d.dropPeer(p.ID())
+		}
+	case errors.Is(err, errReorgRejected):
+		// The peer is kept, a signed checkpoint it relays may still move us
+		// onto its chain.
+		fmt.Println("Synchronisation failed, reorg rejected", "peer", p.ID(), "err", err)
+	default:
+		fmt.Println("Synchronisation failed", "peer", p.ID(), "err", err)
+	}
+	return err
+}
+
+func (d *Downloader) synchronise(p Peer) error {
+	if !atomic.CompareAndSwapInt32(&d.synchronising, 0, 1) {
+		return errBusy
+	}
+	defer atomic.StoreInt32(&d.synchronising, 0)
+
+	d.cancelLock.Lock()
+	d.cancelCh = make(chan struct{})
+	d.cancelLock.Unlock()
+
+	defer d.Cancel()
+
+	head, number := p.Head()
+	local := d.chain.CurrentBlock()
+	if p.TD().Cmp(d.chain.GetTd(local.Hash(), local.NumberU64())) <= 0 {
+		return errNothingToImport
+	}
+
+	d.progressLock.Lock()
+	d.progress = SyncProgress{
+		StartingBlock: local.NumberU64(),
+		CurrentBlock:  local.NumberU64(),
+		HighestBlock:  number,
+	}
+	d.progressLock.Unlock()
+
+	fmt.Println("Synchronising with the network", "peer", p.ID(), "head", head, "number", number, "local", local.NumberU64())
+
+	headers, err := d.fetchHeaders(p, head, number)
+	if err != nil {
+		return err
+	}
+	if err := d.fetchBlocks(p, headers); err != nil {
+		return err
+	}
+
+	fmt.Println("Synchronisation completed", "peer", p.ID(), "number", d.chain.CurrentBlock().NumberU64())
+	return nil
+}
+
+// fetchHeaders walks back from the remote head in batches until it reaches
+// a header that is already known locally, verifying every link on the way.
+// The returned headers are ordered oldest first.
+func (d *Downloader) fetchHeaders(p Peer, head common.Hash, number uint64) ([]*types.Header, error) {
+	// The common ancestor may not lie deeper than maxForkAncestry below the
+	// local head, which bounds the number of headers to walk
+	var floor uint64
+	if local := d.chain.CurrentBlock().NumberU64(); local > maxForkAncestry {
+		floor = local - maxForkAncestry
+	}
+	var (
+		headers []*types.Header
+		origin  = head
+		parent  *types.Header
+	)
+	for parent == nil {
+		if err := d.checkCancel(); err != nil {
+			return nil, err
+		}
+
+		batch, err := d.requestHeaders(p, origin, maxHeaderFetch)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, header := range batch {
+			if len(headers) == 0 {
+				if header.Hash() != head || header.Number.Uint64() != number {
+					return nil, fmt.Errorf("%w: head %x #%d, want %x #%d", errInvalidChain, header.Hash(), header.Number, head, number)
+				}
+			} else {
+				prev := headers[len(headers)-1]
+				if prev.ParentHash != header.Hash() || header.Number.Uint64()+1 != prev.Number.Uint64() {
+					return nil, fmt.Errorf("%w: header %x #%d is not the parent of #%d", errInvalidChain, header.Hash(), header.Number, prev.Number)
+				}
+				if err := d.verify(header, prev); err != nil {
+					return nil, err
+				}
+			}
+			if header.Number.Sign() == 0 {
+				return nil, fmt.Errorf("%w: %x", errGenesisNotShared, header.Hash())
+			}
+			if header.Number.Uint64() <= floor {
+				return nil, fmt.Errorf("%w: no common ancestor above #%d", errInvalidAncestor, floor)
+			}
+			headers = append(headers, header)
+
+			if parent = d.chain.GetHeaderByHash(header.ParentHash); parent != nil {
+				if err := d.verify(parent, header); err != nil {
+					return nil, err
+				}
+				break
+			}
+		}
+		origin = headers[len(headers)-1].ParentHash
+	}
+
+	// A chain that can never become canonical is not worth downloading
+	if err := d.chain.VerifyReorg(parent); err != nil {
+		return nil, fmt.Errorf("%w: %v", errReorgRejected, err)
+	}
+
+	for i, j := 0, len(headers)-1; i < j; i, j = i+1, j-1 {
+		headers[i], headers[j] = headers[j], headers[i]
+	}
+	return headers, nil
+}
+
+// verify checks header against its parent as soon as both are known, so a
+// peer cannot make us collect unverified headers.
+func (d *Downloader) verify(parent, header *types.Header) error {
+	// Headers slightly ahead of our clock are not the fault of the peer, the
+	// import stops at their blocks and is retried on the next sync
+	if err := d.verifyHeader(parent, header); err != nil && !errors.Is(err, consensus.ErrFutureBlock) {
+		return fmt.Errorf("%w: header #%d [%x..]: %v", errInvalidChain, header.Number, header.Hash().Bytes()[:4], err)
+	}
+	return nil
+}
+
+func (d *Downloader) requestHeaders(p Peer, origin common.Hash, amount uint64) ([]*types.Header, error) {
+	var err error
+	for i := 0; i < maxRetries; i++ {
+		var headers []*types.Header
+		if headers, err = p.RequestHeaders(origin, amount); err != nil {
+			fmt.Println("Header request failed", "peer", p.ID(), "attempt", i+1, "err", err)
+			continue
+		}
+		if len(headers) == 0 {
+			return nil, errEmptyHeaderSet
+		}
+		if headers[0].Hash() != origin {
+			return nil, fmt.Errorf("%w: first header %x, want %x", errInvalidChain, headers[0].Hash(), origin)
+		}
+		return headers, nil
+	}
+	return nil, fmt.Errorf("%w: %v", errTooManyRetries, err)
+}
+
+// fetchBlocks retrieves the bodies for the verified headers and imports the
+// assembled blocks batch by batch.
+func (d *Downloader) fetchBlocks(p Peer, headers []*types.Header) error {
+	for len(headers) > 0 {
+		if err := d.checkCancel(); err != nil {
+			return err
+		}
+
+		batch := headers
+		if len(batch) > maxBlockFetch {
+			batch = batch[:maxBlockFetch]
+		}
+		blocks, err := d.requestBlocks(p, batch)
+		if err != nil {
+			return err
+		}
+		if n, err := d.chain.InsertChainFromPeer(p.ID(), blocks); errors.Is(err, consensus.ErrFutureBlock) {
+			return fmt.Errorf("import stopped at block #%d: %w", blocks[n].NumberU64(), err)
+		} else if err != nil {
+			return fmt.Errorf("%w: import failed at block #%d: %v", errInvalidChain, blocks[n].NumberU64(), err)
+		}
+		headers = headers[len(batch):]
+
+		d.progressLock.Lock()
+		d.progress.CurrentBlock = blocks[len(blocks)-1].NumberU64()
+		progress := d.progress
+		d.progressLock.Unlock()
+
+		fmt.Println("Imported new chain segment", "blocks", len(blocks), "current", progress.CurrentBlock, "highest", progress.HighestBlock)
+	}
+	return nil
+}
+
+func (d *Downloader) requestBlocks(p Peer, headers []*types.Header) (types.Blocks, error) {
+	blocks := make(types.Blocks, 0, len(headers))
+
+	for retries := 0; len(blocks) < len(headers); {
+		pending := headers[len(blocks):]
+		hashes := make([]common.Hash, len(pending))
+		for i, header := range pending {
+			hashes[i] = header.Hash()
+		}
+
+		bodies, err := p.RequestBodies(hashes)
+		if err == nil && len(bodies) == 0 {
+			err = errStallingPeer
+		}
+		if err != nil {
+			if retries++; retries >= maxRetries {
+				return nil, fmt.Errorf("%w: %v", errTooManyRetries, err)
+			}
+			fmt.Println("Body request failed", "peer", p.ID(), "attempt", retries, "err", err)
+			continue
+		}
+		if len(bodies) > len(pending) {
+			return nil, fmt.Errorf("%w: %d bodies for %d headers", errInvalidBody, len(bodies), len(pending))
+		}
+		for i, body := range bodies {
+			blocks = append(blocks, types.NewBlockWithHeader(pending[i]).WithBody(body.Transactions))
+		}
+	}
+	return blocks, nil
+}
+
+func (d *Downloader) checkCancel() error {
+	d.cancelLock.Lock()
+	defer d.cancelLock.Unlock()
+
+	select {
+	case <-d.cancelCh:
+		return errCanceled
+	default:
+		return nil
+	}
+}
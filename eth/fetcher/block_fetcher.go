@@ -0,0 +1,254 @@
+package fetcher
+
+import (
+	"bcsbs/consensus"
+	"bcsbs/core/types"
+	"errors"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+const (
+	maxUncleDist = 7
+	maxQueueDist = 32
+	blockLimit   = 64
+)
+
+var (
+	errTerminated = errors.New("terminated")
+)
+
+type blockRetrievalFn func(common.Hash) *types.Block
+
+type headerRequesterFn func(common.Hash) (*types.Header, error)
+
+type bodyRequesterFn func(common.Hash) (types.Transactions, error)
+
+type headerVerifierFn func(parent, header *types.Header) error
+
+type blockBroadcasterFn func(block *types.Block, propagate bool)
+
+type chainHeightFn func() uint64
+
+type chainInsertFn func(peer string, blocks types.Blocks) (int, error)
+
+type peerDropFn func(id string)
+
+type blockAnnounce struct {
+	hash   common.Hash
+	number uint64
+	origin string
+
+	fetchHeader headerRequesterFn
+	fetchBody   bodyRequesterFn
+}
+
+type blockInject struct {
+	origin string
+	block  *types.Block
+}
+
+// BlockFetcher retrieves announced blocks from the announcing peers and
+// imports them once their parent is known locally.
+type BlockFetcher struct {
+	notify chan *blockAnnounce
+	inject chan *blockInject
+	failed chan common.Hash
+	quit   chan struct{}
+
+	fetching map[common.Hash]*blockAnnounce
+	queued   map[common.Hash]*blockInject
+
+	getBlock       blockRetrievalFn
+	verifyHeader   headerVerifierFn
+	broadcastBlock blockBroadcasterFn
+	chainHeight    chainHeightFn
+	insertChain    chainInsertFn
+	dropPeer       peerDropFn
+}
+
+func NewBlockFetcher(getBlock blockRetrievalFn, verifyHeader headerVerifierFn, broadcastBlock blockBroadcasterFn, chainHeight chainHeightFn, insertChain chainInsertFn, dropPeer peerDropFn) *BlockFetcher {
+	return &BlockFetcher{
+		notify:         make(chan *blockAnnounce),
+		inject:         make(chan *blockInject),
+		failed:         make(chan common.Hash),
+		quit:           make(chan struct{}),
+		fetching:       make(map[common.Hash]*blockAnnounce),
+		queued:         make(map[common.Hash]*blockInject),
+		getBlock:       getBlock,
+		verifyHeader:   verifyHeader,
+		broadcastBlock: broadcastBlock,
+		chainHeight:    chainHeight,
+		insertChain:    insertChain,
+		dropPeer:       dropPeer,
+	}
+}
+
+func (f *BlockFetcher) Start() {
+	go f.loop()
+}
+
+func (f *BlockFetcher) Stop() {
+	close(f.quit)
+}
+
+func (f *BlockFetcher) Notify(peer string, hash common.Hash, number uint64, headerFetcher headerRequesterFn, bodyFetcher bodyRequesterFn) error {
+	block := &blockAnnounce{
+		hash:        hash,
+		number:      number,
+		origin:      peer,
+		fetchHeader: headerFetcher,
+		fetchBody:   bodyFetcher,
+	}
+	select {
+	case f.notify <- block:
+		return nil
+	case <-f.quit:
+		return errTerminated
+	}
+}
+
+func (f *BlockFetcher) Enqueue(peer string, block *types.Block) error {
+	op := &blockInject{
+		origin: peer,
+		block:  block,
+	}
+	select {
+	case f.inject <- op:
+		return nil
+	case <-f.quit:
+		return errTerminated
+	}
+}
+
+func (f *BlockFetcher) loop() {
+	for {
+		select {
+		case <-f.quit:
+			return
+
+		case notification := <-f.notify:
+			if f.known(notification.hash) {
+				continue
+			}
+			if _, ok := f.fetching[notification.hash]; ok {
+				continue
+			}
+			if dist := int64(notification.number) - int64(f.chainHeight()); dist < -maxUncleDist || dist > maxQueueDist {
+				continue
+			}
+			f.fetching[notification.hash] = notification
+			go f.fetch(notification)
+
+		case hash := <-f.failed:
+			delete(f.fetching, hash)
+
+		case op := <-f.inject:
+			hash := op.block.Hash()
+			delete(f.fetching, hash)
+			f.enqueue(op)
+			f.process()
+		}
+	}
+}
+
+func (f *BlockFetcher) fetch(announce *blockAnnounce) {
+	block, err := f.retrieve(announce)
+	if err != nil {
+		fmt.Println("Block fetch failed", "peer", announce.origin, "hash", announce.hash, "err", err)
+		select {
+		case f.failed <- announce.hash:
+		case <-f.quit:
+		}
+		return
+	}
+	f.Enqueue(announce.origin, block)
+}
+
+func (f *BlockFetcher) retrieve(announce *blockAnnounce) (*types.Block, error) {
+	header, err := announce.fetchHeader(announce.hash)
+	if err != nil {
+		return nil, err
+	}
+	if header.Hash() != announce.hash {
+		return nil, fmt.Errorf("header hash mismatch: have %x, want %x", header.Hash(), announce.hash)
+	}
+	txs, err := announce.fetchBody(announce.hash)
+	if err != nil {
+		return nil, err
+	}
+	return types.NewBlockWithHeader(header).WithBody(txs), nil
+}
+
+func (f *BlockFetcher) known(hash common.Hash) bool {
+	if _, ok := f.queued[hash]; ok {
+		return true
+	}
+	return f.getBlock(hash) != nil
+}
+
+func (f *BlockFetcher) enqueue(op *blockInject) {
+	hash := op.block.Hash()
+	if f.known(hash) {
+		return
+	}
+	if dist := int64(op.block.NumberU64()) - int64(f.chainHeight()); dist < -maxUncleDist || dist > maxQueueDist {
+		return
+	}
+	if len(f.queued) >= blockLimit {
+		return
+	}
+	f.queued[hash] = op
+}
+
+// process imports every queued block whose parent is already in the chain,
+// repeating until no more progress can be made.
+func (f *BlockFetcher) process() {
+	for imported := true; imported; {
+		imported = false
+
+		height := f.chainHeight()
+		for hash, op := range f.queued {
+			number := op.block.NumberU64()
+			if number+maxUncleDist < height || f.getBlock(hash) != nil {
+				delete(f.queued, hash)
+				continue
+			}
+			parent := f.getBlock(op.block.ParentHash())
+			if parent == nil {
+				continue
+			}
+			delete(f.queued, hash)
+			f.importBlock(op.origin, parent, op.block)
+			imported = true
+		}
+	}
+}
+
+func (f *BlockFetcher) importBlock(peer string, parent, block *types.Block) {
+	hash := block.Hash()
+
+	// Blocks ahead of our clock are held back by the chain, they are not
+	// propagated until imported
+	err := f.verifyHeader(parent.Header(), block.Header())
+	future := errors.Is(err, consensus.ErrFutureBlock)
+	if err != nil && !future {
+		fmt.Println("Propagated block verification failed", "peer", peer, "number", block.Number(), "hash", hash, "err", err)
+		// Hand the block to the chain anyway, which records it as bad
+		f.insertChain(peer, types.Blocks{block})
+		f.dropPeer(peer)
+		return
+	}
+	if !future {
+		go f.broadcastBlock(block, true)
+	}
+
+	if _, err := f.insertChain(peer, types.Blocks{block}); err != nil {
+		fmt.Println("Propagated block import failed", "peer", peer, "number", block.Number(), "hash", hash, "err", err)
+		return
+	}
+	if !future {
+		go f.broadcastBlock(block, false)
+	}
+}
@@ -0,0 +1,91 @@
+package fetcher
+
+import (
+	"bcsbs/core/types"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+const (
+	maxTxRetrievals  = 256
+	txFetchTimeout   = 30 * time.Second
+	txRequestedLimit = 4096
+)
+
+type txRequesterFn func([]common.Hash) ([]*types.Transaction, error)
+
+// TxFetcher retrieves announced transactions that are not in the local
+// pool yet, asking for each hash only once while a request is in flight.
+type TxFetcher struct {
+	hasTx  func(common.Hash) bool
+	addTxs func(string, []*types.Transaction) []error
+
+	requested map[common.Hash]time.Time
+	lock      sync.Mutex
+}
+
+func NewTxFetcher(hasTx func(common.Hash) bool, addTxs func(string, []*types.Transaction) []error) *TxFetcher {
+	return &TxFetcher{
+		hasTx:     hasTx,
+		addTxs:    addTxs,
+		requested: make(map[common.Hash]time.Time),
+	}
+}
+
+func (f *TxFetcher) Notify(peer string, hashes []common.Hash, fetchTxs txRequesterFn) {
+	var unknown []common.Hash
+
+	f.lock.Lock()
+	now := time.Now()
+	for hash, at := range f.requested {
+		if now.Sub(at) > txFetchTimeout {
+			delete(f.requested, hash)
+		}
+	}
+	for _, hash := range hashes {
+		if len(unknown) >= maxTxRetrievals || len(f.requested) >= txRequestedLimit {
+			break
+		}
+		if _, ok := f.requested[hash]; ok || f.hasTx(hash) {
+			continue
+		}
+		f.requested[hash] = now
+		unknown = append(unknown, hash)
+	}
+	f.lock.Unlock()
+
+	if len(unknown) == 0 {
+		return
+	}
+
+	go func() {
+		txs, err := fetchTxs(unknown)
+		if err != nil {
+			fmt.Println("Transaction fetch failed", "peer", peer, "err", err)
+		} else {
+			f.Enqueue(peer, txs)
+		}
+
+		f.lock.Lock()
+		for _, hash := range unknown {
+			delete(f.requested, hash)
+		}
+		f.lock.Unlock()
+	}()
+}
+
+func (f *TxFetcher) Enqueue(peer string, txs []*types.Transaction) []error {
+	if len(txs) == 0 {
+		return nil
+	}
+	f.lock.Lock()
+	for _, tx := range txs {
+		delete(f.requested, tx.Hash())
+	}
+	f.lock.Unlock()
+
+	return f.addTxs(peer, txs)
+}
@@ -0,0 +1,584 @@
+package eth
+
+import (
+	"bcsbs/consensus"
+	"bcsbs/core"
+	"bcsbs/core/types"
+	"bcsbs/eth/downloader"
+	"bcsbs/eth/fetcher"
+	"bcsbs/event"
+	"bcsbs/miner"
+	"bcsbs/p2p"
+	"bcsbs/p2p/enode"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+const (
+	txChanSize = 4096
+)
+
+type Config struct {
+	NetworkID uint64
+	Chain     *core.BlockChain
+	TxPool    *core.TxPool
+	Engine    consensus.Engine
+	Miner     *miner.Miner
+}
+
+type Handler struct {
+	networkID uint64
+
+	chain  *core.BlockChain
+	txpool *core.TxPool
+	engine consensus.Engine
+	miner  *miner.Miner
+
+	downloader   *downloader.Downloader
+	blockFetcher *fetcher.BlockFetcher
+	txFetcher    *fetcher.TxFetcher
+	peers        *peerSet
+
+	newPeerCh     chan *Peer
+	txsCh         chan core.NewTxsEvent
+	txsSub        event.Subscription
+	minedBlockCh  chan core.NewMinedBlockEvent
+	minedBlockSub event.Subscription
+	checkpointCh  chan core.CheckpointEvent
+	checkpointSub event.Subscription
+
+	quitSync chan struct{}
+	wg       sync.WaitGroup
+}
+
+func NewHandler(config *Config) *Handler {
+	h := &Handler{
+		networkID: config.NetworkID,
+		chain:     config.Chain,
+		txpool:    config.TxPool,
+		engine:    config.Engine,
+		miner:     config.Miner,
+		peers:     newPeerSet(),
+		newPeerCh: make(chan *Peer),
+		quitSync:  make(chan struct{}),
+	}
+
+	verifyHeader := func(parent, header *types.Header) error {
+		if err := h.chain.VerifyCheckpoint(header); err != nil {
+			return err
+		}
+		return h.engine.VerifyHeader(parent, header, true)
+	}
+	chainHeight := func() uint64 {
+		return h.chain.CurrentBlock().NumberU64()
+	}
+	h.downloader = downloader.New(h.chain, verifyHeader, h.removePeer, h.badBlockPeer)
+	h.blockFetcher = fetcher.NewBlockFetcher(h.chain.GetBlockByHash, verifyHeader, h.BroadcastBlock, chainHeight, h.chain.InsertPropagatedChain, h.badBlockPeer)
+	h.txFetcher = fetcher.NewTxFetcher(h.txpool.Has, h.addTxs)
+
+	return h
+}
+
+func (h *Handler) Protocols() []p2p.Protocol {
+	return []p2p.Protocol{{
+		Name:    ProtocolName,
+		Version: ProtocolVersion,
+		Length:  protocolLength,
+		Run: func(p *p2p.Peer, rw p2p.MsgReadWriter) error {
+			peer := NewPeer(ProtocolVersion, p, rw)
+			defer peer.Close()
+
+			return h.runEthPeer(peer)
+		},
+		NodeInfo: func() interface{} {
+			return h.NodeInfo()
+		},
+		PeerInfo: func(id enode.ID) interface{} {
+			if p := h.peers.peer(id.String()); p != nil {
+				return p.Info()
+			}
+			return nil
+		},
+	}}
+}
+
+func (h *Handler) Start() {
+	h.blockFetcher.Start()
+
+	h.txsCh = make(chan core.NewTxsEvent, txChanSize)
+	h.txsSub = h.txpool.SubscribeNewTxsEvent(h.txsCh)
+
+	h.checkpointCh = make(chan core.CheckpointEvent, 10)
+	h.checkpointSub = h.chain.SubscribeCheckpointEvent(h.checkpointCh)
+
+	h.wg.Add(3)
+	go h.txBroadcastLoop()
+	go h.checkpointBroadcastLoop()
+	go h.syncLoop()
+
+	if h.miner != nil {
+		h.minedBlockCh = make(chan core.NewMinedBlockEvent, 10)
+		h.minedBlockSub = h.miner.SubscribeNewMinedBlockEvent(h.minedBlockCh)
+
+		h.wg.Add(1)
+		go h.minedBroadcastLoop()
+	}
+}
+
+func (h *Handler) Stop() {
+	h.txsSub.Unsubscribe()
+	h.checkpointSub.Unsubscribe()
+	if h.minedBlockSub != nil {
+		h.minedBlockSub.Unsubscribe()
+	}
+	close(h.quitSync)
+	h.blockFetcher.Stop()
+	h.peers.close()
+	h.wg.Wait()
+}
+
+func (h *Handler) runEthPeer(peer *Peer) error {
+	var (
+		genesis = h.chain.Genesis()
+		head    = h.chain.CurrentBlock()
+		td      = h.chain.GetTd(head.Hash(), head.NumberU64())
+	)
+	if err := peer.Handshake(h.networkID, td, head.Hash(), head.NumberU64(), genesis.Hash()); err != nil {
+		fmt.Println("Ethereum handshake failed", "peer", peer.ID(), "err", err)
+		return err
+	}
+
+	if err := h.peers.register(peer); err != nil {
+		fmt.Println("Ethereum peer registration failed", "peer", peer.ID(), "err", err)
+		return err
+	}
+	defer h.peers.unregister(peer.ID())
+
+	_, number := peer.Head()
+	fmt.Println("Ethereum peer connected", "peer", peer.ID(), "number", number)
+
+	if checkpoint := h.chain.SignedCheckpoint(); checkpoint != nil {
+		peer.AsyncSendCheckpoint(checkpoint)
+	}
+
+	select {
+	case h.newPeerCh <- peer:
+	case <-h.quitSync:
+	}
+
+	for {
+		if err := h.handleMsg(peer); err != nil {
+			fmt.Println("Ethereum message handling failed", "peer", peer.ID(), "err", err)
+			return err
+		}
+	}
+}
+
+func (h *Handler) Downloader() *downloader.Downloader {
+	return h.downloader
+}
+
+func (h *Handler) removePeer(id string) {
+	if peer := h.peers.peer(id); peer != nil {
+		peer.Disconnect(p2p.DiscUselessPeer)
+	}
+}
+
+// penalizePeer lowers the score of a peer, disconnecting and banning it
+// once the score runs out.
+func (h *Handler) penalizePeer(id string, penalty int, reason string) {
+	peer := h.peers.peer(id)
+	if peer == nil {
+		return
+	}
+	if score := peer.penalize(penalty); score <= 0 {
+		fmt.Println("Banning misbehaving peer", "peer", id, "reason", reason)
+		peer.Disconnect(p2p.DiscBadPeer)
+	}
+}
+
+// badBlockPeer penalizes a peer that delivered a block failing header verification.
+func (h *Handler) badBlockPeer(id string) {
+	h.penalizePeer(id, badBlockPenalty, "bad block")
+}
+
+// addCheckpoint accepts a signed checkpoint received from a peer. Peers
+// relaying checkpoints that are not properly signed or that conflict with
+// the configured ones are penalized.
+func (h *Handler) addCheckpoint(peer *Peer, checkpoint *types.Checkpoint) {
+	peer.markCheckpoint(checkpoint.Number)
+
+	err := h.chain.AddSignedCheckpoint(checkpoint)
+	switch {
+	case err == nil, errors.Is(err, core.ErrStaleCheckpoint):
+	case errors.Is(err, types.ErrInvalidCheckpointSig), errors.Is(err, core.ErrUnauthorizedCheckpoint), errors.Is(err, core.ErrCheckpointMismatch):
+		fmt.Println("Invalid signed checkpoint", "peer", peer.ID(), "number", checkpoint.Number, "hash", checkpoint.Hash, "err", err)
+		h.penalizePeer(peer.ID(), badCheckpointPenalty, "bad checkpoint")
+	default:
+		fmt.Println("Signed checkpoint import failed", "peer", peer.ID(), "number", checkpoint.Number, "hash", checkpoint.Hash, "err", err)
+	}
+}
+
+// addTxs imports transactions received from a peer into the pool and
+// penalizes the peer for those failing validation.
+func (h *Handler) addTxs(peer string, txs []*types.Transaction) []error {
+	errs := h.txpool.AddRemotes(txs)
+
+	penalty := 0
+	for _, err := range errs {
+		switch {
+		case errors.Is(err, core.ErrInvalidSender), errors.Is(err, core.ErrNegativeValue):
+			penalty += invalidTxPenalty
+		case errors.Is(err, core.ErrNonceTooLow), errors.Is(err, core.ErrInsufficientFunds):
+			penalty += staleTxPenalty
+		}
+	}
+	if penalty > 0 {
+		h.penalizePeer(peer, penalty, "invalid transactions")
+	}
+	return errs
+}
+
+func (h *Handler) handleMsg(peer *Peer) error {
+	msg, err := peer.rw.ReadMsg()
+	if err != nil {
+		return err
+	}
+	if msg.Size > maxMessageSize {
+		return fmt.Errorf("%w: %v > %v", errMsgTooLarge, msg.Size, maxMessageSize)
+	}
+	defer msg.Discard()
+
+	switch msg.Code {
+	case StatusMsg:
+		return errExtraStatusMsg
+
+	case NewBlockHashesMsg:
+		var announces NewBlockHashesPacket
+		if err := msg.Decode(&announces); err != nil {
+			return fmt.Errorf("%w: message %v: %v", errDecode, msg, err)
+		}
+		for _, block := range announces {
+			peer.markBlock(block.Hash)
+			peer.announceHead(block.Hash, block.Number)
+		}
+		for _, block := range announces {
+			if h.chain.GetBlockByHash(block.Hash) == nil {
+				h.blockFetcher.Notify(peer.ID(), block.Hash, block.Number, h.fetchHeader(peer), h.fetchBody(peer))
+			}
+		}
+		return nil
+
+	case NewBlockMsg:
+		var packet NewBlockPacket
+		if err := msg.Decode(&packet); err != nil {
+			return fmt.Errorf("%w: message %v: %v", errDecode, msg, err)
+		}
+		if packet.TD == nil || packet.TD.Sign() < 0 {
+			return fmt.Errorf("%w: invalid total difficulty", errDecode)
+		}
+		block := packet.Block()
+
+		peer.markBlock(block.Hash())
+		if packet.TD.Cmp(peer.TD()) > 0 {
+			peer.SetHead(block.Hash(), block.NumberU64(), packet.TD)
+		}
+		return h.blockFetcher.Enqueue(peer.ID(), block)
+
+	case GetBlockHeadersMsg:
+		var query GetBlockHeadersPacket
+		if err := msg.Decode(&query); err != nil {
+			return fmt.Errorf("%w: message %v: %v", errDecode, msg, err)
+		}
+		return p2p.Send(peer.rw, BlockHeadersMsg, &BlockHeadersPacket{
+			RequestId: query.RequestId,
+			Headers:   h.serveHeaders(&query),
+		})
+
+	case BlockHeadersMsg:
+		var res BlockHeadersPacket
+		if err := msg.Decode(&res); err != nil {
+			return fmt.Errorf("%w: message %v: %v", errDecode, msg, err)
+		}
+		peer.deliver(res.RequestId, res.Headers)
+		return nil
+
+	case GetBlockBodiesMsg:
+		var query GetBlockBodiesPacket
+		if err := msg.Decode(&query); err != nil {
+			return fmt.Errorf("%w: message %v: %v", errDecode, msg, err)
+		}
+		return p2p.Send(peer.rw, BlockBodiesMsg, &BlockBodiesPacket{
+			RequestId: query.RequestId,
+			Bodies:    h.serveBodies(&query),
+		})
+
+	case BlockBodiesMsg:
+		var res BlockBodiesPacket
+		if err := msg.Decode(&res); err != nil {
+			return fmt.Errorf("%w: message %v: %v", errDecode, msg, err)
+		}
+		peer.deliver(res.RequestId, res.Bodies)
+		return nil
+
+	case TransactionsMsg:
+		var txs TransactionsPacket
+		if err := msg.Decode(&txs); err != nil {
+			return fmt.Errorf("%w: message %v: %v", errDecode, msg, err)
+		}
+		for i, tx := range txs {
+			if tx == nil {
+				return fmt.Errorf("%w: transaction %d is nil", errDecode, i)
+			}
+			peer.markTransaction(tx.Hash())
+		}
+		h.txFetcher.Enqueue(peer.ID(), txs)
+		return nil
+
+	case NewPooledTransactionHashesMsg:
+		var hashes NewPooledTransactionHashesPacket
+		if err := msg.Decode(&hashes); err != nil {
+			return fmt.Errorf("%w: message %v: %v", errDecode, msg, err)
+		}
+		peer.knownTxs.Add(hashes...)
+		h.txFetcher.Notify(peer.ID(), hashes, peer.RequestTxs)
+		return nil
+
+	case GetPooledTransactionsMsg:
+		var query GetPooledTransactionsPacket
+		if err := msg.Decode(&query); err != nil {
+			return fmt.Errorf("%w: message %v: %v", errDecode, msg, err)
+		}
+		return p2p.Send(peer.rw, PooledTransactionsMsg, &PooledTransactionsPacket{
+			RequestId:    query.RequestId,
+			Transactions: h.serveTransactions(&query),
+		})
+
+	case PooledTransactionsMsg:
+		var res PooledTransactionsPacket
+		if err := msg.Decode(&res); err != nil {
+			return fmt.Errorf("%w: message %v: %v", errDecode, msg, err)
+		}
+		for i, tx := range res.Transactions {
+			if tx == nil {
+				return fmt.Errorf("%w: transaction %d is nil", errDecode, i)
+			}
+			peer.markTransaction(tx.Hash())
+		}
+		peer.deliver(res.RequestId, res.Transactions)
+		return nil
+
+	case CheckpointMsg:
+		var checkpoint types.Checkpoint
+		if err := msg.Decode(&checkpoint); err != nil {
+			return fmt.Errorf("%w: message %v: %v", errDecode, msg, err)
+		}
+		h.addCheckpoint(peer, &checkpoint)
+		return nil
+
+	default:
+		return fmt.Errorf("%w: %v", errInvalidMsgCode, msg.Code)
+	}
+}
+
+func (h *Handler) serveHeaders(query *GetBlockHeadersPacket) []*types.Header {
+	var headers []*types.Header
+
+	hash := query.Origin
+	for uint64(len(headers)) < query.Amount && len(headers) < maxHeadersServe {
+		header := h.chain.GetHeaderByHash(hash)
+		if header == nil {
+			break
+		}
+		headers = append(headers, header)
+		if header.Number.Sign() == 0 {
+			break
+		}
+		hash = header.ParentHash
+	}
+	return headers
+}
+
+func (h *Handler) serveBodies(query *GetBlockBodiesPacket) []*types.Body {
+	var bodies []*types.Body
+
+	for i, hash := range query.Hashes {
+		if i >= maxBodiesServe {
+			break
+		}
+		if block := h.chain.GetBlockByHash(hash); block != nil {
+			bodies = append(bodies, block.Body())
+		}
+	}
+	return bodies
+}
+
+func (h *Handler) serveTransactions(query *GetPooledTransactionsPacket) []*types.Transaction {
+	var txs []*types.Transaction
+
+	for _, hash := range query.Hashes {
+		if len(txs) >= maxTxsServe {
+			break
+		}
+		if tx := h.txpool.Get(hash); tx != nil {
+			txs = append(txs, tx)
+		}
+	}
+	return txs
+}
+
+func (h *Handler) fetchHeader(peer *Peer) func(common.Hash) (*types.Header, error) {
+	return func(hash common.Hash) (*types.Header, error) {
+		headers, err := peer.RequestHeaders(hash, 1)
+		if err != nil {
+			return nil, err
+		}
+		if len(headers) != 1 {
+			return nil, fmt.Errorf("unexpected header count %d", len(headers))
+		}
+		return headers[0], nil
+	}
+}
+
+func (h *Handler) fetchBody(peer *Peer) func(common.Hash) (types.Transactions, error) {
+	return func(hash common.Hash) (types.Transactions, error) {
+		bodies, err := peer.RequestBodies([]common.Hash{hash})
+		if err != nil {
+			return nil, err
+		}
+		if len(bodies) != 1 {
+			return nil, fmt.Errorf("unexpected body count %d", len(bodies))
+		}
+		return bodies[0].Transactions, nil
+	}
+}
+
+// BroadcastBlock sends the full block to a subset of the peers that do not
+// know about it yet, or announces its hash to all of them.
+func (h *Handler) BroadcastBlock(block *types.Block, propagate bool) {
+	hash := block.Hash()
+	peers := h.peers.peersWithoutBlock(hash)
+
+	if propagate {
+		var td *big.Int
+		if parent := h.chain.GetHeader(block.ParentHash(), block.NumberU64()-1); parent != nil {
+			if ptd := h.chain.GetTd(parent.Hash(), parent.Number.Uint64()); ptd != nil {
+				td = new(big.Int).Add(ptd, h.engine.CalcDifficulty(parent))
+			}
+		}
+		if td == nil {
+			fmt.Println("Propagating dangling block", "number", block.Number(), "hash", hash)
+			return
+		}
+		transfer := peers[:int(math.Sqrt(float64(len(peers))))]
+		for _, peer := range transfer {
+			peer.AsyncSendNewBlock(block, td)
+		}
+		return
+	}
+	for _, peer := range peers {
+		peer.AsyncSendNewBlockHash(block)
+	}
+}
+
+func (h *Handler) minedBroadcastLoop() {
+	defer h.wg.Done()
+
+	for {
+		select {
+		case ev := <-h.minedBlockCh:
+			h.BroadcastBlock(ev.Block, true)
+			h.BroadcastBlock(ev.Block, false)
+		case <-h.minedBlockSub.Err():
+			return
+		}
+	}
+}
+
+// BroadcastTransactions sends the full transactions to a subset of the peers
+// that do not know about them yet and announces their hashes to the rest.
+func (h *Handler) BroadcastTransactions(txs types.Transactions) {
+	var (
+		txset  = make(map[*Peer][]*types.Transaction)
+		annset = make(map[*Peer][]common.Hash)
+	)
+	for _, tx := range txs {
+		peers := h.peers.peersWithoutTransaction(tx.Hash())
+
+		numDirect := int(math.Sqrt(float64(len(peers))))
+		for _, peer := range peers[:numDirect] {
+			txset[peer] = append(txset[peer], tx)
+		}
+		for _, peer := range peers[numDirect:] {
+			annset[peer] = append(annset[peer], tx.Hash())
+		}
+	}
+	for peer, txs := range txset {
+		peer.AsyncSendTransactions(txs)
+	}
+	for peer, hashes := range annset {
+		peer.AsyncSendPooledTransactionHashes(hashes)
+	}
+}
+
+// BroadcastCheckpoint sends a signed checkpoint to all the peers that do not
+// have it yet.
+func (h *Handler) BroadcastCheckpoint(checkpoint *types.Checkpoint) {
+	for _, peer := range h.peers.peersWithoutCheckpoint(checkpoint.Number) {
+		peer.AsyncSendCheckpoint(checkpoint)
+	}
+}
+
+func (h *Handler) checkpointBroadcastLoop() {
+	defer h.wg.Done()
+
+	for {
+		select {
+		case ev := <-h.checkpointCh:
+			h.BroadcastCheckpoint(ev.Checkpoint)
+		case <-h.checkpointSub.Err():
+			return
+		}
+	}
+}
+
+func (h *Handler) txBroadcastLoop() {
+	defer h.wg.Done()
+
+	for {
+		select {
+		case ev := <-h.txsCh:
+			h.BroadcastTransactions(ev.Txs)
+		case <-h.txsSub.Err():
+			return
+		}
+	}
+}
+
+// NodeInfo represents a short summary of the eth sub-protocol metadata
+// known about the host peer.
+type NodeInfo struct {
+	Network    uint64      `json:"network"`    // Ethereum network ID
+	Difficulty *big.Int    `json:"difficulty"` // Total difficulty of the host's blockchain
+	Number     uint64      `json:"number"`     // Number of the host's blockchain head
+	Genesis    common.Hash `json:"genesis"`    // SHA3 hash of the host's genesis block
+	Head       common.Hash `json:"head"`       // Hash of the host's best owned block
+}
+
+// NodeInfo retrieves some eth protocol metadata about the running host node.
+func (h *Handler) NodeInfo() *NodeInfo {
+	head := h.chain.CurrentBlock()
+
+	return &NodeInfo{
+		Network:    h.networkID,
+		Difficulty: h.chain.GetTd(head.Hash(), head.NumberU64()),
+		Number:     head.NumberU64(),
+		Genesis:    h.chain.Genesis().Hash(),
+		Head:       head.Hash(),
+	}
+}
@@ -0,0 +1,470 @@
+package eth
+
+import (
+	"bcsbs/core/types"
+	"bcsbs/p2p"
+	"errors"
+	"math/big"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+const (
+	maxKnownBlocks = 1024
+	maxKnownTxs    = 32768
+
+	maxQueuedBlocks   = 4
+	maxQueuedBlockAns = 4
+	maxQueuedTxs      = 64
+	maxQueuedTxAnns   = 64
+
+	maxQueuedCheckpoints = 4
+
+	requestTimeout = 10 * time.Second
+
+	// Every peer starts with maxPeerScore. Misbehaviour is subtracted from
+	// the score, which recovers by one point per scoreRecoverInterval.
+	// Peers reaching zero are disconnected and banned.
+	maxPeerScore         = 100
+	scoreRecoverInterval = 10 * time.Second
+
+	badBlockPenalty      = maxPeerScore
+	badCheckpointPenalty = maxPeerScore
+	invalidTxPenalty     = 20
+	staleTxPenalty       = 1
+)
+
+var (
+	errRequestTimeout = errors.New("request timed out")
+	errPeerClosed     = errors.New("peer closed")
+)
+
+type Peer struct {
+	id string
+
+	*p2p.Peer
+	rw p2p.MsgReadWriter
+
+	version uint
+
+	head   common.Hash
+	number uint64
+	td     *big.Int
+	lock   sync.RWMutex
+
+	knownBlocks     *knownCache
+	queuedBlocks    chan *blockPropagation
+	queuedBlockAnns chan *types.Block
+
+	knownCheckpoint   uint64 // number of the latest signed checkpoint the peer has
+	queuedCheckpoints chan *types.Checkpoint
+
+	knownTxs     *knownCache
+	queuedTxs    chan []*types.Transaction
+	queuedTxAnns chan []common.Hash
+
+	reqLock sync.Mutex
+	pending map[uint64]chan interface{}
+
+	score        int
+	scoreUpdated time.Time
+	scoreLock    sync.Mutex
+
+	term chan struct{}
+}
+
+func NewPeer(version uint, p *p2p.Peer, rw p2p.MsgReadWriter) *Peer {
+	peer := &Peer{
+		id:                p.ID().String(),
+		Peer:              p,
+		rw:                rw,
+		version:           version,
+		knownBlocks:       newKnownCache(maxKnownBlocks),
+		queuedBlocks:      make(chan *blockPropagation, maxQueuedBlocks),
+		queuedBlockAnns:   make(chan *types.Block, maxQueuedBlockAns),
+		queuedCheckpoints: make(chan *types.Checkpoint, maxQueuedCheckpoints),
+		knownTxs:          newKnownCache(maxKnownTxs),
+		queuedTxs:         make(chan []*types.Transaction, maxQueuedTxs),
+		queuedTxAnns:      make(chan []common.Hash, maxQueuedTxAnns),
+		pending:           make(map[uint64]chan interface{}),
+		td:                new(big.Int),
+		score:             maxPeerScore,
+		scoreUpdated:      time.Now(),
+		term:              make(chan struct{}),
+	}
+	go peer.broadcastBlocks()
+	go peer.broadcastTransactions()
+
+	return peer
+}
+
+func (p *Peer) Close() {
+	close(p.term)
+}
+
+func (p *Peer) ID() string {
+	return p.id
+}
+
+func (p *Peer) Version() uint {
+	return p.version
+}
+
+func (p *Peer) Head() (hash common.Hash, number uint64) {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	return p.head, p.number
+}
+
+// TD returns the total difficulty of the peer's chain, as last advertised.
+func (p *Peer) TD() *big.Int {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	return new(big.Int).Set(p.td)
+}
+
+func (p *Peer) SetHead(hash common.Hash, number uint64, td *big.Int) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.head, p.number = hash, number
+	p.td = new(big.Int).Set(td)
+}
+
+// announceHead moves the head of the peer to an announced block. The total
+// difficulty is not known until the peer sends the full block.
+func (p *Peer) announceHead(hash common.Hash, number uint64) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if number > p.number {
+		p.head, p.number = hash, number
+	}
+}
+
+// Score returns the current score of the peer.
+func (p *Peer) Score() int {
+	return p.penalize(0)
+}
+
+// penalize subtracts penalty from the peer score and returns the new score.
+func (p *Peer) penalize(penalty int) int {
+	p.scoreLock.Lock()
+	defer p.scoreLock.Unlock()
+
+	if recovered := int(time.Since(p.scoreUpdated) / scoreRecoverInterval); recovered > 0 {
+		p.score += recovered
+		if p.score > maxPeerScore {
+			p.score = maxPeerScore
+		}
+		p.scoreUpdated = p.scoreUpdated.Add(time.Duration(recovered) * scoreRecoverInterval)
+	}
+	p.score -= penalty
+	return p.score
+}
+
+// PeerInfo represents a short summary of the eth sub-protocol metadata known
+// about a connected peer.
+type PeerInfo struct {
+	Version    uint        `json:"version"`
+	Difficulty *big.Int    `json:"difficulty"`
+	Head       common.Hash `json:"head"`
+	Number     uint64      `json:"number"`
+	Score      int         `json:"score"`
+}
+
+// Info gathers and returns a collection of metadata known about a peer.
+func (p *Peer) Info() *PeerInfo {
+	hash, number := p.Head()
+
+	return &PeerInfo{
+		Version:    p.version,
+		Difficulty: p.TD(),
+		Head:       hash,
+		Number:     number,
+		Score:      p.Score(),
+	}
+}
+
+func (p *Peer) KnownBlock(hash common.Hash) bool {
+	return p.knownBlocks.Contains(hash)
+}
+
+func (p *Peer) markBlock(hash common.Hash) {
+	p.knownBlocks.Add(hash)
+}
+
+// KnownCheckpoint returns whether the peer has a signed checkpoint at or
+// above number.
+func (p *Peer) KnownCheckpoint(number uint64) bool {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	return number <= p.knownCheckpoint
+}
+
+func (p *Peer) markCheckpoint(number uint64) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if number > p.knownCheckpoint {
+		p.knownCheckpoint = number
+	}
+}
+
+func (p *Peer) KnownTransaction(hash common.Hash) bool {
+	return p.knownTxs.Contains(hash)
+}
+
+func (p *Peer) markTransaction(hash common.Hash) {
+	p.knownTxs.Add(hash)
+}
+
+// Block propagation
+
+// blockPropagation is a block queued for propagation together with the
+// total difficulty of its chain.
+type blockPropagation struct {
+	block *types.Block
+	td    *big.Int
+}
+
+func (p *Peer) broadcastBlocks() {
+	for {
+		select {
+		case prop := <-p.queuedBlocks:
+			if err := p.SendNewBlock(prop.block, prop.td); err != nil {
+				return
+			}
+		case block := <-p.queuedBlockAnns:
+			if err := p.SendNewBlockHashes([]common.Hash{block.Hash()}, []uint64{block.NumberU64()}); err != nil {
+				return
+			}
+		case checkpoint := <-p.queuedCheckpoints:
+			if err := p.SendCheckpoint(checkpoint); err != nil {
+				return
+			}
+		case <-p.term:
+			return
+		}
+	}
+}
+
+func (p *Peer) SendNewBlockHashes(hashes []common.Hash, numbers []uint64) error {
+	for _, hash := range hashes {
+		p.markBlock(hash)
+	}
+	request := make(NewBlockHashesPacket, len(hashes))
+	for i := 0; i < len(hashes); i++ {
+		request[i].Hash = hashes[i]
+		request[i].Number = numbers[i]
+	}
+	return p2p.Send(p.rw, NewBlockHashesMsg, request)
+}
+
+func (p *Peer) AsyncSendNewBlockHash(block *types.Block) {
+	select {
+	case p.queuedBlockAnns <- block:
+		p.markBlock(block.Hash())
+	default:
+	}
+}
+
+func (p *Peer) SendNewBlock(block *types.Block, td *big.Int) error {
+	p.markBlock(block.Hash())
+	return p2p.Send(p.rw, NewBlockMsg, &NewBlockPacket{
+		Header:       block.Header(),
+		Transactions: block.Transactions(),
+		TD:           td,
+	})
+}
+
+func (p *Peer) AsyncSendNewBlock(block *types.Block, td *big.Int) {
+	select {
+	case p.queuedBlocks <- &blockPropagation{block: block, td: td}:
+		p.markBlock(block.Hash())
+	default:
+	}
+}
+
+// Transaction propagation
+
+func (p *Peer) broadcastTransactions() {
+	for {
+		select {
+		case txs := <-p.queuedTxs:
+			if err := p.SendTransactions(txs); err != nil {
+				return
+			}
+		case hashes := <-p.queuedTxAnns:
+			if err := p.SendPooledTransactionHashes(hashes); err != nil {
+				return
+			}
+		case <-p.term:
+			return
+		}
+	}
+}
+
+func (p *Peer) SendCheckpoint(checkpoint *types.Checkpoint) error {
+	p.markCheckpoint(checkpoint.Number)
+	return p2p.Send(p.rw, CheckpointMsg, checkpoint)
+}
+
+func (p *Peer) AsyncSendCheckpoint(checkpoint *types.Checkpoint) {
+	select {
+	case p.queuedCheckpoints <- checkpoint:
+		p.markCheckpoint(checkpoint.Number)
+	default:
+	}
+}
+
+func (p *Peer) SendTransactions(txs types.Transactions) error {
+	for _, tx := range txs {
+		p.markTransaction(tx.Hash())
+	}
+	return p2p.Send(p.rw, TransactionsMsg, txs)
+}
+
+func (p *Peer) AsyncSendTransactions(txs []*types.Transaction) {
+	select {
+	case p.queuedTxs <- txs:
+		for _, tx := range txs {
+			p.markTransaction(tx.Hash())
+		}
+	default:
+	}
+}
+
+func (p *Peer) SendPooledTransactionHashes(hashes []common.Hash) error {
+	p.knownTxs.Add(hashes...)
+	return p2p.Send(p.rw, NewPooledTransactionHashesMsg, NewPooledTransactionHashesPacket(hashes))
+}
+
+func (p *Peer) AsyncSendPooledTransactionHashes(hashes []common.Hash) {
+	select {
+	case p.queuedTxAnns <- hashes:
+		p.knownTxs.Add(hashes...)
+	default:
+	}
+}
+
+// Requests
+
+func (p *Peer) RequestHeaders(origin common.Hash, amount uint64) ([]*types.Header, error) {
+	res, err := p.request(GetBlockHeadersMsg, func(id uint64) interface{} {
+		return &GetBlockHeadersPacket{RequestId: id, Origin: origin, Amount: amount}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res.([]*types.Header), nil
+}
+
+func (p *Peer) RequestBodies(hashes []common.Hash) ([]*types.Body, error) {
+	res, err := p.request(GetBlockBodiesMsg, func(id uint64) interface{} {
+		return &GetBlockBodiesPacket{RequestId: id, Hashes: hashes}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res.([]*types.Body), nil
+}
+
+func (p *Peer) RequestTxs(hashes []common.Hash) ([]*types.Transaction, error) {
+	res, err := p.request(GetPooledTransactionsMsg, func(id uint64) interface{} {
+		return &GetPooledTransactionsPacket{RequestId: id, Hashes: hashes}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res.([]*types.Transaction), nil
+}
+
+func (p *Peer) request(code uint64, packet func(id uint64) interface{}) (interface{}, error) {
+	id := rand.Uint64()
+	resCh := make(chan interface{}, 1)
+
+	p.reqLock.Lock()
+	p.pending[id] = resCh
+	p.reqLock.Unlock()
+
+	defer func() {
+		p.reqLock.Lock()
+		delete(p.pending, id)
+		p.reqLock.Unlock()
+	}()
+
+	if err := p2p.Send(p.rw, code, packet(id)); err != nil {
+		return nil, err
+	}
+
+	timeout := time.NewTimer(requestTimeout)
+	defer timeout.Stop()
+
+	select {
+	case res := <-resCh:
+		return res, nil
+	case <-timeout.C:
+		return nil, errRequestTimeout
+	case <-p.term:
+		return nil, errPeerClosed
+	}
+}
+
+func (p *Peer) deliver(id uint64, res interface{}) bool {
+	p.reqLock.Lock()
+	resCh, ok := p.pending[id]
+	delete(p.pending, id)
+	p.reqLock.Unlock()
+
+	if ok {
+		resCh <- res
+	}
+	return ok
+}
+
+// knownCache is a bounded set of hashes, evicting the oldest entry first.
+type knownCache struct {
+	hashes map[common.Hash]struct{}
+	order  []common.Hash
+	max    int
+	lock   sync.Mutex
+}
+
+func newKnownCache(max int) *knownCache {
+	return &knownCache{
+		hashes: make(map[common.Hash]struct{}, max),
+		max:    max,
+	}
+}
+
+func (k *knownCache) Add(hashes ...common.Hash) {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+
+	for _, hash := range hashes {
+		if _, ok := k.hashes[hash]; ok {
+			continue
+		}
+		for len(k.order) >= k.max {
+			delete(k.hashes, k.order[0])
+			k.order = k.order[1:]
+		}
+		k.hashes[hash] = struct{}{}
+		k.order = append(k.order, hash)
+	}
+}
+
+func (k *knownCache) Contains(hash common.Hash) bool {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+
+	_, ok := k.hashes[hash]
+	return ok
+}
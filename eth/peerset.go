@@ -0,0 +1,132 @@
+package eth
+
+import (
+	"bcsbs/p2p"
+	"errors"
+	"math/big"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+var (
+	errPeerSetClosed         = errors.New("peerset closed")
+	errPeerAlreadyRegistered = errors.New("peer already registered")
+	errPeerNotRegistered     = errors.New("peer not registered")
+)
+
+type peerSet struct {
+	peers  map[string]*Peer
+	lock   sync.RWMutex
+	closed bool
+}
+
+func newPeerSet() *peerSet {
+	return &peerSet{
+		peers: make(map[string]*Peer),
+	}
+}
+
+func (ps *peerSet) register(p *Peer) error {
+	ps.lock.Lock()
+	defer ps.lock.Unlock()
+
+	if ps.closed {
+		return errPeerSetClosed
+	}
+	if _, ok := ps.peers[p.ID()]; ok {
+		return errPeerAlreadyRegistered
+	}
+	ps.peers[p.ID()] = p
+	return nil
+}
+
+func (ps *peerSet) unregister(id string) error {
+	ps.lock.Lock()
+	defer ps.lock.Unlock()
+
+	if _, ok := ps.peers[id]; !ok {
+		return errPeerNotRegistered
+	}
+	delete(ps.peers, id)
+	return nil
+}
+
+func (ps *peerSet) peer(id string) *Peer {
+	ps.lock.RLock()
+	defer ps.lock.RUnlock()
+
+	return ps.peers[id]
+}
+
+func (ps *peerSet) len() int {
+	ps.lock.RLock()
+	defer ps.lock.RUnlock()
+
+	return len(ps.peers)
+}
+
+func (ps *peerSet) close() {
+	ps.lock.Lock()
+	defer ps.lock.Unlock()
+
+	for _, p := range ps.peers {
+		p.Disconnect(p2p.DiscQuitting)
+	}
+	ps.closed = true
+}
+
+func (ps *peerSet) peersWithoutBlock(hash common.Hash) []*Peer {
+	ps.lock.RLock()
+	defer ps.lock.RUnlock()
+
+	list := make([]*Peer, 0, len(ps.peers))
+	for _, p := range ps.peers {
+		if !p.KnownBlock(hash) {
+			list = append(list, p)
+		}
+	}
+	return list
+}
+
+func (ps *peerSet) peersWithoutTransaction(hash common.Hash) []*Peer {
+	ps.lock.RLock()
+	defer ps.lock.RUnlock()
+
+	list := make([]*Peer, 0, len(ps.peers))
+	for _, p := range ps.peers {
+		if !p.KnownTransaction(hash) {
+			list = append(list, p)
+		}
+	}
+	return list
+}
+
+func (ps *peerSet) peersWithoutCheckpoint(number uint64) []*Peer {
+	ps.lock.RLock()
+	defer ps.lock.RUnlock()
+
+	list := make([]*Peer, 0, len(ps.peers))
+	for _, p := range ps.peers {
+		if !p.KnownCheckpoint(number) {
+			list = append(list, p)
+		}
+	}
+	return list
+}
+
+func (ps *peerSet) peerWithHighestTD() *Peer {
+	ps.lock.RLock()
+	defer ps.lock.RUnlock()
+
+	var (
+		bestPeer *Peer
+		bestTd   *big.Int
+	)
+	for _, p := range ps.peers {
+		if td := p.TD(); bestPeer == nil || td.Cmp(bestTd) > 0 {
+			bestPeer, bestTd = p, td
+		}
+	}
+	return bestPeer
+}
@@ -0,0 +1,114 @@
+package eth
+
+import (
+	"bcsbs/core/types"
+	"errors"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+const (
+	ProtocolName    = "eth"
+	ProtocolVersion = 3
+
+	protocolLength = 12
+	maxMessageSize = 10 * 1024 * 1024
+)
+
+const DefaultNetworkID = 1337
+
+const (
+	StatusMsg                     = 0x00
+	NewBlockHashesMsg             = 0x01
+	TransactionsMsg               = 0x02
+	GetBlockHeadersMsg            = 0x03
+	BlockHeadersMsg               = 0x04
+	GetBlockBodiesMsg             = 0x05
+	BlockBodiesMsg                = 0x06
+	NewBlockMsg                   = 0x07
+	NewPooledTransactionHashesMsg = 0x08
+	GetPooledTransactionsMsg      = 0x09
+	PooledTransactionsMsg         = 0x0a
+	CheckpointMsg                 = 0x0b
+)
+
+const (
+	maxHeadersServe = 1024
+	maxBodiesServe  = 1024
+	maxTxsServe     = 256
+)
+
+var (
+	errNoStatusMsg             = errors.New("no status message")
+	errMsgTooLarge             = errors.New("message too long")
+	errDecode                  = errors.New("invalid message")
+	errInvalidMsgCode          = errors.New("invalid message code")
+	errProtocolVersionMismatch = errors.New("protocol version mismatch")
+	errNetworkIDMismatch       = errors.New("network ID mismatch")
+	errGenesisMismatch         = errors.New("genesis mismatch")
+	errExtraStatusMsg          = errors.New("extra status message")
+)
+
+type StatusPacket struct {
+	ProtocolVersion uint32
+	NetworkID       uint64
+	TD              *big.Int
+	Head            common.Hash
+	Number          uint64
+	Genesis         common.Hash
+}
+
+type NewBlockHashesPacket []struct {
+	Hash   common.Hash
+	Number uint64
+}
+
+// GetBlockHeadersPacket requests Amount headers starting at Origin and
+// walking back through the parent links, newest first.
+type GetBlockHeadersPacket struct {
+	RequestId uint64
+	Origin    common.Hash
+	Amount    uint64
+}
+
+type BlockHeadersPacket struct {
+	RequestId uint64
+	Headers   []*types.Header
+}
+
+type GetBlockBodiesPacket struct {
+	RequestId uint64
+	Hashes    []common.Hash
+}
+
+type BlockBodiesPacket struct {
+	RequestId uint64
+	Bodies    []*types.Body
+}
+
+// NewBlockPacket carries the header and the transactions separately since
+// types.Block has no wire format of its own.
+type NewBlockPacket struct {
+	Header       *types.Header
+	Transactions []*types.Transaction
+	TD           *big.Int
+}
+
+func (p *NewBlockPacket) Block() *types.Block {
+	return types.NewBlockWithHeader(p.Header).WithBody(p.Transactions)
+}
+
+type TransactionsPacket []*types.Transaction
+
+type NewPooledTransactionHashesPacket []common.Hash
+
+type GetPooledTransactionsPacket struct {
+	RequestId uint64
+	Hashes    []common.Hash
+}
+
+type PooledTransactionsPacket struct {
+	RequestId    uint64
+	Transactions []*types.Transaction
+}
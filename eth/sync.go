@@ -0,0 +1,41 @@
+package eth
+
+import (
+	"time"
+)
+
+const (
+	forceSyncCycle = 10 * time.Second
+)
+
+// syncLoop periodically picks the peer with the highest total difficulty and
+// starts downloading its chain if it is heavier than ours.
+func (h *Handler) syncLoop() {
+	defer h.wg.Done()
+
+	ticker := time.NewTicker(forceSyncCycle)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-h.newPeerCh:
+		case <-ticker.C:
+		case <-h.quitSync:
+			h.downloader.Cancel()
+			return
+		}
+
+		peer := h.peers.peerWithHighestTD()
+		if peer == nil {
+			continue
+		}
+		head := h.chain.CurrentBlock()
+		if peer.TD().Cmp(h.chain.GetTd(head.Hash(), head.NumberU64())) <= 0 {
+			continue
+		}
+		if h.downloader.Synchronising() {
+			continue
+		}
+		go h.downloader.Synchronise(peer)
+	}
+}
@@ -18,22 +18,78 @@ type KeyValueStore interface {
 	KeyValueReader
 	KeyValueWriter
 	Batcher
+	Iteratee
 
 	io.Closer
 }
 
+// AncientReader contains the methods required to read from immutable ancient
+// data.
+type AncientReader interface {
+	// HasAncient returns an indicator whether the specified data exists in the
+	// ancient store.
+	HasAncient(kind string, number uint64) (bool, error)
+
+	// Ancient retrieves an ancient binary blob from the append-only immutable files.
+	Ancient(kind string, number uint64) ([]byte, error)
+
+	// Ancients returns the ancient item numbers in the ancient store.
+	Ancients() (uint64, error)
+
+	// AncientSize returns the ancient size of the specified category.
+	AncientSize(kind string) (uint64, error)
+}
+
+// AncientWriter contains the methods required to write to immutable ancient
+// data.
+type AncientWriter interface {
+	// ModifyAncients runs a write operation on the ancient store. If the
+	// function returns an error, any changes to the underlying store are
+	// reverted. The integer return value is the total size of the written data.
+	ModifyAncients(func(AncientWriteOp) error) (int64, error)
+
+	// TruncateHead discards all but the first n ancient data from the ancient
+	// store.
+	TruncateHead(n uint64) error
+
+	// Sync flushes all in-memory ancient store data to disk.
+	Sync() error
+}
+
+// AncientWriteOp is given to the function argument of ModifyAncients.
+type AncientWriteOp interface {
+	// Append adds an RLP-encoded item.
+	Append(kind string, number uint64, item interface{}) error
+
+	// AppendRaw adds an item without RLP-encoding it.
+	AppendRaw(kind string, number uint64, item []byte) error
+}
+
+// AncientStore contains all the methods required to allow handling different
+// ancient data stores backing immutable chain data store.
+type AncientStore interface {
+	AncientReader
+	AncientWriter
+	io.Closer
+}
+
 type Reader interface {
 	KeyValueReader
+	AncientReader
 }
 
 type Writer interface {
 	KeyValueWriter
+	AncientWriter
 }
 
+// Database contains all the methods required by the high level database to
+// not only access the key-value data store but also the chain freezer.
 type Database interface {
 	Reader
 	Writer
 	Batcher
+	Iteratee
 
 	io.Closer
 }
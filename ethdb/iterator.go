@@ -0,0 +1,19 @@
+package ethdb
+
+type Iterator interface {
+	Next() bool
+
+	Error() error
+
+	Key() []byte
+
+	Value() []byte
+
+	Release()
+}
+
+type Iteratee interface {
+	// NewIterator creates an iterator over the keys with the given prefix,
+	// starting at a particular initial key (or after, if it does not exist).
+	NewIterator(prefix []byte, start []byte) Iterator
+}
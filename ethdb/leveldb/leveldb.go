@@ -9,6 +9,7 @@ import (
 	"github.com/syndtr/goleveldb/leveldb/errors"
 	"github.com/syndtr/goleveldb/leveldb/filter"
 	"github.com/syndtr/goleveldb/leveldb/opt"
+	"github.com/syndtr/goleveldb/leveldb/util"
 )
 
 const (
@@ -52,9 +53,8 @@ func NewCustom(file, namespace string, customize func(options *opt.Options)) (*D
 		return nil, err
 	}
 	ldb := &Database{
-		fn:       file,
-		db:       db,
-		quitChan: make(chan chan error),
+		fn: file,
+		db: db,
 	}
 
 	return ldb, nil
@@ -129,6 +129,18 @@ func (db *Database) NewBatchWithSize(size int) ethdb.Batch {
 	}
 }
 
+// Iteratee
+
+func (db *Database) NewIterator(prefix []byte, start []byte) ethdb.Iterator {
+	return db.db.NewIterator(bytesPrefixRange(prefix, start), nil)
+}
+
+func bytesPrefixRange(prefix, start []byte) *util.Range {
+	r := util.BytesPrefix(prefix)
+	r.Start = append(r.Start, start...)
+	return r
+}
+
 // Batch
 
 type batch struct {
@@ -0,0 +1,101 @@
+package event
+
+import "sync"
+
+// Subscription represents a stream of events. The carrier of the events is
+// typically a channel, but isn't part of the interface.
+//
+// The Err channel is closed when Unsubscribe is called. Unsubscribe can be
+// called any number of times.
+type Subscription interface {
+	Err() <-chan error // returns the error channel
+	Unsubscribe()      // cancels sending of events, closing the error channel
+}
+
+// FeedOf implements one-to-many subscriptions where the carrier of events is a
+// channel. Values sent to a Feed are delivered to all subscribed channels
+// simultaneously.
+//
+// Send blocks until every subscriber has received the value or unsubscribed,
+// so subscribers should use buffered channels and keep up with the feed.
+//
+// The zero value is ready to use.
+type FeedOf[T any] struct {
+	mu   sync.Mutex
+	subs map[*feedOfSub[T]]struct{}
+}
+
+// Subscribe adds a channel to the feed. Future sends will be delivered on the
+// channel until the subscription is canceled.
+func (f *FeedOf[T]) Subscribe(ch chan<- T) Subscription {
+	sub := &feedOfSub[T]{
+		feed: f,
+		ch:   ch,
+		quit: make(chan struct{}),
+		err:  make(chan error),
+	}
+	f.mu.Lock()
+	if f.subs == nil {
+		f.subs = make(map[*feedOfSub[T]]struct{})
+	}
+	f.subs[sub] = struct{}{}
+	f.mu.Unlock()
+	return sub
+}
+
+func (f *FeedOf[T]) remove(sub *feedOfSub[T]) {
+	f.mu.Lock()
+	delete(f.subs, sub)
+	f.mu.Unlock()
+}
+
+// Send delivers to all subscribed channels simultaneously. It returns the
+// number of subscribers that the value was sent to.
+func (f *FeedOf[T]) Send(value T) (nsent int) {
+	f.mu.Lock()
+	subs := make([]*feedOfSub[T], 0, len(f.subs))
+	for sub := range f.subs {
+		subs = append(subs, sub)
+	}
+	f.mu.Unlock()
+
+	// Deliver to the subscribers that are ready first, so a slow one does not
+	// hold back the others.
+	var waiting []*feedOfSub[T]
+	for _, sub := range subs {
+		select {
+		case sub.ch <- value:
+			nsent++
+		default:
+			waiting = append(waiting, sub)
+		}
+	}
+	for _, sub := range waiting {
+		select {
+		case sub.ch <- value:
+			nsent++
+		case <-sub.quit:
+		}
+	}
+	return nsent
+}
+
+type feedOfSub[T any] struct {
+	feed    *FeedOf[T]
+	ch      chan<- T
+	quit    chan struct{}
+	errOnce sync.Once
+	err     chan error
+}
+
+func (sub *feedOfSub[T]) Unsubscribe() {
+	sub.errOnce.Do(func() {
+		sub.feed.remove(sub)
+		close(sub.quit)
+		close(sub.err)
+	})
+}
+
+func (sub *feedOfSub[T]) Err() <-chan error {
+	return sub.err
+}
@@ -11,7 +11,7 @@ import (
 
 func Accounts() {
 	state_trie, _ := trie.NewTxTrie(nil)
-	statedb, _ := state.New(state_trie, nil, nil)
+	statedb, _ := state.New(state_trie, nil)
 
 	key, _ := crypto.GenerateKey()
 	addr := crypto.PubkeyToAddress(key.PublicKey)
@@ -5,23 +5,23 @@ import (
 	"bcsbs/core"
 	"bcsbs/core/rawdb"
 	"bcsbs/core/state"
+	"bcsbs/params"
 	"bcsbs/trie"
 	"fmt"
-	"math"
-	"math/big"
 )
 
 func DB() {
 
-	engine := &ethash.Ethash{
-		Target: big.NewInt(int64(math.Pow(16, 3))),
-	}
+	engine := ethash.New(params.DefaultEthashConfig)
 
 	db, _ := rawdb.NewLevelDBDatabase("./my_geth", 0, 0, "", false)
 	state_trie, _ := trie.NewTxTrie(db)
-	statedb, _ := state.New(state_trie, nil, nil)
+	statedb, _ := state.New(state_trie, nil)
 
-	bc := core.NewBlockChain(db, engine, nil, statedb)
+	bc, err := core.NewBlockChain(db, nil, engine, nil, statedb)
+	if err != nil {
+		panic(err)
+	}
 
 	fmt.Println(bc)
 	addr := rawdb.ReadHeadBlock(db).Coinbase()
@@ -8,11 +8,11 @@ import (
 	"bcsbs/core/types"
 	"bcsbs/core/vm"
 	"bcsbs/miner"
+	"bcsbs/params"
 	"bcsbs/trie"
 	"crypto/ecdsa"
 	"encoding/hex"
 	"fmt"
-	"math"
 	"math/big"
 	"strconv"
 	"time"
@@ -144,24 +144,24 @@ func show(addr_contract common.Address, statedb *state.StateDB) {
 func EVM() {
 	genesis := core.DefaultGenesisBlock()
 
-	engine := &ethash.Ethash{
-		Target: big.NewInt(int64(math.Pow(16, 3))),
-	}
+	engine := ethash.New(params.DefaultEthashConfig)
 
 	db, _ := rawdb.NewLevelDBDatabase("./my_geth", 0, 0, "", false)
 	tx_trie, _ := trie.NewTxTrie(db)
 	storage_trie, _ := trie.NewStorageTrie(db)
-	blockCtx := core.NewEVMBlockContext()
 
-	statedb, _ := state.New(tx_trie, storage_trie, &blockCtx)
+	statedb, _ := state.New(tx_trie, storage_trie)
 
-	bc := core.NewBlockChain(db, engine, genesis, statedb)
+	bc, err := core.NewBlockChain(db, nil, engine, genesis, statedb)
+	if err != nil {
+		panic(err)
+	}
 
 	key1, _ := crypto.GenerateKey()
 	key2, _ := crypto.GenerateKey()
 	addr1 := crypto.PubkeyToAddress(key1.PublicKey)
 	addr2 := crypto.PubkeyToAddress(key2.PublicKey)
-	signer := types.HomesteadSigner{}
+	signer := types.LatestSigner(params.DefaultChainConfig)
 
 	pool := core.NewTxPool(bc, signer)
 	backend := &Backend{
@@ -7,9 +7,9 @@ import (
 	"bcsbs/core/state"
 	"bcsbs/core/types"
 	"bcsbs/miner"
+	"bcsbs/params"
 	"bcsbs/trie"
 	"fmt"
-	"math"
 	"math/big"
 	"time"
 
@@ -32,19 +32,20 @@ func (b *Backend) TxPool() *core.TxPool {
 func Miner() {
 	genesis := core.DefaultGenesisBlock()
 
-	engine := &ethash.Ethash{
-		Target: big.NewInt(int64(math.Pow(16, 3))),
-	}
+	engine := ethash.New(params.DefaultEthashConfig)
 
 	db, _ := rawdb.NewLevelDBDatabase("./my_geth", 0, 0, "", false)
 	state_trie, _ := trie.NewTxTrie(db)
-	statedb, _ := state.New(state_trie, nil, nil)
+	statedb, _ := state.New(state_trie, nil)
 
-	bc := core.NewBlockChain(db, engine, genesis, statedb)
+	bc, err := core.NewBlockChain(db, nil, engine, genesis, statedb)
+	if err != nil {
+		panic(err)
+	}
 
 	key, _ := crypto.GenerateKey()
 	addr := crypto.PubkeyToAddress(key.PublicKey)
-	signer := types.HomesteadSigner{}
+	signer := types.LatestSigner(params.DefaultChainConfig)
 
 	pool := core.NewTxPool(bc, signer)
 	backend := &Backend{
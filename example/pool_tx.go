@@ -6,9 +6,9 @@ import (
 	"bcsbs/core/rawdb"
 	"bcsbs/core/state"
 	"bcsbs/core/types"
+	"bcsbs/params"
 	"bcsbs/trie"
 	"fmt"
-	"math"
 	"math/big"
 
 	"github.com/ethereum/go-ethereum/common"
@@ -18,18 +18,19 @@ import (
 func PoolTx() {
 	genesis := core.DefaultGenesisBlock()
 
-	engine := &ethash.Ethash{
-		Target: big.NewInt(int64(math.Pow(16, 3))),
-	}
+	engine := ethash.New(params.DefaultEthashConfig)
 
 	db, _ := rawdb.NewLevelDBDatabase("./my_geth", 0, 0, "", false)
 	state_trie, _ := trie.NewTxTrie(db)
-	statedb, _ := state.New(state_trie, nil, nil)
+	statedb, _ := state.New(state_trie, nil)
 
-	bc := core.NewBlockChain(db, engine, genesis, statedb)
+	bc, err := core.NewBlockChain(db, nil, engine, genesis, statedb)
+	if err != nil {
+		panic(err)
+	}
 
 	key, _ := crypto.GenerateKey()
-	signer := types.HomesteadSigner{}
+	signer := types.LatestSigner(params.DefaultChainConfig)
 
 	pool := core.NewTxPool(bc, signer)
 
@@ -0,0 +1,32 @@
+package example
+
+import (
+	"bcsbs/p2p/simulations"
+	"fmt"
+	"time"
+)
+
+// Simulation starts a small in-process network and mines a few blocks on
+// it. The partition and reorg scenarios live in the tests of the
+// simulations package.
+func Simulation() {
+	network, err := simulations.NewNetwork(4)
+	if err != nil {
+		panic(err)
+	}
+	defer network.Close()
+
+	if err := network.ConnectAll(); err != nil {
+		panic(err)
+	}
+	if err := network.MineBlocks(0, 3, time.Minute); err != nil {
+		panic(err)
+	}
+	if err := network.WaitConverged(10 * time.Second); err != nil {
+		panic(err)
+	}
+
+	for i, head := range network.Heads() {
+		fmt.Println("Converged", "node", i, "number", head.NumberU64(), "hash", head.Hash())
+	}
+}
@@ -5,8 +5,8 @@ import (
 	"bcsbs/core"
 	"bcsbs/core/rawdb"
 	"bcsbs/core/types"
+	"bcsbs/params"
 	"fmt"
-	"math"
 	"math/big"
 	"time"
 
@@ -17,15 +17,16 @@ import (
 func Transactions() {
 	genesis := core.DefaultGenesisBlock()
 
-	engine := &ethash.Ethash{
-		Target: big.NewInt(int64(math.Pow(16, 3))),
-	}
+	engine := ethash.New(params.DefaultEthashConfig)
 
 	db, _ := rawdb.NewLevelDBDatabase("./my_geth", 0, 0, "", false)
-	bc := core.NewBlockChain(db, engine, genesis, nil)
+	bc, err := core.NewBlockChain(db, nil, engine, genesis, nil)
+	if err != nil {
+		panic(err)
+	}
 
 	key, _ := crypto.GenerateKey()
-	signer := types.HomesteadSigner{}
+	signer := types.LatestSigner(params.DefaultChainConfig)
 
 	var i, j int64
 	var k uint64
@@ -3,6 +3,9 @@ package miner
 import (
 	"bcsbs/consensus"
 	"bcsbs/core"
+	"bcsbs/event"
+	"bcsbs/params"
+	"fmt"
 	"sync"
 
 	"github.com/ethereum/go-ethereum/common"
@@ -77,6 +80,21 @@ func (miner *Miner) Mining() bool {
 	return miner.worker.isRunning()
 }
 
+// SubscribeNewMinedBlockEvent registers a subscription of NewMinedBlockEvent,
+// sent for every sealed block written to the chain.
+func (miner *Miner) SubscribeNewMinedBlockEvent(ch chan<- core.NewMinedBlockEvent) event.Subscription {
+	return miner.worker.subscribeNewMinedBlockEvent(ch)
+}
+
+// SetExtra sets the extra data of the blocks mined from now on.
+func (miner *Miner) SetExtra(extra []byte) error {
+	if uint64(len(extra)) > params.MaximumExtraDataSize {
+		return fmt.Errorf("extra exceeds max length. %d > %v", len(extra), params.MaximumExtraDataSize)
+	}
+	miner.worker.setExtra(extra)
+	return nil
+}
+
 func (miner *Miner) SetEtherbase(addr common.Address) {
 	miner.coinbase = addr
 	miner.worker.setEtherbase(addr)
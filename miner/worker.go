@@ -2,9 +2,12 @@ package miner
 
 import (
 	"bcsbs/consensus"
+	"bcsbs/consensus/misc"
 	"bcsbs/core"
 	"bcsbs/core/state"
 	"bcsbs/core/types"
+	"bcsbs/event"
+	"bcsbs/params"
 	"errors"
 	"fmt"
 	"sync"
@@ -17,6 +20,12 @@ import (
 const (
 	resultQueueSize = 10
 	staleThreshold  = 7
+
+	// txChanSize is the size of channel listening to NewTxsEvent.
+	txChanSize = 4096
+
+	// chainHeadChanSize is the size of channel listening to ChainHeadEvent.
+	chainHeadChanSize = 10
 )
 
 var (
@@ -46,8 +55,14 @@ type worker struct {
 	eth    Backend
 	chain  *core.BlockChain
 
+	// Feeds
+	minedFeed event.FeedOf[core.NewMinedBlockEvent]
+
 	// Subscriptions
-	txsCh chan core.NewTxsEvent
+	txsCh        chan core.NewTxsEvent
+	txsSub       event.Subscription
+	chainHeadCh  chan core.ChainHeadEvent
+	chainHeadSub event.Subscription
 
 	// Channels
 	newWorkCh chan *newWorkReq
@@ -62,6 +77,7 @@ type worker struct {
 
 	mu       sync.RWMutex
 	coinbase common.Address
+	extra    []byte
 
 	pendingMu    sync.RWMutex
 	pendingTasks map[common.Hash]*task
@@ -80,7 +96,8 @@ func newWorker(engine consensus.Engine, eth Backend) *worker {
 		eth:    eth,
 		chain:  eth.BlockChain(),
 
-		txsCh: make(chan core.NewTxsEvent),
+		txsCh:       make(chan core.NewTxsEvent, txChanSize),
+		chainHeadCh: make(chan core.ChainHeadEvent, chainHeadChanSize),
 
 		newWorkCh: make(chan *newWorkReq),
 		taskCh:    make(chan *task),
@@ -91,7 +108,8 @@ func newWorker(engine consensus.Engine, eth Backend) *worker {
 		pendingTasks: make(map[common.Hash]*task),
 	}
 
-	eth.TxPool().SubscribeNewTxsEvent(worker.txsCh)
+	worker.txsSub = eth.TxPool().SubscribeNewTxsEvent(worker.txsCh)
+	worker.chainHeadSub = eth.BlockChain().SubscribeChainHeadEvent(worker.chainHeadCh)
 
 	worker.wg.Add(4)
 	go worker.mainLoop()
@@ -110,6 +128,16 @@ func (w *worker) setEtherbase(addr common.Address) {
 	w.coinbase = addr
 }
 
+func (w *worker) setExtra(extra []byte) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.extra = extra
+}
+
+func (w *worker) subscribeNewMinedBlockEvent(ch chan<- core.NewMinedBlockEvent) event.Subscription {
+	return w.minedFeed.Subscribe(ch)
+}
+
 func (w *worker) start() {
 	atomic.StoreInt32(&w.running, 1)
 	w.startCh <- struct{}{}
@@ -141,6 +169,17 @@ func (w *worker) commitWork(interrupt *int32, noempty bool, timestamp int64) {
 		coinbase = w.coinbase
 	}
 
+	// Never seal a block ahead of the local clock, peers would hold it back
+	if parent := w.chain.CurrentBlock(); parent.Time() >= uint64(timestamp) {
+		timer := time.NewTimer(time.Until(time.Unix(int64(parent.Time())+1, 0)))
+		select {
+		case <-timer.C:
+		case <-w.exitCh:
+			timer.Stop()
+			return
+		}
+		timestamp = int64(parent.Time()) + 1
+	}
 	work, err := w.prepareWork(&generateParams{
 		timestamp: uint64(timestamp),
 		coinbase:  coinbase,
@@ -170,7 +209,7 @@ func (w *worker) commit(env *environment, interval func(), update bool, start ti
 		}
 
 		env := env.copy()
-		block, err := w.engine.FinalizeAndAssemble(env.header, env.state, env.txs)
+		block, err := w.engine.FinalizeAndAssemble(env.header, env.state, env.txs, env.receipts)
 		if err != nil {
 			return err
 		}
@@ -200,8 +239,9 @@ type environment struct {
 	tcount   int
 	coinbase common.Address
 
-	header *types.Header
-	txs    []*types.Transaction
+	header   *types.Header
+	txs      []*types.Transaction
+	receipts []*types.Receipt
 }
 
 func (env *environment) copy() *environment {
@@ -215,6 +255,8 @@ func (env *environment) copy() *environment {
 
 	cpy.txs = make([]*types.Transaction, len(env.txs))
 	copy(cpy.txs, env.txs)
+	cpy.receipts = make([]*types.Receipt, len(env.receipts))
+	copy(cpy.receipts, env.receipts)
 	return cpy
 }
 
@@ -244,6 +286,8 @@ func (w *worker) prepareWork(genParams *generateParams) (*environment, error) {
 		Number:     num.Add(num, common.Big1),
 		Time:       timestamp,
 		Coinbase:   genParams.coinbase,
+		GasLimit:   misc.CalcGasLimit(parent.Header()),
+		Extra:      w.extra,
 	}
 
 	if err := w.engine.Prepare(parent.Header(), header); err != nil {
@@ -279,6 +323,10 @@ func (w *worker) commitTransactions(env *environment, txs *types.TransactionsByP
 			return errBlockInterruptedByNewHead
 		}
 
+		if env.header.GasLimit-env.header.GasUsed < params.TxGas {
+			break
+		}
+
 		tx := txs.Peek()
 		if tx == nil {
 			break
@@ -298,21 +346,25 @@ func (w *worker) commitTransactions(env *environment, txs *types.TransactionsByP
 }
 
 func (w *worker) commitTransaction(env *environment, tx *types.Transaction) error {
-	if !env.state.ApplyTx(tx) {
-		return fmt.Errorf("Error TX hash: %s", tx.Hash())
+	receipt, err := core.ApplyTransaction(w.chain.Config(), env.state, env.header, tx, &env.header.GasUsed)
+	if err != nil {
+		return fmt.Errorf("Error TX hash: %s err: %v", tx.Hash(), err)
 	}
 	env.txs = append(env.txs, tx)
+	env.receipts = append(env.receipts, receipt)
 	return nil
 }
 
 func (w *worker) makeEnv(parent *types.Block, header *types.Header, coinbase common.Address) (*environment, error) {
-	state, err := w.chain.StateAt()
+	statedb, err := w.chain.StateAt()
 	if err != nil {
 		return nil, err
 	}
+	// Work on a copy, the chain executes the block again once it is sealed.
+	state := statedb.Copy()
 
 	env := &environment{
-		signer:   types.HomesteadSigner{},
+		signer:   types.MakeSigner(w.chain.Config(), header.Number),
 		state:    state,
 		coinbase: coinbase,
 		header:   header,
@@ -326,21 +378,27 @@ func (w *worker) makeEnv(parent *types.Block, header *types.Header, coinbase com
 
 func (w *worker) mainLoop() {
 	defer w.wg.Done()
+	defer w.txsSub.Unsubscribe()
+	defer w.chainHeadSub.Unsubscribe()
 
 	for {
 		select {
 		case req := <-w.newWorkCh:
 			w.commitWork(req.interrupt, req.noempty, req.timestamp)
-		case ev := <-w.txsCh:
-			if !w.isRunning() && w.current != nil {
-				fmt.Println("!w.isRunning() && w.current != nil: %b, %b", w.isRunning(), w.current != nil)
-				_ = ev
-			} else {
-				fmt.Println("else")
+		case <-w.txsCh:
+			// Sealing work is built on top of the chain state, so idle
+			// workers must not apply pending transactions to it.
+			if w.isRunning() {
 				w.commitWork(nil, true, time.Now().Unix())
 			}
+
+		// System stopped
 		case <-w.exitCh:
 			return
+		case <-w.txsSub.Err():
+			return
+		case <-w.chainHeadSub.Err():
+			return
 		}
 	}
 }
@@ -382,6 +440,14 @@ func (w *worker) newWorkLoop() {
 			clearPending(w.chain.CurrentBlock().NumberU64())
 			timestamp = time.Now().Unix()
 			commit(true, commitInterruptNewHead)
+		case head := <-w.chainHeadCh:
+			clearPending(head.Block.NumberU64())
+			// Work sealed on the old head is stale, rebuild it on top of the
+			// new one while transactions are waiting.
+			if pending, _ := w.eth.TxPool().Stats(); w.isRunning() && pending > 0 {
+				timestamp = time.Now().Unix()
+				commit(true, commitInterruptNewHead)
+			}
 		case <-w.exitCh:
 			return
 		}
@@ -425,6 +491,8 @@ func (w *worker) resultLoop() {
 
 			fmt.Println("Successfully sealed new block", "number", block.Number(), "sealhash", sealhash, "hash", hash)
 
+			w.minedFeed.Send(core.NewMinedBlockEvent{Block: block})
+
 		case <-w.exitCh:
 			return
 		}
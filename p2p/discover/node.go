@@ -0,0 +1,38 @@
+package discover
+
+import (
+	"bcsbs/p2p/enode"
+	"time"
+)
+
+// node represents a host on the network.
+// The fields of Node may not be modified.
+type node struct {
+	*enode.Node
+	addedAt        time.Time // time when the node was added to the table
+	livenessChecks uint      // how often liveness was checked
+}
+
+func wrapNode(n *enode.Node) *node {
+	return &node{Node: n}
+}
+
+func wrapNodes(ns []*enode.Node) []*node {
+	result := make([]*node, len(ns))
+	for i, n := range ns {
+		result[i] = wrapNode(n)
+	}
+	return result
+}
+
+func unwrapNode(n *node) *enode.Node {
+	return n.Node
+}
+
+func unwrapNodes(ns []*node) []*enode.Node {
+	result := make([]*enode.Node, len(ns))
+	for i, n := range ns {
+		result[i] = unwrapNode(n)
+	}
+	return result
+}
@@ -0,0 +1,513 @@
+package discover
+
+import (
+	"bcsbs/p2p/enode"
+	"bytes"
+	"crypto/ecdsa"
+	crand "crypto/rand"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	respTimeout    = 500 * time.Millisecond
+	expiration     = 20 * time.Second
+	bondExpiration = 24 * time.Hour
+
+	maxFindnodeFailures = 5 // nodes exceeding this limit are dropped
+	maxPacketSize       = 1280
+	maxNeighbors        = 12 // fits into maxPacketSize together with the packet header
+)
+
+// UDPConn is a network connection on which discovery can operate.
+type UDPConn interface {
+	ReadFromUDP(b []byte) (n int, addr *net.UDPAddr, err error)
+	WriteToUDP(b []byte, addr *net.UDPAddr) (n int, err error)
+	Close() error
+	LocalAddr() net.Addr
+}
+
+// Config holds settings for the discovery listener.
+type Config struct {
+	// These settings are required and configure the UDP listener:
+	PrivateKey *ecdsa.PrivateKey
+
+	// These settings are optional:
+	Bootnodes []*enode.Node // list of bootstrap nodes
+}
+
+// UDPv4 implements the v4 wire protocol.
+type UDPv4 struct {
+	conn UDPConn
+	priv *ecdsa.PrivateKey
+	self *enode.Node
+	db   *enode.DB
+	tab  *Table
+	wg   sync.WaitGroup
+
+	mu       sync.Mutex
+	matchers map[*replyMatcher]struct{}
+	closing  chan struct{}
+	closed   bool
+}
+
+// replyMatcher represents a pending reply.
+//
+// Some implementations of the protocol wish to send more than one
+// reply packet to findnode. In general, any neighbors packet cannot
+// be matched up with a specific findnode packet.
+//
+// Our implementation handles this by storing a callback function for
+// each pending reply. Incoming packets from a node are dispatched
+// to all callback functions for that node.
+type replyMatcher struct {
+	// these fields must match in the reply.
+	from  enode.ID
+	ip    net.IP
+	ptype byte
+
+	// callback is called when a matching reply arrives. If it returns matched == true, the
+	// reply was acceptable. The second return value indicates whether the callback should
+	// be removed from the pending reply queue. If it returns false, the reply is considered
+	// incomplete and the callback will be invoked again for the next matching reply.
+	callback replyMatchFunc
+
+	// errc receives nil when the callback indicates completion or an
+	// error if no further reply is received within the timeout.
+	errc    chan error
+	timer   *time.Timer
+	matched bool // whether any reply was accepted
+}
+
+type replyMatchFunc func(packetV4) (matched bool, requestDone bool)
+
+// ListenUDP starts listening for discovery packets on the given UDP socket.
+func ListenUDP(c UDPConn, self *enode.Node, db *enode.DB, cfg Config) (*UDPv4, error) {
+	t := &UDPv4{
+		conn:     c,
+		priv:     cfg.PrivateKey,
+		self:     self,
+		db:       db,
+		matchers: make(map[*replyMatcher]struct{}),
+		closing:  make(chan struct{}),
+	}
+	var bootnodes []*enode.Node
+	for _, n := range cfg.Bootnodes {
+		if n.ID() != self.ID() {
+			bootnodes = append(bootnodes, n)
+		}
+	}
+	t.tab = newTable(t, db, bootnodes)
+
+	t.wg.Add(2)
+	go t.readLoop()
+	go func() {
+		defer t.wg.Done()
+		t.tab.loop()
+	}()
+	return t, nil
+}
+
+// Self returns the local node.
+func (t *UDPv4) Self() *enode.Node {
+	return t.self
+}
+
+// Close shuts down the socket and aborts any running queries.
+func (t *UDPv4) Close() {
+	t.mu.Lock()
+	if t.closed {
+		t.mu.Unlock()
+		return
+	}
+	t.closed = true
+	close(t.closing)
+	for rm := range t.matchers {
+		rm.timer.Stop()
+		rm.errc <- errClosed
+	}
+	t.matchers = nil
+	t.mu.Unlock()
+
+	t.conn.Close()
+	t.tab.close()
+	t.wg.Wait()
+}
+
+// ReadRandomNodes fills the given slice with random nodes from the table.
+func (t *UDPv4) ReadRandomNodes(buf []*enode.Node) int {
+	return t.tab.ReadRandomNodes(buf)
+}
+
+// Nodes returns all nodes contained in the table.
+func (t *UDPv4) Nodes() []*enode.Node {
+	return t.tab.Nodes()
+}
+
+// LookupPubkey finds the closest nodes to the given public key.
+func (t *UDPv4) LookupPubkey(key *ecdsa.PublicKey) []*enode.Node {
+	return unwrapNodes(t.lookup(encodePubkey(key)))
+}
+
+func (t *UDPv4) lookupRandom() []*enode.Node {
+	var target encPubkey
+	crand.Read(target[:])
+	return unwrapNodes(t.lookup(target))
+}
+
+func (t *UDPv4) lookupSelf() []*enode.Node {
+	return unwrapNodes(t.lookup(encodePubkey(&t.priv.PublicKey)))
+}
+
+// lookup performs an iterative network search for nodes close to the target.
+func (t *UDPv4) lookup(target encPubkey) []*node {
+	var (
+		targetID = target.id()
+		asked    = map[enode.ID]bool{t.self.ID(): true}
+		seen     = map[enode.ID]bool{t.self.ID(): true}
+		reply    = make(chan []*node, alpha)
+		pending  = 0
+	)
+	result := t.tab.findnodeByID(targetID, bucketSize, false)
+	for _, n := range result.entries {
+		seen[n.ID()] = true
+	}
+	for {
+		// Ask the alpha closest nodes that we haven't asked yet.
+		for i := 0; i < len(result.entries) && pending < alpha; i++ {
+			n := result.entries[i]
+			if !asked[n.ID()] {
+				asked[n.ID()] = true
+				pending++
+				go func(n *node) {
+					reply <- t.query(n, target)
+				}(n)
+			}
+		}
+		if pending == 0 {
+			// We have asked all closest nodes, stop the search.
+			break
+		}
+		select {
+		case nodes := <-reply:
+			for _, n := range nodes {
+				if n != nil && !seen[n.ID()] {
+					seen[n.ID()] = true
+					result.push(n, bucketSize)
+				}
+			}
+		case <-t.closing:
+			return nil
+		}
+		pending--
+	}
+	return result.entries
+}
+
+// query sends a findnode request to n and tracks its failures.
+func (t *UDPv4) query(n *node, target encPubkey) []*node {
+	r, err := t.findnode(n.ID(), n.UDPAddr(), target)
+	if errors.Is(err, errClosed) {
+		return nil
+	}
+	if err != nil || len(r) == 0 {
+		fails := t.db.FindFails(n.ID()) + 1
+		t.db.UpdateFindFails(n.ID(), fails)
+		if fails >= maxFindnodeFailures {
+			fmt.Println("Too many findnode failures, dropping", "id", n.ID().TerminalString(), "failcount", fails)
+			t.tab.delete(n)
+		}
+		return nil
+	}
+	if fails := t.db.FindFails(n.ID()); fails > 0 {
+		t.db.UpdateFindFails(n.ID(), 0)
+	}
+	// Grab as many nodes as possible. Some of them might not be alive anymore, but we'll
+	// just remove those again during revalidation.
+	for _, n := range r {
+		t.tab.addSeenNode(n)
+	}
+	return r
+}
+
+// ping sends a ping message to the given node and waits for a reply.
+func (t *UDPv4) ping(n *enode.Node) error {
+	rm := t.sendPing(n.ID(), n.UDPAddr(), nil)
+	return <-rm.errc
+}
+
+// sendPing sends a ping message to the given node and invokes the callback
+// when the reply arrives.
+func (t *UDPv4) sendPing(toid enode.ID, toaddr *net.UDPAddr, callback func()) *replyMatcher {
+	req := t.makePing(toaddr)
+	packet, hash, err := encode(t.priv, req)
+	if err != nil {
+		errc := make(chan error, 1)
+		errc <- err
+		return &replyMatcher{errc: errc}
+	}
+	// Add a matcher for the reply to the pending reply queue. Pongs are matched if they
+	// reference the ping we're about to send.
+	rm := t.pending(toid, toaddr.IP, p_pongV4, func(p packetV4) (matched bool, requestDone bool) {
+		matched = bytes.Equal(p.(*pongV4).ReplyTok, hash)
+		if matched && callback != nil {
+			callback()
+		}
+		return matched, matched
+	})
+	t.write(toaddr, toid, req.name(), packet)
+	return rm
+}
+
+func (t *UDPv4) makePing(toaddr *net.UDPAddr) *pingV4 {
+	return &pingV4{
+		Version:    4,
+		From:       rpcEndpoint{IP: t.self.IP(), UDP: uint16(t.self.UDP()), TCP: uint16(t.self.TCP())},
+		To:         makeEndpoint(toaddr, 0),
+		Expiration: uint64(time.Now().Add(expiration).Unix()),
+	}
+}
+
+// findnode sends a findnode request to the given node and waits until
+// the node has sent up to k neighbors.
+func (t *UDPv4) findnode(toid enode.ID, toaddr *net.UDPAddr, target encPubkey) ([]*node, error) {
+	t.ensureBond(toid, toaddr)
+
+	// Add a matcher for 'neighbours' replies to the pending reply queue. The matcher is
+	// active until enough nodes have been received.
+	nodes := make([]*node, 0, bucketSize)
+	nreceived := 0
+	rm := t.pending(toid, toaddr.IP, p_neighborsV4, func(r packetV4) (matched bool, requestDone bool) {
+		reply := r.(*neighborsV4)
+		for _, rn := range reply.Nodes {
+			nreceived++
+			n, err := nodeFromRPC(toaddr, rn)
+			if err != nil {
+				continue
+			}
+			nodes = append(nodes, n)
+		}
+		return true, nreceived >= bucketSize
+	})
+	t.send(toaddr, toid, &findnodeV4{
+		Target:     target,
+		Expiration: uint64(time.Now().Add(expiration).Unix()),
+	})
+	// Ensure that callers don't see a timeout if the node actually responded. Since
+	// findnode can receive more than one neighbors response, the reply matcher will be
+	// active until the remote node sends enough nodes. If the remote end doesn't have
+	// enough nodes the reply matcher will time out waiting for the second reply, but
+	// there's no need for an error in that case.
+	err := <-rm.errc
+	if errors.Is(err, errTimeout) && rm.matched {
+		err = nil
+	}
+	return nodes, err
+}
+
+// ensureBond solicits a ping from a node if we haven't seen a ping from it for a while.
+// The remote node only answers findnode once it has verified our endpoint.
+func (t *UDPv4) ensureBond(toid enode.ID, toaddr *net.UDPAddr) {
+	tooOld := time.Since(t.db.LastPingReceived(toid)) > bondExpiration
+	if tooOld || t.db.FindFails(toid) > maxFindnodeFailures {
+		rm := t.sendPing(toid, toaddr, nil)
+		<-rm.errc
+		// Wait for them to ping back and process our pong.
+		time.Sleep(respTimeout)
+	}
+}
+
+// pending adds a reply matcher to the pending reply queue.
+func (t *UDPv4) pending(id enode.ID, ip net.IP, ptype byte, callback replyMatchFunc) *replyMatcher {
+	rm := &replyMatcher{from: id, ip: ip, ptype: ptype, callback: callback, errc: make(chan error, 1)}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.closed {
+		rm.errc <- errClosed
+		return rm
+	}
+	t.matchers[rm] = struct{}{}
+	rm.timer = time.AfterFunc(respTimeout, func() { t.finish(rm, errTimeout) })
+	return rm
+}
+
+// finish removes rm from the pending reply queue and delivers err to the waiting caller.
+func (t *UDPv4) finish(rm *replyMatcher, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if _, ok := t.matchers[rm]; ok {
+		delete(t.matchers, rm)
+		rm.timer.Stop()
+		rm.errc <- err
+	}
+}
+
+// handleReply dispatches a reply packet, invoking reply matchers. It returns
+// whether any matcher considered the packet acceptable.
+func (t *UDPv4) handleReply(from enode.ID, fromIP net.IP, req packetV4) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	matched := false
+	for rm := range t.matchers {
+		if rm.from != from || rm.ptype != req.kind() || !rm.ip.Equal(fromIP) {
+			continue
+		}
+		ok, requestDone := rm.callback(req)
+		matched = matched || ok
+		rm.matched = rm.matched || ok
+		if requestDone {
+			delete(t.matchers, rm)
+			rm.timer.Stop()
+			rm.errc <- nil
+		}
+	}
+	return matched
+}
+
+func (t *UDPv4) send(toaddr *net.UDPAddr, toid enode.ID, req packetV4) ([]byte, error) {
+	packet, hash, err := encode(t.priv, req)
+	if err != nil {
+		return hash, err
+	}
+	return hash, t.write(toaddr, toid, req.name(), packet)
+}
+
+func (t *UDPv4) write(toaddr *net.UDPAddr, toid enode.ID, what string, packet []byte) error {
+	_, err := t.conn.WriteToUDP(packet, toaddr)
+	return err
+}
+
+// readLoop runs in its own goroutine. it handles incoming UDP packets.
+func (t *UDPv4) readLoop() {
+	defer t.wg.Done()
+
+	buf := make([]byte, maxPacketSize)
+	for {
+		nbytes, from, err := t.conn.ReadFromUDP(buf)
+		if err != nil {
+			select {
+			case <-t.closing:
+				return
+			default:
+			}
+			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+				continue
+			}
+			fmt.Println("UDP read error", "err", err)
+			return
+		}
+		if err := t.handlePacket(from, buf[:nbytes]); err != nil && err != errUnsolicitedReply {
+			fmt.Println("Bad discv4 packet", "addr", from, "err", err)
+		}
+	}
+}
+
+func (t *UDPv4) handlePacket(from *net.UDPAddr, buf []byte) error {
+	req, fromKey, hash, err := decode(buf)
+	if err != nil {
+		return err
+	}
+	fromID := fromKey.id()
+
+	switch req := req.(type) {
+	case *pingV4:
+		return t.handlePing(req, from, fromID, fromKey, hash)
+	case *pongV4:
+		return t.handlePong(req, from, fromID)
+	case *findnodeV4:
+		return t.handleFindnode(req, from, fromID)
+	case *neighborsV4:
+		return t.handleNeighbors(req, from, fromID)
+	}
+	return nil
+}
+
+func (t *UDPv4) handlePing(req *pingV4, from *net.UDPAddr, fromID enode.ID, fromKey encPubkey, mac []byte) error {
+	if expired(req.Expiration) {
+		return errExpired
+	}
+	key, err := decodePubkey(fromKey)
+	if err != nil {
+		return errors.New("invalid public key")
+	}
+
+	// Reply.
+	t.send(from, fromID, &pongV4{
+		To:         makeEndpoint(from, req.From.TCP),
+		ReplyTok:   mac,
+		Expiration: uint64(time.Now().Add(expiration).Unix()),
+	})
+
+	// Ping back if our last pong on file is too far in the past.
+	n := wrapNode(enode.NewV4(key, from.IP, int(req.From.TCP), from.Port))
+	if time.Since(t.db.LastPongReceived(fromID)) > bondExpiration {
+		t.sendPing(fromID, from, func() {
+			t.tab.addVerifiedNode(n)
+		})
+	} else {
+		t.tab.addVerifiedNode(n)
+	}
+
+	// Update node database and endpoint predictor.
+	t.db.UpdateLastPingReceived(fromID, time.Now())
+	return nil
+}
+
+func (t *UDPv4) handlePong(req *pongV4, from *net.UDPAddr, fromID enode.ID) error {
+	if expired(req.Expiration) {
+		return errExpired
+	}
+	if !t.handleReply(fromID, from.IP, req) {
+		return errUnsolicitedReply
+	}
+	t.db.UpdateLastPongReceived(fromID, time.Now())
+	return nil
+}
+
+func (t *UDPv4) handleFindnode(req *findnodeV4, from *net.UDPAddr, fromID enode.ID) error {
+	if expired(req.Expiration) {
+		return errExpired
+	}
+	if time.Since(t.db.LastPongReceived(fromID)) > bondExpiration {
+		// No endpoint proof pong exists, we don't process the packet. This prevents an
+		// attack vector where the discovery protocol could be used to amplify traffic in a
+		// DDOS attack. A malicious actor would send a findnode request with the IP address
+		// and UDP port of the target as the source address. The recipient of the findnode
+		// packet would then send a neighbors packet (which is a much bigger packet than
+		// findnode) to the victim.
+		return errUnknownNode
+	}
+
+	// Determine closest nodes.
+	target := req.Target.id()
+	closest := t.tab.findnodeByID(target, bucketSize, true).entries
+
+	// Send neighbors in chunks with at most maxNeighbors per packet
+	// to stay below the packet size limit.
+	p := neighborsV4{Expiration: uint64(time.Now().Add(expiration).Unix())}
+	for i, n := range closest {
+		p.Nodes = append(p.Nodes, nodeToRPC(n))
+		if len(p.Nodes) == maxNeighbors || i == len(closest)-1 {
+			t.send(from, fromID, &p)
+			p.Nodes = p.Nodes[:0]
+		}
+	}
+	return nil
+}
+
+func (t *UDPv4) handleNeighbors(req *neighborsV4, from *net.UDPAddr, fromID enode.ID) error {
+	if expired(req.Expiration) {
+		return errExpired
+	}
+	if !t.handleReply(fromID, from.IP, req) {
+		return errUnsolicitedReply
+	}
+	return nil
+}
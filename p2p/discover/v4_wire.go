@@ -0,0 +1,220 @@
+package discover
+
+import (
+	"bcsbs/p2p/enode"
+	"bytes"
+	"crypto/ecdsa"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+// RPC packet types
+const (
+	p_pingV4 = iota + 1 // zero is 'reserved'
+	p_pongV4
+	p_findnodeV4
+	p_neighborsV4
+)
+
+// RPC request structures
+type (
+	pingV4 struct {
+		Version    uint
+		From, To   rpcEndpoint
+		Expiration uint64
+		// Ignore additional fields (for forward compatibility).
+		Rest []rlp.RawValue `rlp:"tail"`
+	}
+
+	// pongV4 is the reply to pingV4.
+	pongV4 struct {
+		// This field should mirror the UDP envelope address
+		// of the ping packet, which provides a way to discover the
+		// the external address (after NAT).
+		To rpcEndpoint
+
+		ReplyTok   []byte // This contains the hash of the ping packet.
+		Expiration uint64 // Absolute timestamp at which the packet becomes invalid.
+		// Ignore additional fields (for forward compatibility).
+		Rest []rlp.RawValue `rlp:"tail"`
+	}
+
+	// findnodeV4 is a query for nodes close to the given target.
+	findnodeV4 struct {
+		Target     encPubkey
+		Expiration uint64
+		// Ignore additional fields (for forward compatibility).
+		Rest []rlp.RawValue `rlp:"tail"`
+	}
+
+	// neighborsV4 is the reply to findnodeV4.
+	neighborsV4 struct {
+		Nodes      []rpcNode
+		Expiration uint64
+		// Ignore additional fields (for forward compatibility).
+		Rest []rlp.RawValue `rlp:"tail"`
+	}
+
+	rpcNode struct {
+		IP  net.IP // len 4 for IPv4 or 16 for IPv6
+		UDP uint16 // for discovery protocol
+		TCP uint16 // for RLPx protocol
+		ID  encPubkey
+	}
+
+	rpcEndpoint struct {
+		IP  net.IP // len 4 for IPv4 or 16 for IPv6
+		UDP uint16 // for discovery protocol
+		TCP uint16 // for RLPx protocol
+	}
+)
+
+// packetV4 is implemented by all v4 protocol messages.
+type packetV4 interface {
+	name() string
+	kind() byte
+}
+
+func (req *pingV4) name() string      { return "PING/v4" }
+func (req *pingV4) kind() byte        { return p_pingV4 }
+func (req *pongV4) name() string      { return "PONG/v4" }
+func (req *pongV4) kind() byte        { return p_pongV4 }
+func (req *findnodeV4) name() string  { return "FINDNODE/v4" }
+func (req *findnodeV4) kind() byte    { return p_findnodeV4 }
+func (req *neighborsV4) name() string { return "NEIGHBORS/v4" }
+func (req *neighborsV4) kind() byte   { return p_neighborsV4 }
+
+const (
+	macSize  = 32
+	sigSize  = crypto.SignatureLength
+	headSize = macSize + sigSize // space of packet frame data
+)
+
+var (
+	errPacketTooSmall   = errors.New("too small")
+	errBadHash          = errors.New("bad hash")
+	errExpired          = errors.New("expired")
+	errUnsolicitedReply = errors.New("unsolicited reply")
+	errUnknownNode      = errors.New("unknown node")
+	errTimeout          = errors.New("RPC timeout")
+	errClosed           = errors.New("socket closed")
+	errLowPort          = errors.New("low port")
+)
+
+var headSpace = make([]byte, headSize)
+
+// encPubkey is the uncompressed secp256k1 public key without the 0x04 prefix.
+type encPubkey [64]byte
+
+func encodePubkey(key *ecdsa.PublicKey) encPubkey {
+	var e encPubkey
+	copy(e[:], crypto.FromECDSAPub(key)[1:])
+	return e
+}
+
+func decodePubkey(e encPubkey) (*ecdsa.PublicKey, error) {
+	return crypto.UnmarshalPubkey(append([]byte{0x04}, e[:]...))
+}
+
+func (e encPubkey) id() enode.ID {
+	return enode.ID(crypto.Keccak256Hash(e[:]))
+}
+
+func makeEndpoint(addr *net.UDPAddr, tcpPort uint16) rpcEndpoint {
+	ip := addr.IP.To4()
+	if ip == nil {
+		ip = addr.IP.To16()
+	}
+	return rpcEndpoint{IP: ip, UDP: uint16(addr.Port), TCP: tcpPort}
+}
+
+func nodeFromRPC(sender *net.UDPAddr, rn rpcNode) (*node, error) {
+	if rn.UDP <= 1024 {
+		return nil, errLowPort
+	}
+	if rn.IP.IsUnspecified() || rn.IP.IsMulticast() {
+		return nil, fmt.Errorf("invalid IP %v", rn.IP)
+	}
+	key, err := decodePubkey(rn.ID)
+	if err != nil {
+		return nil, err
+	}
+	return wrapNode(enode.NewV4(key, rn.IP, int(rn.TCP), int(rn.UDP))), nil
+}
+
+func nodeToRPC(n *node) rpcNode {
+	return rpcNode{ID: encodePubkey(n.Pubkey()), IP: n.IP(), UDP: uint16(n.UDP()), TCP: uint16(n.TCP())}
+}
+
+// expired checks whether the given UNIX time stamp is in the past.
+func expired(ts uint64) bool {
+	return time.Unix(int64(ts), 0).Before(time.Now())
+}
+
+// encode signs and serializes a packet.
+func encode(priv *ecdsa.PrivateKey, req packetV4) (packet, hash []byte, err error) {
+	b := new(bytes.Buffer)
+	b.Write(headSpace)
+	b.WriteByte(req.kind())
+	if err := rlp.Encode(b, req); err != nil {
+		return nil, nil, err
+	}
+	packet = b.Bytes()
+	sig, err := crypto.Sign(crypto.Keccak256(packet[headSize:]), priv)
+	if err != nil {
+		return nil, nil, err
+	}
+	copy(packet[macSize:], sig)
+	// Add the hash to the front. Note: this doesn't protect the packet in any way.
+	hash = crypto.Keccak256(packet[macSize:])
+	copy(packet, hash)
+	return packet, hash, nil
+}
+
+// decode reads a discovery v4 packet and recovers the sender key.
+func decode(input []byte) (packetV4, encPubkey, []byte, error) {
+	if len(input) < headSize+1 {
+		return nil, encPubkey{}, nil, errPacketTooSmall
+	}
+	hash, sig, sigdata := input[:macSize], input[macSize:headSize], input[headSize:]
+	shouldhash := crypto.Keccak256(input[macSize:])
+	if !bytes.Equal(hash, shouldhash) {
+		return nil, encPubkey{}, nil, errBadHash
+	}
+	fromKey, err := recoverNodeKey(crypto.Keccak256(input[headSize:]), sig)
+	if err != nil {
+		return nil, fromKey, hash, err
+	}
+
+	var req packetV4
+	switch ptype := sigdata[0]; ptype {
+	case p_pingV4:
+		req = new(pingV4)
+	case p_pongV4:
+		req = new(pongV4)
+	case p_findnodeV4:
+		req = new(findnodeV4)
+	case p_neighborsV4:
+		req = new(neighborsV4)
+	default:
+		return nil, fromKey, hash, fmt.Errorf("unknown type: %d", ptype)
+	}
+	s := rlp.NewStream(bytes.NewReader(sigdata[1:]), 0)
+	err = s.Decode(req)
+	return req, fromKey, hash, err
+}
+
+// recoverNodeKey computes the public key used to sign the given hash from the signature.
+func recoverNodeKey(hash, sig []byte) (key encPubkey, err error) {
+	pubkey, err := crypto.Ecrecover(hash, sig)
+	if err != nil {
+		return key, err
+	}
+	copy(key[:], pubkey[1:])
+	return key, nil
+}
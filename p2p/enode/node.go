@@ -0,0 +1,133 @@
+package enode
+
+import (
+	"crypto/ecdsa"
+	"encoding/hex"
+	"fmt"
+	"math/bits"
+	"net"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// ID is a unique identifier for each node, the keccak256 hash of its public key.
+type ID [32]byte
+
+func (n ID) Bytes() []byte {
+	return n[:]
+}
+
+func (n ID) String() string {
+	return fmt.Sprintf("%x", n[:])
+}
+
+// TerminalString returns a shortened hex string for logging.
+func (n ID) TerminalString() string {
+	return hex.EncodeToString(n[:8])
+}
+
+func HexID(in string) (ID, error) {
+	var id ID
+	b, err := hex.DecodeString(strings.TrimPrefix(in, "0x"))
+	if err != nil {
+		return id, err
+	} else if len(b) != len(id) {
+		return id, fmt.Errorf("wrong length, want %d hex chars", len(id)*2)
+	}
+	copy(id[:], b)
+	return id, nil
+}
+
+// PubkeyToIDV4 derives the node ID from a secp256k1 public key.
+func PubkeyToIDV4(key *ecdsa.PublicKey) ID {
+	var id ID
+	copy(id[:], crypto.Keccak256(crypto.FromECDSAPub(key)[1:]))
+	return id
+}
+
+// DistCmp compares the distances a->target and b->target.
+// Returns -1 if a is closer to target, 1 if b is closer to target
+// and 0 if they are equal.
+func DistCmp(target, a, b ID) int {
+	for i := range target {
+		da := a[i] ^ target[i]
+		db := b[i] ^ target[i]
+		if da > db {
+			return 1
+		} else if da < db {
+			return -1
+		}
+	}
+	return 0
+}
+
+// LogDist returns the logarithmic distance between a and b, log2(a ^ b).
+func LogDist(a, b ID) int {
+	lz := 0
+	for i := range a {
+		x := a[i] ^ b[i]
+		if x == 0 {
+			lz += 8
+		} else {
+			lz += bits.LeadingZeros8(x)
+			break
+		}
+	}
+	return len(a)*8 - lz
+}
+
+// Node represents a host on the network.
+type Node struct {
+	id     ID
+	pubkey *ecdsa.PublicKey
+	ip     net.IP
+	tcp    uint16
+	udp    uint16
+}
+
+func NewV4(pubkey *ecdsa.PublicKey, ip net.IP, tcp, udp int) *Node {
+	if ipv4 := ip.To4(); ipv4 != nil {
+		ip = ipv4
+	}
+	return &Node{
+		id:     PubkeyToIDV4(pubkey),
+		pubkey: pubkey,
+		ip:     ip,
+		tcp:    uint16(tcp),
+		udp:    uint16(udp),
+	}
+}
+
+func (n *Node) ID() ID {
+	return n.id
+}
+
+func (n *Node) Pubkey() *ecdsa.PublicKey {
+	return n.pubkey
+}
+
+func (n *Node) IP() net.IP {
+	return n.ip
+}
+
+func (n *Node) TCP() int {
+	return int(n.tcp)
+}
+
+func (n *Node) UDP() int {
+	return int(n.udp)
+}
+
+// TCPEndpoint returns the host:port address used for RLPx connections.
+func (n *Node) TCPEndpoint() string {
+	return (&net.TCPAddr{IP: n.ip, Port: int(n.tcp)}).String()
+}
+
+func (n *Node) UDPAddr() *net.UDPAddr {
+	return &net.UDPAddr{IP: n.ip, Port: int(n.udp)}
+}
+
+func (n *Node) String() string {
+	return n.URLv4()
+}
@@ -0,0 +1,235 @@
+package enode
+
+import (
+	"bcsbs/ethdb"
+	"encoding/binary"
+	"fmt"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+// Keys in the node database.
+const (
+	dbNodePrefix    = "n:" // Identifier to prefix node entries with
+	dbDiscoverPing  = ":lastping"
+	dbDiscoverPong  = ":lastpong"
+	dbDiscoverFails = ":findfail"
+)
+
+const (
+	dbNodeExpiration = 24 * time.Hour // Time after which an unseen node should be dropped.
+	dbCleanupCycle   = time.Hour      // Time period for running the expiration task.
+)
+
+// DB is the node database, storing previously seen nodes and the results of
+// the discovery protocol exchanged with them.
+type DB struct {
+	lvl    ethdb.KeyValueStore
+	runner sync.Once
+	quit   chan struct{}
+}
+
+type nodeRecord struct {
+	Pubkey []byte
+	IP     net.IP
+	TCP    uint16
+	UDP    uint16
+}
+
+func OpenDB(db ethdb.KeyValueStore) *DB {
+	return &DB{lvl: db, quit: make(chan struct{})}
+}
+
+func nodeKey(id ID) []byte {
+	key := append([]byte(dbNodePrefix), id[:]...)
+	return key
+}
+
+func nodeItemKey(id ID, field string) []byte {
+	return append(nodeKey(id), field...)
+}
+
+// Node retrieves a node with a given id from the database.
+func (db *DB) Node(id ID) *Node {
+	blob, err := db.lvl.Get(nodeKey(id))
+	if err != nil {
+		return nil
+	}
+	return decodeNode(blob)
+}
+
+func decodeNode(blob []byte) *Node {
+	var rec nodeRecord
+	if err := rlp.DecodeBytes(blob, &rec); err != nil {
+		fmt.Println("Failed to decode node record", "err", err)
+		return nil
+	}
+	pubkey, err := crypto.UnmarshalPubkey(rec.Pubkey)
+	if err != nil {
+		return nil
+	}
+	return NewV4(pubkey, rec.IP, int(rec.TCP), int(rec.UDP))
+}
+
+// UpdateNode inserts - potentially overwriting - a node into the database.
+func (db *DB) UpdateNode(node *Node) error {
+	blob, err := rlp.EncodeToBytes(&nodeRecord{
+		Pubkey: crypto.FromECDSAPub(node.Pubkey()),
+		IP:     node.IP(),
+		TCP:    uint16(node.TCP()),
+		UDP:    uint16(node.UDP()),
+	})
+	if err != nil {
+		return err
+	}
+	return db.lvl.Put(nodeKey(node.ID()), blob)
+}
+
+// DeleteNode deletes all information associated with a node.
+func (db *DB) DeleteNode(id ID) {
+	it := db.lvl.NewIterator(nodeKey(id), nil)
+	defer it.Release()
+
+	for it.Next() {
+		db.lvl.Delete(copyBytes(it.Key()))
+	}
+}
+
+func copyBytes(b []byte) []byte {
+	return append([]byte{}, b...)
+}
+
+func (db *DB) fetchInt64(key []byte) int64 {
+	blob, err := db.lvl.Get(key)
+	if err != nil || len(blob) != 8 {
+		return 0
+	}
+	return int64(binary.BigEndian.Uint64(blob))
+}
+
+func (db *DB) storeInt64(key []byte, n int64) error {
+	blob := make([]byte, 8)
+	binary.BigEndian.PutUint64(blob, uint64(n))
+	return db.lvl.Put(key, blob)
+}
+
+// LastPingReceived retrieves the time of the last ping packet received from
+// a remote node.
+func (db *DB) LastPingReceived(id ID) time.Time {
+	return time.Unix(db.fetchInt64(nodeItemKey(id, dbDiscoverPing)), 0)
+}
+
+// UpdateLastPingReceived updates the last time we tried contacting a remote node.
+func (db *DB) UpdateLastPingReceived(id ID, instance time.Time) error {
+	return db.storeInt64(nodeItemKey(id, dbDiscoverPing), instance.Unix())
+}
+
+// LastPongReceived retrieves the time of the last successful pong from remote node.
+func (db *DB) LastPongReceived(id ID) time.Time {
+	// Launch expirer
+	db.ensureExpirer()
+	return time.Unix(db.fetchInt64(nodeItemKey(id, dbDiscoverPong)), 0)
+}
+
+// UpdateLastPongReceived updates the last pong time of a node.
+func (db *DB) UpdateLastPongReceived(id ID, instance time.Time) error {
+	return db.storeInt64(nodeItemKey(id, dbDiscoverPong), instance.Unix())
+}
+
+// FindFails retrieves the number of findnode failures since bonding.
+func (db *DB) FindFails(id ID) int {
+	return int(db.fetchInt64(nodeItemKey(id, dbDiscoverFails)))
+}
+
+// UpdateFindFails updates the number of findnode failures since bonding.
+func (db *DB) UpdateFindFails(id ID, fails int) error {
+	return db.storeInt64(nodeItemKey(id, dbDiscoverFails), int64(fails))
+}
+
+// nodes iterates over all node entries, skipping the per-node fields.
+func (db *DB) nodes(fn func(id ID, blob []byte)) {
+	it := db.lvl.NewIterator([]byte(dbNodePrefix), nil)
+	defer it.Release()
+
+	for it.Next() {
+		key := it.Key()
+		if len(key) != len(dbNodePrefix)+len(ID{}) {
+			continue
+		}
+		var id ID
+		copy(id[:], key[len(dbNodePrefix):])
+		fn(id, copyBytes(it.Value()))
+	}
+}
+
+// QuerySeeds retrieves random nodes to be used as potential seed nodes
+// for bootstrapping.
+func (db *DB) QuerySeeds(n int, maxAge time.Duration) []*Node {
+	var (
+		now   = time.Now()
+		nodes []*Node
+	)
+	db.nodes(func(id ID, blob []byte) {
+		if now.Sub(db.LastPongReceived(id)) > maxAge {
+			return
+		}
+		if node := decodeNode(blob); node != nil && node.ID() == id {
+			nodes = append(nodes, node)
+		}
+	})
+	rand.Shuffle(len(nodes), func(i, j int) { nodes[i], nodes[j] = nodes[j], nodes[i] })
+	if len(nodes) > n {
+		nodes = nodes[:n]
+	}
+	return nodes
+}
+
+// ensureExpirer is a small helper method ensuring that the data expiration
+// mechanism is running.
+func (db *DB) ensureExpirer() {
+	db.runner.Do(func() { go db.expirer() })
+}
+
+// expirer should be started in a go routine, and is responsible for looping ad
+// infinitum and dropping stale data from the database.
+func (db *DB) expirer() {
+	tick := time.NewTicker(dbCleanupCycle)
+	defer tick.Stop()
+
+	for {
+		select {
+		case <-tick.C:
+			db.expireNodes()
+		case <-db.quit:
+			return
+		}
+	}
+}
+
+// expireNodes iterates over the database and deletes all nodes that have not
+// been seen (i.e. received a pong from) for some time.
+func (db *DB) expireNodes() {
+	var (
+		threshold = time.Now().Add(-dbNodeExpiration)
+		expired   []ID
+	)
+	db.nodes(func(id ID, blob []byte) {
+		if db.LastPongReceived(id).Before(threshold) {
+			expired = append(expired, id)
+		}
+	})
+	for _, id := range expired {
+		db.DeleteNode(id)
+	}
+}
+
+// Close stops the expiration task. The underlying database is owned by the
+// caller and is not closed.
+func (db *DB) Close() {
+	close(db.quit)
+}
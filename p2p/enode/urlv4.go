@@ -0,0 +1,91 @@
+package enode
+
+import (
+	"crypto/ecdsa"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// MustParseV4 parses a node URL. It panics if the URL is not valid.
+func MustParseV4(rawurl string) *Node {
+	n, err := ParseV4(rawurl)
+	if err != nil {
+		panic("invalid node URL: " + err.Error())
+	}
+	return n
+}
+
+// ParseV4 parses a node URL of the form
+//
+//	enode://<hex node id>@10.3.58.6:30303?discport=30301
+//
+// where the node id is the hex encoded uncompressed public key without the
+// 0x04 prefix. The discport query parameter is only needed when the UDP
+// discovery port differs from the TCP listening port.
+func ParseV4(rawurl string) (*Node, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "enode" {
+		return nil, errors.New("invalid URL scheme, want \"enode\"")
+	}
+	if u.User == nil {
+		return nil, errors.New("does not contain node ID")
+	}
+	pubkey, err := parsePubkey(u.User.String())
+	if err != nil {
+		return nil, fmt.Errorf("invalid public key (%v)", err)
+	}
+
+	ip := net.ParseIP(u.Hostname())
+	if ip == nil {
+		ips, err := net.LookupIP(u.Hostname())
+		if err != nil {
+			return nil, err
+		}
+		ip = ips[0]
+	}
+	tcpPort, err := strconv.ParseUint(u.Port(), 10, 16)
+	if err != nil {
+		return nil, errors.New("invalid port")
+	}
+	udpPort := tcpPort
+	if discport := u.Query().Get("discport"); discport != "" {
+		udpPort, err = strconv.ParseUint(discport, 10, 16)
+		if err != nil {
+			return nil, errors.New("invalid discport in query")
+		}
+	}
+	return NewV4(pubkey, ip, int(tcpPort), int(udpPort)), nil
+}
+
+func parsePubkey(in string) (*ecdsa.PublicKey, error) {
+	b, err := hex.DecodeString(in)
+	if err != nil {
+		return nil, err
+	} else if len(b) != 64 {
+		return nil, fmt.Errorf("wrong length, want %d hex chars", 128)
+	}
+	b = append([]byte{0x4}, b...)
+	return crypto.UnmarshalPubkey(b)
+}
+
+// URLv4 returns the enode URL of n.
+func (n *Node) URLv4() string {
+	u := url.URL{Scheme: "enode"}
+	if n.pubkey != nil {
+		u.User = url.User(hex.EncodeToString(crypto.FromECDSAPub(n.pubkey)[1:]))
+	}
+	u.Host = (&net.TCPAddr{IP: n.ip, Port: int(n.tcp)}).String()
+	if n.udp != n.tcp {
+		u.RawQuery = "discport=" + strconv.Itoa(int(n.udp))
+	}
+	return u.String()
+}
@@ -0,0 +1,109 @@
+package p2p
+
+import (
+	"errors"
+	"fmt"
+)
+
+const (
+	errInvalidMsgCode = iota
+	errInvalidMsg
+)
+
+var errorToString = map[int]string{
+	errInvalidMsgCode: "invalid message code",
+	errInvalidMsg:     "invalid message",
+}
+
+var (
+	errServerStopped     = errors.New("server stopped")
+	errProtocolReturned  = errors.New("protocol returned")
+	errNoMatchingProtos  = errors.New("no matching protocols")
+	errServerNotRunning  = errors.New("server not running")
+	errServerAlreadyRuns = errors.New("server already running")
+	errPeerBanned        = errors.New("peer is banned")
+)
+
+type peerError struct {
+	code    int
+	message string
+}
+
+func newPeerError(code int, format string, v ...interface{}) *peerError {
+	desc, ok := errorToString[code]
+	if !ok {
+		panic("invalid error code")
+	}
+	err := &peerError{code, desc}
+	if format != "" {
+		err.message += ": " + fmt.Sprintf(format, v...)
+	}
+	return err
+}
+
+func (pe *peerError) Error() string {
+	return pe.message
+}
+
+type DiscReason uint8
+
+const (
+	DiscRequested DiscReason = iota
+	DiscNetworkError
+	DiscProtocolError
+	DiscUselessPeer
+	DiscTooManyPeers
+	DiscAlreadyConnected
+	DiscIncompatibleVersion
+	DiscQuitting
+	DiscReadTimeout
+	DiscInvalidIdentity
+	DiscUnexpectedIdentity
+	DiscSelf
+	DiscBadPeer
+	DiscSubprotocolError = DiscReason(0x10)
+)
+
+var discReasonToString = [...]string{
+	DiscRequested:           "disconnect requested",
+	DiscNetworkError:        "network error",
+	DiscProtocolError:       "breach of protocol",
+	DiscUselessPeer:         "useless peer",
+	DiscTooManyPeers:        "too many peers",
+	DiscAlreadyConnected:    "already connected",
+	DiscIncompatibleVersion: "incompatible p2p protocol version",
+	DiscQuitting:            "client quitting",
+	DiscReadTimeout:         "read timeout",
+	DiscInvalidIdentity:     "invalid node identity",
+	DiscUnexpectedIdentity:  "unexpected identity",
+	DiscSelf:                "connected to self",
+	DiscBadPeer:             "misbehaving peer",
+	DiscSubprotocolError:    "subprotocol error",
+}
+
+func (d DiscReason) String() string {
+	if len(discReasonToString) <= int(d) || discReasonToString[d] == "" {
+		return fmt.Sprintf("unknown disconnect reason %d", d)
+	}
+	return discReasonToString[d]
+}
+
+func (d DiscReason) Error() string {
+	return d.String()
+}
+
+func discReasonForError(err error) DiscReason {
+	if reason, ok := err.(DiscReason); ok {
+		return reason
+	}
+	if errors.Is(err, errProtocolReturned) {
+		return DiscQuitting
+	}
+	if peerError, ok := err.(*peerError); ok {
+		switch peerError.code {
+		case errInvalidMsgCode, errInvalidMsg:
+			return DiscProtocolError
+		}
+	}
+	return DiscSubprotocolError
+}
@@ -0,0 +1,49 @@
+package p2p
+
+import (
+	"io"
+	"time"
+
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+type Msg struct {
+	Code       uint64
+	Size       uint32
+	Payload    io.Reader
+	ReceivedAt time.Time
+}
+
+func (msg Msg) Decode(val interface{}) error {
+	s := rlp.NewStream(msg.Payload, uint64(msg.Size))
+	if err := s.Decode(val); err != nil {
+		return newPeerError(errInvalidMsg, "(code %x) (size %d) %v", msg.Code, msg.Size, err)
+	}
+	return nil
+}
+
+func (msg Msg) Discard() error {
+	_, err := io.Copy(io.Discard, msg.Payload)
+	return err
+}
+
+type MsgReader interface {
+	ReadMsg() (Msg, error)
+}
+
+type MsgWriter interface {
+	WriteMsg(Msg) error
+}
+
+type MsgReadWriter interface {
+	MsgReader
+	MsgWriter
+}
+
+func Send(w MsgWriter, msgcode uint64, data interface{}) error {
+	size, r, err := rlp.EncodeToReader(data)
+	if err != nil {
+		return err
+	}
+	return w.WriteMsg(Msg{Code: msgcode, Size: uint32(size), Payload: r})
+}
@@ -0,0 +1,370 @@
+package p2p
+
+import (
+	"bcsbs/p2p/enode"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+const (
+	pingInterval = 15 * time.Second
+)
+
+type Peer struct {
+	rw      *conn
+	running map[string]*protoRW
+
+	created time.Time
+	wg      sync.WaitGroup
+
+	wq       chan writeReq
+	protoErr chan error
+	closed   chan struct{}
+	disc     chan DiscReason
+}
+
+type writeReq struct {
+	msg Msg
+	err chan error
+}
+
+func newPeer(c *conn, protocols []Protocol) *Peer {
+	protomap := matchProtocols(protocols, c.caps)
+	p := &Peer{
+		rw:       c,
+		running:  protomap,
+		created:  time.Now(),
+		wq:       make(chan writeReq),
+		protoErr: make(chan error, len(protomap)+1),
+		closed:   make(chan struct{}),
+		disc:     make(chan DiscReason),
+	}
+	return p
+}
+
+func (p *Peer) ID() enode.ID {
+	return p.rw.node.ID()
+}
+
+func (p *Peer) Node() *enode.Node {
+	return p.rw.node
+}
+
+func (p *Peer) Name() string {
+	return p.rw.name
+}
+
+func (p *Peer) Caps() []Cap {
+	return p.rw.caps
+}
+
+func (p *Peer) RemoteAddr() net.Addr {
+	return p.rw.fd.RemoteAddr()
+}
+
+func (p *Peer) LocalAddr() net.Addr {
+	return p.rw.fd.LocalAddr()
+}
+
+func (p *Peer) Inbound() bool {
+	return p.rw.is(inboundConn)
+}
+
+func (p *Peer) Static() bool {
+	return p.rw.is(staticDialedConn)
+}
+
+func (p *Peer) Trusted() bool {
+	return p.rw.is(trustedConn)
+}
+
+func (p *Peer) Disconnect(reason DiscReason) {
+	select {
+	case p.disc <- reason:
+	case <-p.closed:
+	}
+}
+
+func (p *Peer) String() string {
+	return fmt.Sprintf("Peer %s %v", p.ID().TerminalString(), p.RemoteAddr())
+}
+
+func (p *Peer) run() (remoteRequested bool, err error) {
+	var (
+		readErr  = make(chan error, 1)
+		writeErr = make(chan error, 1)
+		reason   DiscReason
+	)
+
+	p.wg.Add(3)
+	go p.readLoop(readErr)
+	go p.writeLoop(writeErr)
+	go p.pingLoop()
+
+	p.startProtocols()
+
+loop:
+	for {
+		select {
+		case err = <-writeErr:
+			reason = DiscNetworkError
+			break loop
+		case err = <-readErr:
+			if r, ok := err.(DiscReason); ok {
+				remoteRequested = true
+				reason = r
+			} else {
+				reason = DiscNetworkError
+			}
+			break loop
+		case err = <-p.protoErr:
+			reason = discReasonForError(err)
+			break loop
+		case err = <-p.disc:
+			reason = discReasonForError(err)
+			break loop
+		}
+	}
+
+	close(p.closed)
+	p.rw.close(reason)
+	p.wg.Wait()
+	return remoteRequested, err
+}
+
+func (p *Peer) readLoop(errc chan<- error) {
+	defer p.wg.Done()
+
+	for {
+		msg, err := p.rw.ReadMsg()
+		if err != nil {
+			errc <- err
+			return
+		}
+		if err = p.handle(msg); err != nil {
+			errc <- err
+			return
+		}
+	}
+}
+
+func (p *Peer) writeLoop(errc chan<- error) {
+	defer p.wg.Done()
+
+	for {
+		select {
+		case req := <-p.wq:
+			err := p.rw.WriteMsg(req.msg)
+			req.err <- err
+			if err != nil {
+				errc <- err
+				return
+			}
+		case <-p.closed:
+			return
+		}
+	}
+}
+
+func (p *Peer) pingLoop() {
+	defer p.wg.Done()
+
+	ping := time.NewTimer(pingInterval)
+	defer ping.Stop()
+
+	for {
+		select {
+		case <-ping.C:
+			if err := p.send(pingMsg, []interface{}{}); err != nil {
+				select {
+				case p.protoErr <- err:
+				default:
+				}
+				return
+			}
+			ping.Reset(pingInterval)
+		case <-p.closed:
+			return
+		}
+	}
+}
+
+func (p *Peer) send(msgcode uint64, data interface{}) error {
+	size, r, err := rlp.EncodeToReader(data)
+	if err != nil {
+		return err
+	}
+	return p.write(Msg{Code: msgcode, Size: uint32(size), Payload: r})
+}
+
+func (p *Peer) write(msg Msg) error {
+	req := writeReq{msg: msg, err: make(chan error, 1)}
+	select {
+	case p.wq <- req:
+	case <-p.closed:
+		return errServerStopped
+	}
+	return <-req.err
+}
+
+func (p *Peer) handle(msg Msg) error {
+	switch {
+	case msg.Code == pingMsg:
+		msg.Discard()
+		go p.send(pongMsg, []interface{}{})
+	case msg.Code == discMsg:
+		var reason [1]DiscReason
+		rlp.Decode(msg.Payload, &reason)
+		return reason[0]
+	case msg.Code < baseProtocolLength:
+		return msg.Discard()
+	default:
+		proto, err := p.getProto(msg.Code)
+		if err != nil {
+			return fmt.Errorf("msg code out of range: %v", msg.Code)
+		}
+		select {
+		case proto.in <- msg:
+			return nil
+		case <-p.closed:
+			return io.EOF
+		}
+	}
+	return nil
+}
+
+func (p *Peer) startProtocols() {
+	p.wg.Add(len(p.running))
+	for _, proto := range p.running {
+		proto := proto
+		proto.peer = p
+		go func() {
+			defer p.wg.Done()
+			err := proto.Run(p, proto)
+			if err == nil {
+				err = errProtocolReturned
+			}
+			p.protoErr <- err
+		}()
+	}
+}
+
+func (p *Peer) getProto(code uint64) (*protoRW, error) {
+	for _, proto := range p.running {
+		if code >= proto.offset && code < proto.offset+proto.Length {
+			return proto, nil
+		}
+	}
+	return nil, newPeerError(errInvalidMsgCode, "%d", code)
+}
+
+func matchProtocols(protocols []Protocol, caps []Cap) map[string]*protoRW {
+	sort.Slice(caps, func(i, j int) bool {
+		if caps[i].Name != caps[j].Name {
+			return caps[i].Name < caps[j].Name
+		}
+		return caps[i].Version < caps[j].Version
+	})
+
+	offset := baseProtocolLength
+	result := make(map[string]*protoRW)
+
+	for _, cap := range caps {
+		for _, proto := range protocols {
+			if proto.Name == cap.Name && proto.Version == cap.Version {
+				if old := result[cap.Name]; old != nil {
+					offset -= old.Length
+				}
+				result[cap.Name] = &protoRW{Protocol: proto, offset: offset, in: make(chan Msg)}
+				offset += proto.Length
+			}
+		}
+	}
+	return result
+}
+
+type protoRW struct {
+	Protocol
+
+	peer   *Peer
+	in     chan Msg
+	offset uint64
+}
+
+func (rw *protoRW) WriteMsg(msg Msg) error {
+	if msg.Code >= rw.Length {
+		return newPeerError(errInvalidMsgCode, "not handled")
+	}
+	msg.Code += rw.offset
+	return rw.peer.write(msg)
+}
+
+func (rw *protoRW) ReadMsg() (Msg, error) {
+	select {
+	case msg := <-rw.in:
+		msg.Code -= rw.offset
+		return msg, nil
+	case <-rw.peer.closed:
+		return Msg{}, io.EOF
+	}
+}
+
+// PeerInfo represents a short summary of the information known about a connected
+// peer. Sub-protocol independent fields are contained and initialized here, with
+// protocol specifics delegated to all connected sub-protocols.
+type PeerInfo struct {
+	Enode   string   `json:"enode"` // Node URL
+	ID      string   `json:"id"`    // Unique node identifier
+	Name    string   `json:"name"`  // Name of the node, including client type, version, OS, custom data
+	Caps    []string `json:"caps"`  // Protocols advertised by this peer
+	Network struct {
+		LocalAddress  string `json:"localAddress"`  // Local endpoint of the TCP data connection
+		RemoteAddress string `json:"remoteAddress"` // Remote endpoint of the TCP data connection
+		Inbound       bool   `json:"inbound"`
+		Trusted       bool   `json:"trusted"`
+		Static        bool   `json:"static"`
+	} `json:"network"`
+	Protocols map[string]interface{} `json:"protocols"` // Sub-protocol specific metadata fields
+}
+
+// Info gathers and returns a collection of metadata known about a peer.
+func (p *Peer) Info() *PeerInfo {
+	// Gather the protocol capabilities
+	var caps []string
+	for _, cap := range p.Caps() {
+		caps = append(caps, cap.String())
+	}
+	// Assemble the generic peer metadata
+	info := &PeerInfo{
+		Enode:     p.Node().URLv4(),
+		ID:        p.ID().String(),
+		Name:      p.Name(),
+		Caps:      caps,
+		Protocols: make(map[string]interface{}),
+	}
+	info.Network.LocalAddress = p.LocalAddr().String()
+	info.Network.RemoteAddress = p.RemoteAddr().String()
+	info.Network.Inbound = p.rw.is(inboundConn)
+	info.Network.Trusted = p.rw.is(trustedConn)
+	info.Network.Static = p.rw.is(staticDialedConn)
+
+	// Gather all the running protocol infos
+	for _, proto := range p.running {
+		protoInfo := interface{}("unknown")
+		if query := proto.Protocol.PeerInfo; query != nil {
+			if metadata := query(p.ID()); metadata != nil {
+				protoInfo = metadata
+			} else {
+				protoInfo = "handshake"
+			}
+		}
+		info.Protocols[proto.Name] = protoInfo
+	}
+	return info
+}
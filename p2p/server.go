@@ -0,0 +1,698 @@
+package p2p
+
+import (
+	"bcsbs/ethdb"
+	"bcsbs/ethdb/memorydb"
+	"bcsbs/p2p/discover"
+	"bcsbs/p2p/enode"
+	"bytes"
+	"crypto/ecdsa"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+const (
+	defaultDialTimeout   = 15 * time.Second
+	staticRedialInterval = 30 * time.Second
+	dynamicDialInterval  = 5 * time.Second
+
+	// Nodes dialed from the discovery table are not retried within this time.
+	dialHistoryExpiration = 35 * time.Second
+
+	// Up to 1/defaultDialRatio of MaxPeers connections are dialed, the rest
+	// is left for inbound connections.
+	defaultDialRatio = 3
+
+	defaultBanDuration = 10 * time.Minute
+)
+
+type Config struct {
+	// This field must be set to a valid secp256k1 private key.
+	PrivateKey *ecdsa.PrivateKey
+
+	// MaxPeers is the maximum number of peers that can be
+	// connected. It must be greater than zero.
+	MaxPeers int
+
+	Name string
+
+	// If the port is zero, the operating system will pick a port. The
+	// discovery protocol listens on the same port over UDP.
+	ListenAddr string
+
+	// Static nodes are used as pre-configured connections which are always
+	// maintained and re-connected on disconnects.
+	StaticNodes []*enode.Node
+
+	// Trusted nodes are used as pre-configured connections which are always
+	// allowed to connect, even above the peer limit, and are never banned.
+	TrustedNodes []*enode.Node
+
+	// BootstrapNodes are used to establish connectivity
+	// with the rest of the network.
+	BootstrapNodes []*enode.Node
+
+	// NoDiscovery can be used to disable the peer discovery mechanism.
+	NoDiscovery bool
+
+	// NodeDatabase stores previously seen nodes. An in-memory database
+	// is used if nil.
+	NodeDatabase ethdb.KeyValueStore
+
+	// BanDuration is how long peers disconnected for misbehaviour are
+	// refused. Zero means the default of ten minutes.
+	BanDuration time.Duration
+
+	Protocols []Protocol
+}
+
+type connFlag int32
+
+const (
+	dynDialedConn connFlag = 1 << iota
+	staticDialedConn
+	inboundConn
+	trustedConn
+)
+
+type conn struct {
+	fd net.Conn
+	transport
+
+	node  *enode.Node
+	flags connFlag
+	name  string
+	caps  []Cap
+}
+
+func (c *conn) is(f connFlag) bool {
+	flags := connFlag(atomic.LoadInt32((*int32)(&c.flags)))
+	return flags&f != 0
+}
+
+func (c *conn) set(f connFlag, val bool) {
+	for {
+		oldFlags := connFlag(atomic.LoadInt32((*int32)(&c.flags)))
+		flags := oldFlags
+		if val {
+			flags |= f
+		} else {
+			flags &= ^f
+		}
+		if atomic.CompareAndSwapInt32((*int32)(&c.flags), int32(oldFlags), int32(flags)) {
+			return
+		}
+	}
+}
+
+type Server struct {
+	Config
+
+	newTransport func(net.Conn, *ecdsa.PublicKey) transport
+
+	lock         sync.Mutex
+	running      bool
+	listener     net.Listener
+	ourHandshake *protoHandshake
+	self         *enode.Node
+	nodedb       *enode.DB
+	ntab         *discover.UDPv4
+
+	peers   map[enode.ID]*Peer
+	static  map[enode.ID]*enode.Node
+	trusted map[enode.ID]bool
+	banned  map[enode.ID]time.Time
+	dialing map[enode.ID]struct{}
+	history map[enode.ID]time.Time
+	redial  chan struct{}
+
+	quit   chan struct{}
+	loopWG sync.WaitGroup
+}
+
+func (srv *Server) Start() error {
+	srv.lock.Lock()
+	defer srv.lock.Unlock()
+
+	if srv.running {
+		return errServerAlreadyRuns
+	}
+	if srv.PrivateKey == nil {
+		return errors.New("Server.PrivateKey must be set to a non-nil key")
+	}
+	srv.running = true
+
+	if srv.newTransport == nil {
+		srv.newTransport = newRLPX
+	}
+	srv.quit = make(chan struct{})
+	srv.peers = make(map[enode.ID]*Peer)
+	srv.static = make(map[enode.ID]*enode.Node)
+	srv.trusted = make(map[enode.ID]bool)
+	srv.banned = make(map[enode.ID]time.Time)
+	srv.dialing = make(map[enode.ID]struct{})
+	srv.history = make(map[enode.ID]time.Time)
+	srv.redial = make(chan struct{}, 1)
+
+	pubkey := crypto.FromECDSAPub(&srv.PrivateKey.PublicKey)
+	srv.ourHandshake = &protoHandshake{Version: baseProtocolVersion, Name: srv.Name, ID: pubkey[1:]}
+	for _, p := range srv.Protocols {
+		srv.ourHandshake.Caps = append(srv.ourHandshake.Caps, p.cap())
+	}
+
+	var (
+		ip   = net.IPv4(127, 0, 0, 1)
+		port int
+	)
+	if srv.ListenAddr != "" {
+		listener, err := net.Listen("tcp", srv.ListenAddr)
+		if err != nil {
+			srv.running = false
+			return err
+		}
+		srv.listener = listener
+		if tcp, ok := listener.Addr().(*net.TCPAddr); ok {
+			srv.ourHandshake.ListenPort = uint64(tcp.Port)
+			port = tcp.Port
+			if !tcp.IP.IsUnspecified() {
+				ip = tcp.IP
+			}
+		}
+
+		srv.loopWG.Add(1)
+		go srv.listenLoop()
+	}
+	srv.self = enode.NewV4(&srv.PrivateKey.PublicKey, ip, port, port)
+
+	if err := srv.setupDiscovery(); err != nil {
+		srv.running = false
+		if srv.listener != nil {
+			srv.listener.Close()
+		}
+		return err
+	}
+
+	for _, n := range srv.StaticNodes {
+		srv.static[n.ID()] = n
+	}
+	for _, n := range srv.TrustedNodes {
+		srv.trusted[n.ID()] = true
+	}
+
+	srv.loopWG.Add(1)
+	go srv.dialLoop()
+
+	fmt.Println("Started P2P networking", "self", srv.self.URLv4())
+	return nil
+}
+
+func (srv *Server) setupDiscovery() error {
+	if srv.NoDiscovery || srv.listener == nil {
+		return nil
+	}
+	db := srv.NodeDatabase
+	if db == nil {
+		db = memorydb.New()
+	}
+	srv.nodedb = enode.OpenDB(db)
+
+	addr, err := net.ResolveUDPAddr("udp", srv.ListenAddr)
+	if err != nil {
+		return err
+	}
+	conn, err := net.ListenUDP("udp", addr)
+	if err != nil {
+		return err
+	}
+	ntab, err := discover.ListenUDP(conn, srv.self, srv.nodedb, discover.Config{
+		PrivateKey: srv.PrivateKey,
+		Bootnodes:  srv.BootstrapNodes,
+	})
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	srv.ntab = ntab
+	return nil
+}
+
+func (srv *Server) Stop() {
+	srv.lock.Lock()
+	if !srv.running {
+		srv.lock.Unlock()
+		return
+	}
+	srv.running = false
+	close(srv.quit)
+	if srv.listener != nil {
+		srv.listener.Close()
+	}
+	for _, p := range srv.peers {
+		p.Disconnect(DiscQuitting)
+	}
+	srv.lock.Unlock()
+
+	if srv.ntab != nil {
+		srv.ntab.Close()
+		srv.nodedb.Close()
+	}
+	srv.loopWG.Wait()
+}
+
+// Self returns the local node's endpoint information.
+func (srv *Server) Self() *enode.Node {
+	srv.lock.Lock()
+	defer srv.lock.Unlock()
+
+	return srv.self
+}
+
+func (srv *Server) ListenAddress() net.Addr {
+	srv.lock.Lock()
+	defer srv.lock.Unlock()
+
+	if srv.listener == nil {
+		return nil
+	}
+	return srv.listener.Addr()
+}
+
+func (srv *Server) Peers() []*Peer {
+	srv.lock.Lock()
+	defer srv.lock.Unlock()
+
+	peers := make([]*Peer, 0, len(srv.peers))
+	for _, p := range srv.peers {
+		peers = append(peers, p)
+	}
+	sort.Slice(peers, func(i, j int) bool {
+		a, b := peers[i].ID(), peers[j].ID()
+		return bytes.Compare(a[:], b[:]) < 0
+	})
+	return peers
+}
+
+func (srv *Server) PeerCount() int {
+	srv.lock.Lock()
+	defer srv.lock.Unlock()
+
+	return len(srv.peers)
+}
+
+// AddPeer adds the given node to the static node set. The server will
+// connect to it and keep reconnecting on disconnects.
+func (srv *Server) AddPeer(node *enode.Node) {
+	srv.lock.Lock()
+	srv.static[node.ID()] = node
+	srv.lock.Unlock()
+
+	select {
+	case srv.redial <- struct{}{}:
+	default:
+	}
+}
+
+// RemovePeer removes a node from the static node set and disconnects it.
+func (srv *Server) RemovePeer(node *enode.Node) {
+	srv.lock.Lock()
+	defer srv.lock.Unlock()
+
+	delete(srv.static, node.ID())
+	if p := srv.peers[node.ID()]; p != nil {
+		go p.Disconnect(DiscRequested)
+	}
+}
+
+// AddTrustedPeer adds the given node to a reserved trusted list which allows the
+// node to always connect, even if the slot are full.
+func (srv *Server) AddTrustedPeer(node *enode.Node) {
+	srv.lock.Lock()
+	defer srv.lock.Unlock()
+
+	srv.trusted[node.ID()] = true
+	delete(srv.banned, node.ID())
+	if p := srv.peers[node.ID()]; p != nil {
+		p.rw.set(trustedConn, true)
+	}
+}
+
+// RemoveTrustedPeer removes the given node from the trusted peer set.
+func (srv *Server) RemoveTrustedPeer(node *enode.Node) {
+	srv.lock.Lock()
+	defer srv.lock.Unlock()
+
+	delete(srv.trusted, node.ID())
+	if p := srv.peers[node.ID()]; p != nil {
+		p.rw.set(trustedConn, false)
+	}
+}
+
+// banPeer refuses connections to and from the node for the ban duration.
+// The caller must hold srv.lock.
+func (srv *Server) banPeer(id enode.ID) {
+	if srv.trusted[id] {
+		return
+	}
+	duration := srv.BanDuration
+	if duration == 0 {
+		duration = defaultBanDuration
+	}
+	srv.banned[id] = time.Now().Add(duration)
+	fmt.Println("Banned p2p peer", "peer", id.TerminalString(), "duration", duration)
+}
+
+// isBanned reports whether the node is currently banned.
+// The caller must hold srv.lock.
+func (srv *Server) isBanned(id enode.ID) bool {
+	until, ok := srv.banned[id]
+	if ok && time.Now().After(until) {
+		delete(srv.banned, id)
+		return false
+	}
+	return ok
+}
+
+func (srv *Server) maxDialedConns() int {
+	if srv.MaxPeers <= 0 {
+		return 0
+	}
+	limit := srv.MaxPeers / defaultDialRatio
+	if limit == 0 {
+		limit = 1
+	}
+	return limit
+}
+
+func (srv *Server) maxInboundConns() int {
+	return srv.MaxPeers - srv.maxDialedConns()
+}
+
+func (srv *Server) listenLoop() {
+	defer srv.loopWG.Done()
+
+	for {
+		fd, err := srv.listener.Accept()
+		if err != nil {
+			select {
+			case <-srv.quit:
+				return
+			default:
+			}
+			fmt.Println("Failed to accept connection", "err", err)
+			time.Sleep(time.Second)
+			continue
+		}
+		go srv.SetupConn(fd, inboundConn, nil)
+	}
+}
+
+func (srv *Server) dialLoop() {
+	defer srv.loopWG.Done()
+
+	var (
+		static  = time.NewTimer(0)
+		dynamic = time.NewTicker(dynamicDialInterval)
+	)
+	defer static.Stop()
+	defer dynamic.Stop()
+
+	for {
+		select {
+		case <-static.C:
+			static.Reset(staticRedialInterval)
+			srv.dialStatic()
+		case <-srv.redial:
+			srv.dialStatic()
+		case <-dynamic.C:
+			srv.dialDynamic()
+		case <-srv.quit:
+			return
+		}
+	}
+}
+
+func (srv *Server) dialStatic() {
+	srv.lock.Lock()
+	defer srv.lock.Unlock()
+
+	for _, n := range srv.static {
+		srv.startDial(n, staticDialedConn)
+	}
+}
+
+// dialDynamic connects to random nodes from the discovery table until
+// maxDialedConns outbound connections are established.
+func (srv *Server) dialDynamic() {
+	if srv.ntab == nil || srv.maxDialedConns() == 0 {
+		return
+	}
+	buf := make([]*enode.Node, srv.maxDialedConns())
+	n := srv.ntab.ReadRandomNodes(buf)
+
+	srv.lock.Lock()
+	defer srv.lock.Unlock()
+
+	now := time.Now()
+	for id, expiry := range srv.history {
+		if now.After(expiry) {
+			delete(srv.history, id)
+		}
+	}
+	dialed := 0
+	for _, p := range srv.peers {
+		if !p.rw.is(inboundConn) {
+			dialed++
+		}
+	}
+	need := srv.maxDialedConns() - dialed - len(srv.dialing)
+	for _, node := range buf[:n] {
+		if need <= 0 {
+			break
+		}
+		if _, ok := srv.history[node.ID()]; ok {
+			continue
+		}
+		if srv.startDial(node, dynDialedConn) {
+			srv.history[node.ID()] = now.Add(dialHistoryExpiration)
+			need--
+		}
+	}
+}
+
+// startDial launches a dial to n unless it is connected or already being dialed.
+// The caller must hold srv.lock.
+func (srv *Server) startDial(n *enode.Node, flags connFlag) bool {
+	if n.ID() == srv.self.ID() || srv.isBanned(n.ID()) {
+		return false
+	}
+	_, connected := srv.peers[n.ID()]
+	_, dialing := srv.dialing[n.ID()]
+	if connected || dialing {
+		return false
+	}
+	srv.dialing[n.ID()] = struct{}{}
+	go srv.dial(n, flags)
+	return true
+}
+
+func (srv *Server) dial(n *enode.Node, flags connFlag) {
+	defer func() {
+		srv.lock.Lock()
+		delete(srv.dialing, n.ID())
+		srv.lock.Unlock()
+	}()
+
+	fd, err := net.DialTimeout("tcp", n.TCPEndpoint(), defaultDialTimeout)
+	if err != nil {
+		fmt.Println("Failed to dial peer", "id", n.ID().TerminalString(), "addr", n.TCPEndpoint(), "err", err)
+		return
+	}
+	if err := srv.SetupConn(fd, flags, n); err != nil {
+		fmt.Println("Failed to set up peer", "id", n.ID().TerminalString(), "addr", n.TCPEndpoint(), "err", err)
+	}
+}
+
+// SetupConn runs the protocol handshake on fd and registers the new peer.
+// dialDest is the dialed node, nil for inbound connections.
+func (srv *Server) SetupConn(fd net.Conn, flags connFlag, dialDest *enode.Node) error {
+	var dialPubkey *ecdsa.PublicKey
+	if dialDest != nil {
+		dialPubkey = dialDest.Pubkey()
+	}
+	c := &conn{fd: fd, transport: srv.newTransport(fd, dialPubkey), flags: flags}
+
+	srv.lock.Lock()
+	running := srv.running
+	srv.lock.Unlock()
+	if !running {
+		c.close(errServerStopped)
+		return errServerStopped
+	}
+
+	// Run the RLPx handshake. For dialed connections, the remote key is
+	// checked by the key agreement itself, inbound peers prove ownership
+	// of the key they announce.
+	remotePubkey, err := c.doEncHandshake(srv.PrivateKey)
+	if err != nil {
+		fd.Close()
+		return err
+	}
+	if dialDest != nil {
+		c.node = dialDest
+	} else {
+		c.node = nodeFromConn(remotePubkey, fd, 0)
+	}
+
+	phs, err := c.doProtoHandshake(srv.ourHandshake)
+	if err != nil {
+		c.close(err)
+		return err
+	}
+	if id := crypto.FromECDSAPub(c.node.Pubkey())[1:]; !bytes.Equal(id, phs.ID) {
+		c.close(DiscUnexpectedIdentity)
+		return DiscUnexpectedIdentity
+	}
+	if dialDest == nil {
+		c.node = nodeFromConn(remotePubkey, fd, int(phs.ListenPort))
+	}
+	c.name, c.caps = phs.Name, phs.Caps
+
+	srv.lock.Lock()
+	if srv.trusted[c.node.ID()] {
+		c.set(trustedConn, true)
+	}
+	srv.lock.Unlock()
+
+	return srv.addPeer(c)
+}
+
+func nodeFromConn(pubkey *ecdsa.PublicKey, fd net.Conn, listenPort int) *enode.Node {
+	ip := net.IPv4(127, 0, 0, 1)
+	if tcp, ok := fd.RemoteAddr().(*net.TCPAddr); ok {
+		ip = tcp.IP
+	}
+	return enode.NewV4(pubkey, ip, listenPort, listenPort)
+}
+
+func (srv *Server) addPeer(c *conn) error {
+	p := newPeer(c, srv.Protocols)
+
+	srv.lock.Lock()
+	var err error
+	switch {
+	case !srv.running:
+		err = DiscQuitting
+	case c.node.ID() == srv.self.ID():
+		err = DiscSelf
+	case srv.isBanned(c.node.ID()):
+		err = errPeerBanned
+	case !c.is(trustedConn) && len(srv.peers) >= srv.MaxPeers:
+		err = DiscTooManyPeers
+	case !c.is(trustedConn) && c.is(inboundConn) && srv.inboundCount() >= srv.maxInboundConns():
+		err = DiscTooManyPeers
+	case len(p.running) == 0:
+		err = DiscUselessPeer
+	case srv.peers[c.node.ID()] != nil:
+		err = DiscAlreadyConnected
+	}
+	if err != nil {
+		srv.lock.Unlock()
+		c.close(err)
+		return err
+	}
+	srv.peers[c.node.ID()] = p
+	srv.loopWG.Add(1)
+	srv.lock.Unlock()
+
+	fmt.Println("Adding p2p peer", "peer", c.node.ID().TerminalString(), "addr", c.fd.RemoteAddr(), "name", c.name, "inbound", c.is(inboundConn))
+	go srv.runPeer(p)
+	return nil
+}
+
+func (srv *Server) runPeer(p *Peer) {
+	defer srv.loopWG.Done()
+
+	remoteRequested, err := p.run()
+
+	srv.lock.Lock()
+	if srv.peers[p.ID()] == p {
+		delete(srv.peers, p.ID())
+	}
+	if !remoteRequested && err == DiscBadPeer {
+		srv.banPeer(p.ID())
+	}
+	srv.lock.Unlock()
+
+	fmt.Println("Removing p2p peer", "peer", p.ID().TerminalString(), "req", remoteRequested, "err", err)
+}
+
+// inboundCount returns the number of inbound peers.
+// The caller must hold srv.lock.
+func (srv *Server) inboundCount() int {
+	count := 0
+	for _, p := range srv.peers {
+		if p.rw.is(inboundConn) {
+			count++
+		}
+	}
+	return count
+}
+
+// NodeInfo represents a short summary of the information known about the host.
+type NodeInfo struct {
+	ID    string `json:"id"`    // Unique node identifier
+	Name  string `json:"name"`  // Name of the node, including client type, version, OS, custom data
+	Enode string `json:"enode"` // Enode URL for adding this peer from remote peers
+	IP    string `json:"ip"`    // IP address of the node
+	Ports struct {
+		Discovery int `json:"discovery"` // UDP listening port for discovery protocol
+		Listener  int `json:"listener"`  // TCP listening port for RLPx
+	} `json:"ports"`
+	ListenAddr string                 `json:"listenAddr"`
+	Protocols  map[string]interface{} `json:"protocols"`
+}
+
+// NodeInfo gathers and returns a collection of metadata known about the host.
+func (srv *Server) NodeInfo() *NodeInfo {
+	// Gather and assemble the generic node infos
+	node := srv.Self()
+	info := &NodeInfo{
+		Name:       srv.Name,
+		Enode:      node.URLv4(),
+		ID:         node.ID().String(),
+		IP:         node.IP().String(),
+		ListenAddr: srv.ListenAddr,
+		Protocols:  make(map[string]interface{}),
+	}
+	info.Ports.Discovery = node.UDP()
+	info.Ports.Listener = node.TCP()
+
+	// Gather all the running protocol infos (only once per protocol type)
+	for _, proto := range srv.Protocols {
+		if _, ok := info.Protocols[proto.Name]; !ok {
+			nodeInfo := interface{}("unknown")
+			if query := proto.NodeInfo; query != nil {
+				nodeInfo = proto.NodeInfo()
+			}
+			info.Protocols[proto.Name] = nodeInfo
+		}
+	}
+	return info
+}
+
+// PeersInfo returns an array of metadata objects describing connected peers.
+func (srv *Server) PeersInfo() []*PeerInfo {
+	peers := srv.Peers()
+	infos := make([]*PeerInfo, 0, len(peers))
+	for _, peer := range peers {
+		infos = append(infos, peer.Info())
+	}
+	return infos
+}
@@ -0,0 +1,319 @@
+package simulations
+
+import (
+	"bcsbs/core/types"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+const pollInterval = 50 * time.Millisecond
+
+var (
+	errUnknownNode = errors.New("unknown node")
+	errPartitioned = errors.New("nodes are in different partitions")
+	errSameNode    = errors.New("cannot connect a node to itself")
+)
+
+// Network is an in-process network of full nodes. Nodes are linked by
+// in-memory pipes, so simulations run offline, e.g. inside go test.
+type Network struct {
+	Nodes []*Node
+
+	lock  sync.Mutex
+	group []int // partition group of every node
+}
+
+// NewNetwork starts n nodes sharing the default genesis block. The nodes are
+// not connected, use Connect or ConnectAll to link them.
+func NewNetwork(n int) (*Network, error) {
+	network := &Network{group: make([]int, n)}
+	for i := 0; i < n; i++ {
+		node, err := newNode(i, n)
+		if err != nil {
+			network.Close()
+			return nil, err
+		}
+		network.Nodes = append(network.Nodes, node)
+	}
+	return network, nil
+}
+
+func (net *Network) node(i int) (*Node, error) {
+	if i < 0 || i >= len(net.Nodes) {
+		return nil, fmt.Errorf("%w: %d", errUnknownNode, i)
+	}
+	return net.Nodes[i], nil
+}
+
+// Connect links node i and node j over an in-memory pipe, with i acting
+// as the dialer.
+func (net *Network) Connect(i, j int) error {
+	if i == j {
+		return errSameNode
+	}
+	a, err := net.node(i)
+	if err != nil {
+		return err
+	}
+	b, err := net.node(j)
+	if err != nil {
+		return err
+	}
+	net.lock.Lock()
+	partitioned := net.group[i] != net.group[j]
+	net.lock.Unlock()
+	if partitioned {
+		return errPartitioned
+	}
+	if net.connected(a, b) {
+		return nil
+	}
+
+	c1, c2 := newPipe()
+	errc := make(chan error, 2)
+	go func() { errc <- a.Server.SetupConn(c1, 0, b.Server.Self()) }()
+	go func() { errc <- b.Server.SetupConn(c2, 0, nil) }()
+	for k := 0; k < 2; k++ {
+		if err := <-errc; err != nil {
+			return fmt.Errorf("connect %d -> %d: %v", i, j, err)
+		}
+	}
+	return nil
+}
+
+// ConnectAll links every pair of nodes that are in the same partition.
+func (net *Network) ConnectAll() error {
+	for i := range net.Nodes {
+		for j := i + 1; j < len(net.Nodes); j++ {
+			net.lock.Lock()
+			partitioned := net.group[i] != net.group[j]
+			net.lock.Unlock()
+			if partitioned {
+				continue
+			}
+			if err := net.Connect(i, j); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Disconnect drops the link between node i and node j, if any.
+func (net *Network) Disconnect(i, j int) error {
+	a, err := net.node(i)
+	if err != nil {
+		return err
+	}
+	b, err := net.node(j)
+	if err != nil {
+		return err
+	}
+	a.Server.RemovePeer(b.Server.Self())
+	b.Server.RemovePeer(a.Server.Self())
+
+	return net.waitFor(time.Second, func() bool {
+		return !net.connected(a, b) && !net.connected(b, a)
+	})
+}
+
+// Partition splits the network into the given groups of node indices. Links
+// between nodes of different groups are dropped and refused until Heal is
+// called. Nodes not listed form one additional group.
+func (net *Network) Partition(groups ...[]int) error {
+	net.lock.Lock()
+	for i := range net.group {
+		net.group[i] = 0
+	}
+	for g, group := range groups {
+		for _, i := range group {
+			if i < 0 || i >= len(net.group) {
+				net.lock.Unlock()
+				return fmt.Errorf("%w: %d", errUnknownNode, i)
+			}
+			net.group[i] = g + 1
+		}
+	}
+	net.lock.Unlock()
+
+	for i := range net.Nodes {
+		for j := i + 1; j < len(net.Nodes); j++ {
+			if net.group[i] == net.group[j] {
+				continue
+			}
+			if err := net.Disconnect(i, j); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Heal removes all partitions and links every pair of nodes again.
+func (net *Network) Heal() error {
+	net.lock.Lock()
+	for i := range net.group {
+		net.group[i] = 0
+	}
+	net.lock.Unlock()
+
+	return net.ConnectAll()
+}
+
+// InjectTx adds a signed transaction to the pool of node i, from where it
+// is gossiped to the rest of the network.
+func (net *Network) InjectTx(i int, tx *types.Transaction) error {
+	node, err := net.node(i)
+	if err != nil {
+		return err
+	}
+	return node.TxPool.AddLocalAndUpdate(tx)
+}
+
+// WaitTx waits until the pool of node i knows the transaction with the
+// given hash.
+func (net *Network) WaitTx(i int, hash common.Hash, timeout time.Duration) error {
+	node, err := net.node(i)
+	if err != nil {
+		return err
+	}
+	err = net.waitFor(timeout, func() bool {
+		return node.TxPool.Has(hash)
+	})
+	if err != nil {
+		return fmt.Errorf("%v: transaction %x not received: %v", node, hash, err)
+	}
+	return nil
+}
+
+// StartMining makes node i mine blocks for its coinbase.
+func (net *Network) StartMining(i int) error {
+	node, err := net.node(i)
+	if err != nil {
+		return err
+	}
+	node.Miner.Start(node.Coinbase)
+	return nil
+}
+
+// StopMining stops the miner of node i.
+func (net *Network) StopMining(i int) error {
+	node, err := net.node(i)
+	if err != nil {
+		return err
+	}
+	node.Miner.Stop()
+	return nil
+}
+
+// MineBlocks lets node i mine until its head advanced by n blocks. The
+// worker seals one block per start, so mining is restarted for every block.
+func (net *Network) MineBlocks(i int, n uint64, timeout time.Duration) error {
+	node, err := net.node(i)
+	if err != nil {
+		return err
+	}
+	var (
+		target   = node.Head().NumberU64() + n
+		deadline = time.Now().Add(timeout)
+	)
+	defer node.Miner.Stop()
+
+	for number := node.Head().NumberU64(); number < target; number = node.Head().NumberU64() {
+		node.Miner.Start(node.Coinbase)
+		err := net.waitFor(time.Until(deadline), func() bool {
+			return node.Head().NumberU64() > number
+		})
+		if err != nil {
+			return fmt.Errorf("%v: mined to #%d, want #%d: %v", node, node.Head().NumberU64(), target, err)
+		}
+	}
+	return nil
+}
+
+// Heads returns the current head block of every node.
+func (net *Network) Heads() []*types.Block {
+	heads := make([]*types.Block, len(net.Nodes))
+	for i, node := range net.Nodes {
+		heads[i] = node.Head()
+	}
+	return heads
+}
+
+// Converged reports whether all nodes have the same head block.
+func (net *Network) Converged() bool {
+	heads := net.Heads()
+	for _, head := range heads[1:] {
+		if head.Hash() != heads[0].Hash() {
+			return false
+		}
+	}
+	return true
+}
+
+// WaitConverged waits until all nodes have the same head block, returning
+// an error describing the heads if they did not converge within timeout.
+func (net *Network) WaitConverged(timeout time.Duration) error {
+	if err := net.waitFor(timeout, net.Converged); err != nil {
+		var heads []string
+		for i, head := range net.Heads() {
+			heads = append(heads, fmt.Sprintf("node %d: #%d [%x..]", i, head.NumberU64(), head.Hash().Bytes()[:4]))
+		}
+		return fmt.Errorf("heads did not converge: %s", strings.Join(heads, ", "))
+	}
+	return nil
+}
+
+// Close stops all nodes.
+func (net *Network) Close() {
+	for _, node := range net.Nodes {
+		node.close()
+	}
+}
+
+func (net *Network) connected(a, b *Node) bool {
+	id := b.Server.Self().ID()
+	for _, p := range a.Server.Peers() {
+		if p.ID() == id {
+			return true
+		}
+	}
+	return false
+}
+
+func (net *Network) waitFor(timeout time.Duration, cond func() bool) error {
+	deadline := time.Now().Add(timeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			return errors.New("timed out")
+		}
+		time.Sleep(pollInterval)
+	}
+	return nil
+}
+
+// newPipe creates an in-memory full-duplex connection. The returned
+// connections report TCP addresses so peers look like regular network peers.
+func newPipe() (net.Conn, net.Conn) {
+	c1, c2 := net.Pipe()
+	return &pipeConn{c1, pipeAddr(1)}, &pipeConn{c2, pipeAddr(2)}
+}
+
+type pipeConn struct {
+	net.Conn
+	remote *net.TCPAddr
+}
+
+func (c *pipeConn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+func pipeAddr(port int) *net.TCPAddr {
+	return &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: port}
+}
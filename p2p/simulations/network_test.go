@@ -0,0 +1,122 @@
+package simulations
+
+import (
+	"bcsbs/core/types"
+	"bcsbs/params"
+	"math/big"
+	"testing"
+	"time"
+)
+
+func newTestNetwork(t *testing.T, n int) *Network {
+	if testing.Short() {
+		t.Skip("skipping network simulation in short mode")
+	}
+	network, err := NewNetwork(n)
+	if err != nil {
+		t.Fatalf("failed to start network: %v", err)
+	}
+	t.Cleanup(network.Close)
+
+	if err := network.ConnectAll(); err != nil {
+		t.Fatalf("failed to connect nodes: %v", err)
+	}
+	return network
+}
+
+func TestNetworkConverges(t *testing.T) {
+	network := newTestNetwork(t, 4)
+
+	if err := network.MineBlocks(0, 3, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if err := network.WaitConverged(10 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+	for i, head := range network.Heads() {
+		if head.NumberU64() < 3 {
+			t.Errorf("node %d: head #%d, want at least #3", i, head.NumberU64())
+		}
+	}
+}
+
+func TestNetworkPartitionReorg(t *testing.T) {
+	network := newTestNetwork(t, 4)
+
+	// Fund the account of node 0 and wait for everyone to follow
+	if err := network.MineBlocks(0, 3, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if err := network.WaitConverged(10 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	// Split the network and mine competing chains, the second one heavier
+	if err := network.Partition([]int{0, 1}, []int{2, 3}); err != nil {
+		t.Fatal(err)
+	}
+	sender, receiver := network.Nodes[0], network.Nodes[3]
+	tx, err := types.SignTx(types.NewTransaction(0, receiver.Coinbase, big.NewInt(1000), nil), types.LatestSigner(params.DefaultChainConfig), sender.AccountKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := network.InjectTx(1, tx); err != nil {
+		t.Fatal(err)
+	}
+	if err := network.WaitTx(0, tx.Hash(), 10*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if err := network.MineBlocks(0, 2, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if err := network.MineBlocks(2, 3, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	light, heavy := network.Nodes[0].Head(), network.Nodes[2].Head()
+	if light.Hash() == heavy.Hash() {
+		t.Fatalf("partitions share head #%d", heavy.NumberU64())
+	}
+	if receipt, _, _, _ := network.Nodes[0].Chain.GetReceipt(tx.Hash()); receipt == nil {
+		t.Fatal("transaction not included on the light side")
+	}
+
+	// After healing, the light side reorganises onto the heavy chain
+	if err := network.Heal(); err != nil {
+		t.Fatal(err)
+	}
+	if err := network.WaitConverged(30 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+	for i, head := range network.Heads() {
+		if head.Hash() != heavy.Hash() {
+			t.Fatalf("node %d: head #%d [%x..], want #%d [%x..]", i, head.NumberU64(), head.Hash().Bytes()[:4], heavy.NumberU64(), heavy.Hash().Bytes()[:4])
+		}
+	}
+	if block := network.Nodes[0].Chain.GetBlockByNumber(light.NumberU64()); block != nil && block.Hash() == light.Hash() {
+		t.Fatalf("light head #%d still canonical", light.NumberU64())
+	}
+
+	// The dropped transaction is back in the pool, announced to the heavy
+	// side that never saw it and lands on the new chain
+	if err := network.WaitTx(0, tx.Hash(), 10*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if err := network.WaitTx(2, tx.Hash(), 10*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if err := network.MineBlocks(0, 1, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if err := network.WaitConverged(30 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+	for _, node := range network.Nodes {
+		receipt, _, _, _ := node.Chain.GetReceipt(tx.Hash())
+		if receipt == nil || receipt.Status != types.ReceiptStatusSuccessful {
+			t.Errorf("%v: missing or failed receipt %v", node, receipt)
+		}
+		if balance := node.StateDB.GetBalance(receiver.Coinbase); balance.Cmp(big.NewInt(1000)) != 0 {
+			t.Errorf("%v: receiver balance %v, want 1000", node, balance)
+		}
+	}
+}
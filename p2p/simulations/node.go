@@ -0,0 +1,142 @@
+package simulations
+
+import (
+	"bcsbs/consensus/ethash"
+	"bcsbs/core"
+	"bcsbs/core/rawdb"
+	"bcsbs/core/state"
+	"bcsbs/core/types"
+	"bcsbs/eth"
+	"bcsbs/ethdb"
+	"bcsbs/miner"
+	"bcsbs/p2p"
+	"bcsbs/trie"
+	"crypto/ecdsa"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// Node is a full node running inside the simulation. Every node has its own
+// in-memory database, chain, transaction pool and miner.
+type Node struct {
+	Index int
+
+	NodeKey    *ecdsa.PrivateKey // p2p identity
+	AccountKey *ecdsa.PrivateKey // account receiving the mining rewards
+	Coinbase   common.Address
+
+	DB      ethdb.Database
+	StateDB *state.StateDB
+	Chain   *core.BlockChain
+	TxPool  *core.TxPool
+	Miner   *miner.Miner
+	Handler *eth.Handler
+	Server  *p2p.Server
+}
+
+type backend struct {
+	bc   *core.BlockChain
+	pool *core.TxPool
+}
+
+func (b *backend) BlockChain() *core.BlockChain {
+	return b.bc
+}
+func (b *backend) TxPool() *core.TxPool {
+	return b.pool
+}
+
+func newNode(index int, maxPeers int) (*Node, error) {
+	nodeKey, err := crypto.GenerateKey()
+	if err != nil {
+		return nil, err
+	}
+	accountKey, err := crypto.GenerateKey()
+	if err != nil {
+		return nil, err
+	}
+
+	genesis := core.DefaultGenesisBlock()
+	engine := ethash.New(genesis.Config.EthashConfig())
+
+	db := rawdb.NewMemoryDatabase()
+	tx_trie, err := trie.NewTxTrie(db)
+	if err != nil {
+		return nil, err
+	}
+	storage_trie, err := trie.NewStorageTrie(db)
+	if err != nil {
+		return nil, err
+	}
+	statedb, err := state.New(tx_trie, storage_trie)
+	if err != nil {
+		return nil, err
+	}
+
+	bc, err := core.NewBlockChain(db, nil, engine, genesis, statedb)
+	if err != nil {
+		return nil, err
+	}
+	pool := core.NewTxPool(bc, types.LatestSigner(bc.Config()))
+	miner := miner.New(&backend{bc: bc, pool: pool}, engine)
+
+	handler := eth.NewHandler(&eth.Config{
+		NetworkID: eth.DefaultNetworkID,
+		Chain:     bc,
+		TxPool:    pool,
+		Engine:    engine,
+		Miner:     miner,
+	})
+	handler.Start()
+
+	server := &p2p.Server{
+		Config: p2p.Config{
+			PrivateKey:   nodeKey,
+			MaxPeers:     maxPeers,
+			Name:         fmt.Sprintf("sim-%d", index),
+			NoDiscovery:  true,
+			NodeDatabase: db,
+			Protocols:    handler.Protocols(),
+		},
+	}
+	if err := server.Start(); err != nil {
+		handler.Stop()
+		miner.Close()
+		pool.Stop()
+		bc.Stop()
+		return nil, err
+	}
+
+	return &Node{
+		Index:      index,
+		NodeKey:    nodeKey,
+		AccountKey: accountKey,
+		Coinbase:   crypto.PubkeyToAddress(accountKey.PublicKey),
+		DB:         db,
+		StateDB:    statedb,
+		Chain:      bc,
+		TxPool:     pool,
+		Miner:      miner,
+		Handler:    handler,
+		Server:     server,
+	}, nil
+}
+
+// Head returns the current head block of the node.
+func (n *Node) Head() *types.Block {
+	return n.Chain.CurrentBlock()
+}
+
+func (n *Node) String() string {
+	return fmt.Sprintf("node %d (%s)", n.Index, n.Server.Self().ID().TerminalString())
+}
+
+func (n *Node) close() {
+	n.Server.Stop()
+	n.Handler.Stop()
+	n.Miner.Close()
+	n.TxPool.Stop()
+	n.Chain.Stop()
+}
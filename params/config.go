@@ -0,0 +1,215 @@
+package params
+
+import (
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+var (
+	// DefaultEthashConfig is the proof-of-work configuration used when the
+	// chain config specifies none.
+	DefaultEthashConfig = &EthashConfig{
+		BlockReward: big.NewInt(5e+18),
+		Difficulty:  big.NewInt(4096),
+	}
+
+	// DefaultChainConfig is the chain config used when the genesis specifies none.
+	DefaultChainConfig = &ChainConfig{
+		ChainID:        big.NewInt(1337),
+		HomesteadBlock: big.NewInt(0),
+		EIP155Block:    big.NewInt(0),
+		Ethash:         DefaultEthashConfig,
+	}
+)
+
+// ChainConfig is the core config which determines the blockchain settings.
+// It is stored in the database keyed by the genesis hash.
+//
+// Forks are activated at the given block number, nil means the fork is not
+// scheduled and 0 that the chain starts with it.
+type ChainConfig struct {
+	ChainID *big.Int `json:"chainId"` // identifies the chain, used for replay protection
+
+	HomesteadBlock *big.Int `json:"homesteadBlock,omitempty"` // Homestead switch block: canonical signatures, ADD and EQ opcodes
+	EIP155Block    *big.Int `json:"eip155Block,omitempty"`    // EIP155 switch block: replay protected signatures
+
+	Ethash *EthashConfig `json:"ethash,omitempty"`
+
+	// Checkpoints pins the hashes of canonical blocks by number. Blocks not
+	// matching the checkpoint of their number are rejected, and the chain is
+	// never reorganised below the highest checkpoint it has reached.
+	Checkpoints map[uint64]common.Hash `json:"checkpoints,omitempty"`
+
+	// MaxReorgDepth is the maximum number of canonical blocks a
+	// reorganisation may replace, zero means unlimited.
+	MaxReorgDepth uint64 `json:"maxReorgDepth,omitempty"`
+
+	// CheckpointSigners are the addresses whose signed checkpoints are
+	// accepted in addition to the configured ones. None disables signed
+	// checkpoints.
+	CheckpointSigners []common.Address `json:"checkpointSigners,omitempty"`
+}
+
+// EthashConfig is the consensus engine config for proof-of-work based
+// sealing.
+type EthashConfig struct {
+	BlockReward *big.Int `json:"blockReward"` // reward credited to the coinbase of every block
+	Difficulty  *big.Int `json:"difficulty"`  // fixed difficulty of every block after the genesis
+
+	// FutureBlockTime is the number of seconds a block timestamp may be
+	// ahead of the local clock. Such blocks are imported once their time
+	// arrives, blocks further ahead are rejected. Zero means the default of
+	// DefaultFutureBlockTime.
+	FutureBlockTime uint64 `json:"futureBlockTime,omitempty"`
+}
+
+func (c *EthashConfig) String() string {
+	return fmt.Sprintf("{BlockReward: %v Difficulty: %v FutureBlockTime: %v}", c.BlockReward, c.Difficulty, c.FutureBlockTimeLimit())
+}
+
+// FutureBlockTimeLimit returns the number of seconds a block timestamp may be
+// ahead of the local clock.
+func (c *EthashConfig) FutureBlockTimeLimit() uint64 {
+	if c.FutureBlockTime == 0 {
+		return DefaultFutureBlockTime
+	}
+	return c.FutureBlockTime
+}
+
+func (c *ChainConfig) String() string {
+	return fmt.Sprintf("{ChainID: %v Homestead: %v EIP155: %v Ethash: %v Checkpoints: %d MaxReorgDepth: %d CheckpointSigners: %d}",
+		c.ChainID, c.HomesteadBlock, c.EIP155Block, c.EthashConfig(), len(c.Checkpoints), c.MaxReorgDepth, len(c.CheckpointSigners))
+}
+
+// EthashConfig returns the proof-of-work config, the default one if the
+// chain config has none.
+func (c *ChainConfig) EthashConfig() *EthashConfig {
+	if c.Ethash == nil {
+		return DefaultEthashConfig
+	}
+	return c.Ethash
+}
+
+// IsCheckpointSigner returns whether checkpoints signed by addr are accepted.
+func (c *ChainConfig) IsCheckpointSigner(addr common.Address) bool {
+	for _, signer := range c.CheckpointSigners {
+		if signer == addr {
+			return true
+		}
+	}
+	return false
+}
+
+// IsHomestead returns whether num is either equal to the homestead block or greater.
+func (c *ChainConfig) IsHomestead(num *big.Int) bool {
+	return isForked(c.HomesteadBlock, num)
+}
+
+// IsEIP155 returns whether num is either equal to the EIP155 fork block or greater.
+func (c *ChainConfig) IsEIP155(num *big.Int) bool {
+	return isForked(c.EIP155Block, num)
+}
+
+// CheckCompatible checks whether scheduled fork transitions have been
+// imported with a mismatching chain configuration.
+func (c *ChainConfig) CheckCompatible(newcfg *ChainConfig, height uint64) *ConfigCompatError {
+	head := new(big.Int).SetUint64(height)
+
+	if isForkIncompatible(c.HomesteadBlock, newcfg.HomesteadBlock, head) {
+		return newCompatError("Homestead fork block", c.HomesteadBlock, newcfg.HomesteadBlock)
+	}
+	if isForkIncompatible(c.EIP155Block, newcfg.EIP155Block, head) {
+		return newCompatError("EIP155 fork block", c.EIP155Block, newcfg.EIP155Block)
+	}
+	if c.IsEIP155(head) && !configNumEqual(c.ChainID, newcfg.ChainID) {
+		return newCompatError("EIP155 chain ID", c.EIP155Block, newcfg.EIP155Block)
+	}
+	// The engine parameters have no fork block, they apply to every block
+	// after the genesis
+	if height > 0 {
+		stored, ethash := c.EthashConfig(), newcfg.EthashConfig()
+		if !configNumEqual(stored.BlockReward, ethash.BlockReward) || !configNumEqual(stored.Difficulty, ethash.Difficulty) {
+			return newCompatError("ethash parameters", big.NewInt(1), big.NewInt(1))
+		}
+	}
+	return nil
+}
+
+// isForkIncompatible returns true if a fork scheduled at s1 cannot be
+// rescheduled to block s2 because head is already past the fork.
+func isForkIncompatible(s1, s2, head *big.Int) bool {
+	return (isForked(s1, head) || isForked(s2, head)) && !configNumEqual(s1, s2)
+}
+
+// isForked returns whether a fork scheduled at block s is active at the
+// given head block.
+func isForked(s, head *big.Int) bool {
+	if s == nil || head == nil {
+		return false
+	}
+	return s.Cmp(head) <= 0
+}
+
+func configNumEqual(x, y *big.Int) bool {
+	if x == nil {
+		return y == nil
+	}
+	if y == nil {
+		return x == nil
+	}
+	return x.Cmp(y) == 0
+}
+
+// ConfigCompatError is raised if the locally-stored blockchain is initialised
+// with a ChainConfig that would alter the past.
+type ConfigCompatError struct {
+	What string
+	// block numbers of the stored and new configurations
+	StoredConfig, NewConfig *big.Int
+	// the block number to which the local chain must be rewound to correct
+	// the error
+	RewindTo uint64
+}
+
+func newCompatError(what string, storedblock, newblock *big.Int) *ConfigCompatError {
+	var rew *big.Int
+	switch {
+	case storedblock == nil:
+		rew = newblock
+	case newblock == nil || storedblock.Cmp(newblock) < 0:
+		rew = storedblock
+	default:
+		rew = newblock
+	}
+	err := &ConfigCompatError{what, storedblock, newblock, 0}
+	if rew != nil && rew.Sign() > 0 {
+		err.RewindTo = rew.Uint64() - 1
+	}
+	return err
+}
+
+func (err *ConfigCompatError) Error() string {
+	return fmt.Sprintf("mismatching %s in database (have %d, want %d, rewindto %d)", err.What, err.StoredConfig, err.NewConfig, err.RewindTo)
+}
+
+// Rules wraps ChainConfig and is merely syntactic sugar or can be used for
+// functions that do not have or require information about the block.
+type Rules struct {
+	ChainID               *big.Int
+	IsHomestead, IsEIP155 bool
+}
+
+// Rules ensures c's ChainID is not nil.
+func (c *ChainConfig) Rules(num *big.Int) Rules {
+	chainID := c.ChainID
+	if chainID == nil {
+		chainID = new(big.Int)
+	}
+	return Rules{
+		ChainID:     new(big.Int).Set(chainID),
+		IsHomestead: c.IsHomestead(num),
+		IsEIP155:    c.IsEIP155(num),
+	}
+}
@@ -0,0 +1,18 @@
+package params
+
+const (
+	GenesisGasLimit uint64 = 4712388 // Gas limit of the genesis block, kept by all later blocks.
+
+	MaximumExtraDataSize  uint64 = 32    // Maximum size extra data may be after Genesis.
+	TxGas                 uint64 = 21000 // Per transaction not creating a contract.
+	TxGasContractCreation uint64 = 53000 // Per transaction that creates a contract.
+	TxDataZeroGas         uint64 = 4     // Per byte of data attached to a transaction that equals zero.
+	TxDataNonZeroGas      uint64 = 16    // Per byte of data attached to a transaction that is not equal to zero.
+
+	DefaultFutureBlockTime uint64 = 15 // Seconds a block timestamp may be ahead of the local clock if the chain config sets no limit.
+)
+
+// FullImmutabilityThreshold is the number of blocks after which a chain segment is
+// considered immutable (i.e. soft finality). It is used by the freezer as the
+// number of recent blocks kept in the key-value store.
+const FullImmutabilityThreshold = 90000
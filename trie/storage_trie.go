@@ -4,11 +4,14 @@ import (
 	"bcsbs/core/rawdb"
 	"bcsbs/ethdb"
 	"fmt"
+	"sync"
 )
 
 type StorageTrie struct {
 	cache map[string][]byte
 	db    ethdb.Database
+
+	lock sync.Mutex
 }
 
 func NewStorageTrie(db ethdb.Database) (*StorageTrie, error) {
@@ -25,8 +28,13 @@ func get_key(root, key []byte) string {
 }
 
 func (t *StorageTrie) TryGet(key []byte) ([]byte, error) {
-	res := t.cache[string(key)]
-	if res != nil {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if res, ok := t.cache[string(key)]; ok {
+		if res == nil {
+			return nil, fmt.Errorf("Not find key")
+		}
 		return res, nil
 	}
 
@@ -43,10 +51,44 @@ func (t *StorageTrie) TryGet(key []byte) ([]byte, error) {
 }
 
 func (t *StorageTrie) TryUpdate(key, value []byte) error {
+	return t.TryUpdateTo(t.db, key, value)
+}
+
+func (t *StorageTrie) TryDelete(key []byte) error {
+	return t.TryDeleteTo(t.db, key)
+}
+
+// TryUpdateTo updates the slot in the cache and writes it into w.
+func (t *StorageTrie) TryUpdateTo(w ethdb.KeyValueWriter, key, value []byte) error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
 	if t.db != nil {
-		rawdb.WriteStorage(t.db, key, value)
+		rawdb.WriteStorage(w, key, value)
 	}
 
 	t.cache[string(key)] = value
 	return nil
 }
+
+// TryDeleteTo deletes the slot from the cache and from w. The cache keeps the
+// deletion, the database may still hold the slot until w is written.
+func (t *StorageTrie) TryDeleteTo(w ethdb.KeyValueWriter, key []byte) error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if t.db != nil {
+		rawdb.DeleteStorage(w, key)
+	}
+
+	t.cache[string(key)] = nil
+	return nil
+}
+
+// Purge drops the cache, values are read from the database again.
+func (t *StorageTrie) Purge() {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.cache = make(map[string][]byte)
+}
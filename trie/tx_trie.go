@@ -5,6 +5,7 @@ import (
 	"bcsbs/core/types"
 	"bcsbs/ethdb"
 	"fmt"
+	"sync"
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/rlp"
@@ -13,6 +14,8 @@ import (
 type TxTrie struct {
 	cache map[string][]byte
 	db    ethdb.Database
+
+	lock sync.Mutex
 }
 
 func NewTxTrie(db ethdb.Database) (*TxTrie, error) {
@@ -42,8 +45,13 @@ func StateAccount2Byte(acc *types.StateAccount) ([]byte, error) {
 }
 
 func (t *TxTrie) TryGet(key []byte) ([]byte, error) {
-	res := t.cache[string(key)]
-	if res != nil {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if res, ok := t.cache[string(key)]; ok {
+		if res == nil {
+			return nil, fmt.Errorf("Not find key")
+		}
 		return res, nil
 	}
 
@@ -62,10 +70,44 @@ func (t *TxTrie) TryGet(key []byte) ([]byte, error) {
 }
 
 func (t *TxTrie) TryUpdate(key []byte, value []byte) error {
+	return t.TryUpdateTo(t.db, key, value)
+}
+
+func (t *TxTrie) TryDelete(key []byte) error {
+	return t.TryDeleteTo(t.db, key)
+}
+
+// TryUpdateTo updates the account in the cache and writes it into w.
+func (t *TxTrie) TryUpdateTo(w ethdb.KeyValueWriter, key []byte, value []byte) error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
 	if t.db != nil {
-		rawdb.WriteAccountData(t.db, common.BytesToAddress(key), value)
+		rawdb.WriteAccountData(w, common.BytesToAddress(key), value)
 	}
 
 	t.cache[string(key)] = value
 	return nil
 }
+
+// TryDeleteTo deletes the account from the cache and from w. The cache keeps
+// the deletion, the database may still hold the account until w is written.
+func (t *TxTrie) TryDeleteTo(w ethdb.KeyValueWriter, key []byte) error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if t.db != nil {
+		rawdb.DeleteAccountData(w, common.BytesToAddress(key))
+	}
+
+	t.cache[string(key)] = nil
+	return nil
+}
+
+// Purge drops the cache, values are read from the database again.
+func (t *TxTrie) Purge() {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.cache = make(map[string][]byte)
+}